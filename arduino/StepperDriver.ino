@@ -36,6 +36,11 @@ const unsigned int POS_FACTOR_LOG = 5; // log base 2 of POS_FACTOR, used after m
 const char RESET_COMMAND = 0x80; // -128, command to reset
 const char PENUP_COMMAND = 0x81; // -127, command to lift pen
 const char PENDOWN_COMMAND = 0x7F; // 127, command to lower pen
+const unsigned char STEPS_MAX_VALUE = 126; // largest delta in a single slice, larger values are commands
+
+const unsigned char PROTOCOL_VERSION = 1; // version of the protocol, reported to the host
+const unsigned char CAPABILITIES_HEADER = 0xC1; // sent before the constants, never a valid data request
+boolean previousWasReset = false; // the last value read was a reset that was not replied to
 
 const unsigned int MOVE_DATA_CAPACITY = 1024;
 char moveData[MOVE_DATA_CAPACITY]; // buffer of move data, circular buffer
@@ -296,8 +301,17 @@ void ReadSerialMoveData() {
       moveDataRequestPending = 0;
       moveDataLength = 0;
       UpdateReceiveLed(false);
+
+      // the host sends two resets in a row to ask for the constants
+      if (previousWasReset) {
+        SendCapabilities();
+        previousWasReset = false;
+      } else {
+        previousWasReset = true;
+      }
       return;
     }
+    previousWasReset = false;
 
     MoveDataPut(value);
     moveDataRequestPending--;
@@ -308,6 +322,26 @@ void ReadSerialMoveData() {
   }
 }
 
+// Report the constants the host must generate data with, all values are little endian
+// --------------------------------------
+void SendCapabilities() {
+#ifdef ENABLE_PENUP
+  unsigned long cooldown = PENUP_COOLDOWN_US;
+#else
+  unsigned long cooldown = 0;
+#endif
+
+  Serial.write(CAPABILITIES_HEADER);
+  Serial.write(PROTOCOL_VERSION);
+  Serial.write(TIME_SLICE_US & 0xFF);
+  Serial.write(TIME_SLICE_US >> 8);
+  Serial.write(POS_FACTOR);
+  Serial.write(STEPS_MAX_VALUE);
+  for (int byteIndex = 0; byteIndex < 4; byteIndex++) {
+    Serial.write((cooldown >> (8 * byteIndex)) & 0xFF);
+  }
+}
+
 // Put a value onto the end of the move data buffer
 // --------------------------------------
 void MoveDataPut(char value) {
@@ -7,9 +7,11 @@ import (
 	. "github.com/brandonagr/gocupi/polargraph"
 	"github.com/qpliu/qrencode-go/qrencode"
 	"math"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // set flag usage variable so that entire help will be output
@@ -19,25 +21,104 @@ func init() {
 
 // main
 func main() {
-	Settings.Read()
-
+	configFlag := flag.String("config", "", "Path of the settings file, instead of searching for gocupi_config.xml")
+	var overrides SettingOverrides
+	flag.Var(&overrides, "set", "Override a setting from gocupi_config.xml as Field=value, can be repeated")
+	meshFlag := flag.Bool("mesh", true, "Correct positions with the CorrectionMesh in gocupi_config.xml, -mesh=false to draw without it")
+	profileFlag := flag.String("profile", "", "Name of the profile in gocupi_config.xml to use, defaults to the file's DefaultProfile")
 	pauseOnPenUp := flag.Bool("pause", false, "Pause when pen is raised (requires keyboard input)")
 	toImageFlag := flag.Bool("toimage", false, "Output result to an image file instead of to the stepper")
 	toFileFlag := flag.Bool("tofile", false, "Output steps to a text file")
 	toChartFlag := flag.Bool("tochart", false, "Output a chart of the movement and velocity")
 	countFlag := flag.Bool("count", false, "Outputs the time it would take to draw")
 	speedSlowFactor := flag.Float64("slowfactor", 1.0, "Divide max speed by this number")
-	flipXFlag := flag.Bool("flipx", false, "Flip the drawing left to right")
-	flipYFlag := flag.Bool("flipy", false, "Flip the drawing top to bottom")
+	drawSpeedFlag := flag.Float64("drawspeed", 0, "Max speed while drawing in mm/s, overrides gocupi_config.xml")
+	drawAccelFlag := flag.Float64("drawaccel", 0, "Acceleration while drawing in mm/s^2, overrides gocupi_config.xml")
+	travelSpeedFlag := flag.Float64("travelspeed", 0, "Max speed while the pen is up in mm/s, overrides gocupi_config.xml")
+	travelAccelFlag := flag.Float64("travelaccel", 0, "Acceleration while the pen is up in mm/s^2, overrides gocupi_config.xml")
+	fitFlag := flag.Bool("fit", false, "Scale the drawing to fill the drawing surface")
+	anchorFlag := flag.String("anchor", "", "Place the drawing on the drawing surface instead of relative to the pen")
+	marginFlag := flag.String("margin", "0", "Distance to keep from the edges of the drawing surface")
+	uncheckedFlag := flag.Bool("unchecked", false, "Send the drawing even if it runs off the drawing surface, without buffering it first")
+	var filters FilterChain
+	filters.AddFlags(flag.CommandLine)
+	transportFlag := flag.String("transport", "", "Connection to the stepper driver as type:address, overrides gocupi_config.xml")
 	flag.Parse()
 
+	// resume rebuilds the interrupted job from the command line it was started with
+	jobArgs := os.Args[1:]
+	var resumeJournal *JobJournal
+	if flag.NArg() > 0 && flag.Arg(0) == "resume" {
+		var err error
+		if resumeJournal, err = ReadJobJournal(JournalFile); err != nil {
+			fmt.Println("ERROR: No job to resume,", err)
+			return
+		}
+		jobArgs = resumeJournal.Args
+		if err = resumeJournal.ParseArgs(flag.CommandLine); err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+	}
+
+	// settings are read once the profile is known, which a resumed job takes from its own command line
+	SettingsPath = *configFlag
+	CorrectionMeshEnabled = *meshFlag
+	SettingsProfile = *profileFlag
+	if err := Settings.Read(); err != nil {
+		fmt.Println("Error reading settings:", err)
+		return
+	}
+	if err := overrides.Apply(&Settings); err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+
+	if resumeJournal != nil {
+		// the job's 0,0 is where the pen was when it was first started
+		origin := resumeJournal.Origin()
+		Settings.StartingLeftDist_MM = origin.LeftDist
+		Settings.StartingRightDist_MM = origin.RightDist
+		fmt.Println("Resuming", strings.Join(jobArgs, " "), "from coordinate", resumeJournal.ResumeIndex)
+	}
+
+	if *transportFlag != "" {
+		transportType, address, err := ParseTransportSpec(*transportFlag)
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+		Settings.Transport = transportType
+		Settings.TransportAddress = address
+	}
+
+	placement := Placement{Fit: *fitFlag, Anchor: strings.ToLower(*anchorFlag), Unchecked: *uncheckedFlag}
+	var err error
+	if placement.Margins, err = ParseMargins(*marginFlag); err == nil {
+		err = placement.Validate()
+	}
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+
 	if *speedSlowFactor < 1.0 {
 		panic("slowfactor must be greater than 1")
 	}
-	// apply slow factor to max speed
-	Settings.MaxSpeed_MM_S /= *speedSlowFactor
-	Settings.Acceleration_Seconds *= *speedSlowFactor
-	Settings.Acceleration_MM_S2 /= *speedSlowFactor
+	// apply slow factor to max speed, then the speeds given for this job
+	Settings.SlowDown(*speedSlowFactor)
+	if *drawSpeedFlag > 0 {
+		Settings.DrawSpeed_MM_S = *drawSpeedFlag
+	}
+	if *drawAccelFlag > 0 {
+		Settings.DrawAcceleration_MM_S2 = *drawAccelFlag
+	}
+	if *travelSpeedFlag > 0 {
+		Settings.TravelSpeed_MM_S = *travelSpeedFlag
+	}
+	if *travelAccelFlag > 0 {
+		Settings.TravelAcceleration_MM_S2 = *travelAccelFlag
+	}
 
 	args := flag.Args()
 	if len(args) < 1 {
@@ -45,8 +126,15 @@ func main() {
 		return
 	}
 
-	plotCoords := make(chan Coordinate, 1024)
-	var err error
+	// setup is how impossible settings get fixed, so it checks them after making its changes
+	if args[0] != "help" && args[0] != "setup" {
+		if err := Settings.Validate(); err != nil {
+			fmt.Println("ERROR: Invalid settings,", err)
+			fmt.Println("Fix them in the settings file, with setup, or with -set Field=value")
+			return
+		}
+	}
+
 	var params []float64
 
 	switch args[0] {
@@ -59,6 +147,297 @@ func main() {
 		}
 		return
 
+	case "serve":
+		address := "localhost:8080"
+		if len(args) > 1 {
+			address = args[1]
+		}
+		builder := func(jobArgs []string, start func(generate func())) (<-chan Coordinate, error) {
+			plotCoords, err := createPlotCoords(jobArgs, start)
+			if err == errUnknownCommand {
+				return nil, errors.New(fmt.Sprint("Unknown command ", jobArgs[0], ", GET /commands for the list of commands"))
+			} else if err != nil {
+				return nil, err
+			}
+			return PlaceDrawing(placement, filters.Apply(plotCoords))
+		}
+
+		fmt.Println("Serving on", address)
+		server := NewPlotServer(builder, CommandHelp, "gocupi_uploads")
+		server.SavePosition = true
+		if err := server.ListenAndServe(address); err != nil {
+			fmt.Println("ERROR: ", err)
+		}
+		return
+
+	case "emulate":
+		realtime := len(args) < 2 || strings.ToLower(args[1]) != "fast"
+		emulator := NewStepperEmulator(realtime)
+		devicePath, err := ServeEmulatorOnPty(emulator)
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+		fmt.Println("Emulating StepperDriver on", devicePath)
+		fmt.Println("Run gocupi with -transport=serial:" + devicePath + " to send it data")
+
+		previousState := emulator.State()
+		for {
+			time.Sleep(2 * time.Second)
+			state := emulator.State()
+			if state != previousState {
+				fmt.Println("Resets", state.Resets, "Slices", state.Slices, "Pen Transitions", state.PenTransitions, "PenUp", state.PenUp,
+					"Left Steps", float64(state.LeftPos)/StepsFixedPointFactor, "Right Steps", float64(state.RightPos)/StepsFixedPointFactor,
+					"Time", state.SimulatedTime)
+				previousState = state
+			}
+		}
+
+	case "control":
+		if len(args) != 2 {
+			fmt.Println("ERROR: Expected a single control command")
+			fmt.Println()
+			PrintCommandHelp("control")
+			return
+		}
+		status, err := SendControlCommand(Settings.ControlSocket, args[1])
+		if err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+		fmt.Println("Job", status)
+		return
+
+	case "move":
+		PerformMouseTracking()
+		return
+
+	case "jog":
+		PerformKeyboardJog()
+		return
+
+	case "setup":
+		if params, err = GetArgsAsFloats(args[1:], 3, false); err != nil {
+			fmt.Println("ERROR: ", err)
+			fmt.Println()
+			PrintCommandHelp("setup")
+			return
+		}
+
+		if params[0] != 0 {
+			Settings.SpoolHorizontalDistance_MM = params[0]
+		} else {
+			fmt.Println("Using existing SpoolHorizontalDistance_MM of", Settings.SpoolHorizontalDistance_MM)
+		}
+		if params[1] != 0 {
+			Settings.StartingLeftDist_MM = params[1]
+		} else {
+			fmt.Println("Using existing StartingLeftDist_MM of", Settings.StartingLeftDist_MM)
+		}
+		if params[2] != 0 {
+			Settings.StartingRightDist_MM = params[2]
+		} else {
+			fmt.Println("Using existing StartingRightDist_MM of", Settings.StartingRightDist_MM)
+		}
+
+		if err := Settings.Validate(); err != nil {
+			fmt.Println("ERROR: Invalid settings,", err)
+			return
+		}
+
+		Settings.CalculateDerivedFields()
+
+		polarSystem := PolarSystemFromSettings()
+		polarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+		pos := polarPos.ToCoord(polarSystem)
+
+		if pos.X < Settings.DrawingSurfaceMinX_MM || pos.X > Settings.DrawingSurfaceMaxX_MM || pos.Y < Settings.DrawingSurfaceMinY_MM || pos.Y > Settings.DrawingSurfaceMaxY_MM {
+			fmt.Println("ERROR: The specified settings result in a pen position that exceeds the DrawingSurfaceMin/Max as defined in gocupi_config.xml")
+			fmt.Printf("Initial X,Y position of pen would have been %.3f, %.3f", pos.X, pos.Y)
+			fmt.Println()
+		} else {
+			fmt.Printf("Initial X,Y position of pen is %.3f, %.3f", pos.X, pos.Y)
+			fmt.Println()
+			Settings.Write()
+		}
+
+		return
+
+	case "calibrate":
+		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
+			fmt.Println("ERROR: ", err)
+			fmt.Println()
+			PrintCommandHelp("calibrate")
+			return
+		}
+
+		PerformCalibration(Calibration{Width: params[0], Height: params[1]})
+		return
+
+	case "mesh":
+		if params, err = GetArgsAsFloats(args[1:], 4, false); err == nil {
+			fileName := "gocupi_mesh.txt"
+			if len(args) > 5 {
+				fileName = args[5]
+			}
+			grid := MeshGrid{Width: params[0], Height: params[1], Columns: int(params[2]), Rows: int(params[3])}
+			if err = grid.Validate(); err == nil {
+				PerformMeshGrid(grid, fileName)
+				return
+			}
+		}
+		fmt.Println("ERROR: ", err)
+		fmt.Println()
+		PrintCommandHelp("mesh")
+		return
+
+	case "meshfit":
+		if len(args) != 2 {
+			PrintCommandHelp("meshfit")
+			return
+		}
+		PerformMeshFit(args[1])
+		return
+
+	case "spool":
+		if len(args) == 3 {
+
+			leftSpool := strings.ToLower(args[1]) == "l"
+			if params, err = GetArgsAsFloats(args[2:], 1, true); err != nil {
+				fmt.Println("ERROR: ", err)
+				fmt.Println()
+				PrintCommandHelp("spool")
+				return
+			}
+
+			MoveSpool(leftSpool, params[0])
+		} else {
+			InteractiveMoveSpool()
+		}
+		return
+	}
+
+	// check runs the following drawing command without sending it to the stepper driver
+	checkOnly := args[0] == "check"
+	if checkOnly {
+		if len(args) < 2 {
+			PrintCommandHelp("check")
+			return
+		}
+		args = args[1:]
+	}
+
+	plotCoords, err := createPlotCoords(args, startGenerator)
+	if err == errUnknownCommand {
+		PrintGenericHelp()
+		return
+	} else if err != nil {
+		fmt.Println("ERROR: ", err)
+		fmt.Println()
+		PrintCommandHelp(args[0])
+		return
+	}
+
+	plotCoords, err = PlaceDrawing(placement, filters.Apply(plotCoords))
+	if err != nil && (*toImageFlag || checkOnly) && plotCoords != nil {
+		fmt.Println("WARNING: ", err)
+	} else if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+
+	if *toImageFlag {
+		fmt.Println("Outputting to image")
+		DrawToImage("output.png", plotCoords)
+		return
+	}
+
+	// connect before generating any steps, so they are generated with the constants the stepper driver reports
+	sendToStepper := !checkOnly && !*countFlag && !*toFileFlag && !*toChartFlag
+	var conn *StepperConnection
+	if sendToStepper {
+		fmt.Println("Opening", Settings.Transport, "connection to", Settings.TransportAddress)
+		if conn, err = OpenStepperConnection(); err != nil {
+			fmt.Println("ERROR: ", err)
+			return
+		}
+		defer conn.Close()
+	}
+
+	// output the max speed and acceleration
+	fmt.Println()
+	drawSpeed, drawAccel := Settings.MoveProfile(false)
+	travelSpeed, travelAccel := Settings.MoveProfile(true)
+	fmt.Printf("Draw MaxSpeed: %.3f mm/s Accel: %.3f mm/s^2", drawSpeed, drawAccel)
+	fmt.Println()
+	fmt.Printf("Travel MaxSpeed: %.3f mm/s Accel: %.3f mm/s^2", travelSpeed, travelAccel)
+	fmt.Println()
+
+	generator := NewStepGenerator()
+	writer := NewStepWriter(*pauseOnPenUp)
+	if resumeJournal != nil {
+		generator.Start = resumeJournal.Position()
+		generator.ResumeFrom = resumeJournal.ResumeIndex
+		writer.Start = generator.Start
+	}
+	if checkOnly {
+		generator.Stats = new(StepStats)
+	} else if sendToStepper {
+		journal := NewJobJournal(JournalFile, jobArgs, generator.Origin)
+		generator.Journal = journal
+		writer.Journal = journal
+		// an emulated job does not move the real pen
+		writer.SavePosition = Settings.Transport != EmulatorTransport
+	}
+
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+	switch {
+	case checkOnly:
+		for range stepData {
+		}
+		generator.Stats.Print()
+	case *countFlag:
+		CountSteps(stepData)
+	case *toFileFlag:
+		WriteStepsToFile(stepData)
+	case *toChartFlag:
+		WriteStepsToChart(stepData)
+	default:
+		writer.Control.ListenForSignals()
+		if listener, err := writer.Control.ServeSocket(Settings.ControlSocket); err != nil {
+			fmt.Println("Unable to listen for control commands on", Settings.ControlSocket, err)
+		} else {
+			defer listener.Close()
+		}
+
+		if writer.PauseOnPenUp {
+			fmt.Println("Pause on PenUp enabled!")
+		}
+		writer.Write(conn, stepData)
+		if writer.Control.Status() == PlotAborted {
+			fmt.Println("Run resume to continue the job")
+		}
+	}
+}
+
+// Returned by createPlotCoords when args do not start with a drawing command
+var errUnknownCommand = errors.New("Unknown command")
+
+// Start a generator in its own goroutine, used when a panic should end the program
+func startGenerator(generate func()) {
+	go generate()
+}
+
+// Start the generator for the given command with start, returns an error if the command or its parameters were not valid
+func createPlotCoords(args []string, start func(generate func())) (<-chan Coordinate, error) {
+
+	plotCoords := make(chan Coordinate, 1024)
+	var err error
+	var params []float64
+
+	switch args[0] {
+
 	case "test":
 		plotCoords <- Coordinate{X: 0, Y: 0}
 		plotCoords <- Coordinate{X: 10, Y: 0}
@@ -73,10 +452,7 @@ func main() {
 
 	case "circle":
 		if params, err = GetArgsAsFloats(args[1:], 3, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("circle")
-			return
+			return nil, err
 		}
 		circleSetup := SlidingCircle{
 			Radius:             params[0],
@@ -85,14 +461,11 @@ func main() {
 		}
 
 		fmt.Println("Generating sliding circle")
-		go GenerateSlidingCircle(circleSetup, plotCoords)
+		start(func() { GenerateSlidingCircle(circleSetup, plotCoords) })
 
 	case "crosshatch":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("crosshatch")
-			return
+			return nil, err
 		}
 		crossHatchSetup := CrossHatch{
 			Size: params[0],
@@ -100,19 +473,17 @@ func main() {
 		}
 
 		fmt.Println("Generating crosshatch path")
-		data := LoadImage(args[3])
-		go GenerateCrossHatch(crossHatchSetup, data, plotCoords)
+		data, err := LoadImage(args[3])
+		if err != nil {
+			return nil, err
+		}
+		start(func() { GenerateCrossHatch(crossHatchSetup, data, plotCoords) })
 
-		originalPlotCoords := plotCoords
-		plotCoords = make(chan Coordinate, 1024)
-		go RemoveExtraPenUpMovements(originalPlotCoords, plotCoords)
+		return FilterChain{FilterFunc(RemoveExtraPenUpMovements)}.Apply(plotCoords), nil
 
 	case "gcode":
 		if len(args) < 3 {
-			fmt.Println("ERROR: ", fmt.Sprint("Expected 2 parameters and saw ", len(args)-1))
-			fmt.Println()
-			PrintCommandHelp("gcode")
-			return
+			return nil, errors.New(fmt.Sprint("Expected 2 parameters and saw ", len(args)-1))
 		}
 
 		scale, _ := strconv.ParseFloat(args[1], 64)
@@ -121,15 +492,15 @@ func main() {
 		}
 
 		fmt.Println("Generating Gcode path")
-		data := ParseGcodeFile(args[2])
-		go GenerateGcodePath(data, scale, plotCoords)
+		data, err := ParseGcodeFile(args[2])
+		if err != nil {
+			return nil, err
+		}
+		start(func() { GenerateGcodePath(data, scale, plotCoords) })
 
 	case "grid":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("grid")
-			return
+			return nil, err
 		}
 		gridSetup := Grid{
 			Width: params[0],
@@ -137,14 +508,11 @@ func main() {
 		}
 
 		fmt.Println("Generating grid")
-		go GenerateGrid(gridSetup, plotCoords)
+		start(func() { GenerateGrid(gridSetup, plotCoords) })
 
 	case "hilbert":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("hilbert")
-			return
+			return nil, err
 		}
 		hilbertSetup := HilbertCurve{
 			Size:   params[0],
@@ -152,14 +520,11 @@ func main() {
 		}
 
 		fmt.Println("Generating hilbert curve")
-		go GenerateHilbertCurve(hilbertSetup, plotCoords)
+		start(func() { GenerateHilbertCurve(hilbertSetup, plotCoords) })
 
 	case "imagearc":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("imagearc")
-			return
+			return nil, err
 		}
 		arcSetup := Arc{
 			Size:    params[0],
@@ -167,16 +532,16 @@ func main() {
 		}
 
 		fmt.Println("Generating image arc path")
-		data := LoadImage(args[3])
+		data, err := LoadImage(args[3])
+		if err != nil {
+			return nil, err
+		}
 		data = GaussianImage(data)
-		go GenerateArc(arcSetup, data, plotCoords)
+		start(func() { GenerateArc(arcSetup, data, plotCoords) })
 
 	case "meanderStipple":
 		if params, err = GetArgsAsFloats(args[1:], 4, false); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("meanderStipple")
-			return
+			return nil, err
 		}
 		//rad1:= params[0]
 		//rad2:= params[1]
@@ -186,7 +551,10 @@ func main() {
 		cutOff := params[3]
 
 		fmt.Println("Reading stipples from voronoi_stipller")
-		circles := ParseSvgFileCircle(args[5])
+		circles, err := ParseSvgFileCircle(args[5])
+		if err != nil {
+			return nil, err
+		}
 		//fmt.Println("parsed data:",data)
 
 		fmt.Println("Generating meander")
@@ -203,17 +571,14 @@ func main() {
 			//go TestGenerateMeander(circles,size,narrowness,radMulty,cutOff, plotCoords)
 		*/
 
-		go GenerateMeander(circles, size, narrowness, radMulty, cutOff, plotCoords)
+		start(func() { GenerateMeander(circles, size, narrowness, radMulty, cutOff, plotCoords) })
 
 		//go DrawMeander(circles,size,narrowness,plotCoords)
 		//defer close(plotCoords)
 
 	case "imageraster":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("imageraster")
-			return
+			return nil, err
 		}
 		rasterSetup := Raster{
 			Size:     params[0],
@@ -221,15 +586,15 @@ func main() {
 		}
 
 		fmt.Println("Generating image raster path")
-		data := LoadImage(args[3])
-		go GenerateRaster(rasterSetup, data, plotCoords)
+		data, err := LoadImage(args[3])
+		if err != nil {
+			return nil, err
+		}
+		start(func() { GenerateRaster(rasterSetup, data, plotCoords) })
 
 	case "lissa":
 		if params, err = GetArgsAsFloats(args[1:], 3, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("lissa")
-			return
+			return nil, err
 		}
 		posFunc := func(t float64) Coordinate {
 			return Coordinate{
@@ -239,14 +604,11 @@ func main() {
 		}
 
 		fmt.Println("Generating Lissajous curve")
-		go GenerateParametric(posFunc, plotCoords)
+		start(func() { GenerateParametric(posFunc, plotCoords) })
 
 	case "line":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("line")
-			return
+			return nil, err
 		}
 		lineSetup := BouncingLine{
 			Angle:         params[0],
@@ -254,18 +616,11 @@ func main() {
 		}
 
 		fmt.Println("Generating line")
-		go GenerateBouncingLine(lineSetup, plotCoords)
-
-	case "move":
-		PerformMouseTracking()
-		return
+		start(func() { GenerateBouncingLine(lineSetup, plotCoords) })
 
 	case "parabolic":
 		if params, err = GetArgsAsFloats(args[1:], 3, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("parabolic")
-			return
+			return nil, err
 		}
 		parabolicSetup := Parabolic{
 			Radius:           params[0],
@@ -274,61 +629,11 @@ func main() {
 		}
 
 		fmt.Println("Generating parabolic graph")
-		go GenerateParabolic(parabolicSetup, plotCoords)
-
-	case "setup":
-		if params, err = GetArgsAsFloats(args[1:], 3, false); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("setup")
-			return
-		}
-
-		if params[0] != 0 {
-			Settings.SpoolHorizontalDistance_MM = params[0]
-		} else {
-			fmt.Println("Using existing SpoolHorizontalDistance_MM of", Settings.SpoolHorizontalDistance_MM)
-		}
-		if params[1] != 0 {
-			Settings.StartingLeftDist_MM = params[1]
-		} else {
-			fmt.Println("Using existing StartingLeftDist_MM of", Settings.StartingLeftDist_MM)
-		}
-		if params[2] != 0 {
-			Settings.StartingRightDist_MM = params[2]
-		} else {
-			fmt.Println("Using existing StartingRightDist_MM of", Settings.StartingRightDist_MM)
-		}
-
-		if Settings.SpoolHorizontalDistance_MM > (Settings.StartingLeftDist_MM + Settings.StartingRightDist_MM) {
-			fmt.Println("ERROR: Attempted to specify a setup where the two string distances are less than the distance between idlers")
-			return
-		}
-
-		Settings.CalculateDerivedFields()
-
-		polarSystem := PolarSystemFromSettings()
-		polarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
-		pos := polarPos.ToCoord(polarSystem)
-
-		if pos.X < Settings.DrawingSurfaceMinX_MM || pos.X > Settings.DrawingSurfaceMaxX_MM || pos.Y < Settings.DrawingSurfaceMinY_MM || pos.Y > Settings.DrawingSurfaceMaxY_MM {
-			fmt.Println("ERROR: The specified settings result in a pen position that exceeds the DrawingSurfaceMin/Max as defined in gocupi_config.xml")
-			fmt.Printf("Initial X,Y position of pen would have been %.3f, %.3f", pos.X, pos.Y)
-			fmt.Println()
-		} else {
-			fmt.Printf("Initial X,Y position of pen is %.3f, %.3f", pos.X, pos.Y)
-			fmt.Println()
-			Settings.Write()
-		}
-
-		return
+		start(func() { GenerateParabolic(parabolicSetup, plotCoords) })
 
 	case "spiral":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("spiral")
-			return
+			return nil, err
 		}
 		spiralSetup := Spiral{
 			RadiusBegin:       params[0],
@@ -337,14 +642,11 @@ func main() {
 		}
 
 		fmt.Println("Generating spiral")
-		go GenerateSpiral(spiralSetup, plotCoords)
+		start(func() { GenerateSpiral(spiralSetup, plotCoords) })
 
 	case "spiro":
 		if params, err = GetArgsAsFloats(args[1:], 3, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("spiro")
-			return
+			return nil, err
 		}
 		bigR := params[0]
 		littleR := params[1]
@@ -358,31 +660,11 @@ func main() {
 		}
 
 		fmt.Println("Generating spiro")
-		go GenerateParametric(posFunc, plotCoords)
-
-	case "spool":
-		if len(args) == 3 {
-
-			leftSpool := strings.ToLower(args[1]) == "l"
-			if params, err = GetArgsAsFloats(args[2:], 1, true); err != nil {
-				fmt.Println("ERROR: ", err)
-				fmt.Println()
-				PrintCommandHelp("spool")
-				return
-			}
-
-			MoveSpool(leftSpool, params[0])
-		} else {
-			InteractiveMoveSpool()
-		}
-		return
+		start(func() { GenerateParametric(posFunc, plotCoords) })
 
 	case "svg":
 		if len(args) < 3 {
-			fmt.Println("ERROR: ", fmt.Sprint("Expected at least 2 parameters and saw ", len(args)-1))
-			fmt.Println()
-			PrintCommandHelp("svg")
-			return
+			return nil, errors.New(fmt.Sprint("Expected at least 2 parameters and saw ", len(args)-1))
 		}
 
 		size, _ := strconv.ParseFloat(args[1], 64)
@@ -396,28 +678,34 @@ func main() {
 		}
 
 		fmt.Println("Generating svg path")
-		data := ParseSvgFile(args[2])
+		data, err := ParseSvgFile(args[2])
+		if err != nil {
+			return nil, err
+		}
+		var generate func(Coordinates, float64, chan<- Coordinate)
 		switch svgType {
 		case "top":
-			go GenerateSvgTopPath(data, size, plotCoords)
+			generate = GenerateSvgTopPath
 
 		case "box":
-			go GenerateSvgBoxPath(data, size, plotCoords)
+			generate = GenerateSvgBoxPath
 
 		case "center":
-			go GenerateSvgCenterPath(data, size, plotCoords)
+			generate = GenerateSvgCenterPath
 
 		default:
-			fmt.Println("Expected top or box as the svg type, and saw", svgType)
-			return
+			return nil, errors.New(fmt.Sprint("Expected top, box or center as the svg type, and saw ", svgType))
+		}
+
+		// check the size here, the generator runs in its own goroutine where a bad size can not be returned as an error
+		if _, err := SvgScale(data, size, svgType == "center"); err != nil {
+			return nil, err
 		}
+		start(func() { generate(data, size, plotCoords) })
 
 	case "text":
 		if len(args) != 3 {
-			fmt.Println("ERROR: ", fmt.Sprint("Expected at least 2 parameters and saw ", len(args)-1))
-			fmt.Println()
-			PrintCommandHelp("text")
-			return
+			return nil, errors.New(fmt.Sprint("Expected at least 2 parameters and saw ", len(args)-1))
 		}
 		height, _ := strconv.ParseFloat(args[1], 64)
 		if height == 0 {
@@ -425,14 +713,11 @@ func main() {
 		}
 
 		fmt.Println("Generating text path")
-		go GenerateTextPath(args[2], height, plotCoords)
+		start(func() { GenerateTextPath(args[2], height, plotCoords) })
 
 	case "qr":
 		if params, err = GetArgsAsFloats(args[1:], 2, true); err != nil {
-			fmt.Println("ERROR: ", err)
-			fmt.Println()
-			PrintCommandHelp("qr")
-			return
+			return nil, err
 		}
 		rasterSetup := Raster{
 			Size:     params[0],
@@ -442,81 +727,16 @@ func main() {
 		fmt.Println("Generating qr raster path for ", args[3])
 		data, err := qrencode.Encode(args[3], qrencode.ECLevelQ)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		imageData := data.ImageWithMargin(1, 0)
-		go GenerateRaster(rasterSetup, imageData, plotCoords)
-
-	default:
-		PrintGenericHelp()
-		return
-	}
-
-	if *flipXFlag || *flipYFlag {
-		originalPlotCoords := plotCoords
-		plotCoords = make(chan Coordinate, 1024)
-		go FlipPlotCoords(*flipXFlag, *flipYFlag, originalPlotCoords, plotCoords)
-	}
-
-	if *toImageFlag {
-		fmt.Println("Outputting to image")
-		DrawToImage("output.png", plotCoords)
-		return
-	}
+		start(func() { GenerateRaster(rasterSetup, imageData, plotCoords) })
 
-	// output the max speed and acceleration
-	fmt.Println()
-	fmt.Printf("MaxSpeed: %.3f mm/s Accel: %.3f mm/s^2", Settings.MaxSpeed_MM_S, Settings.Acceleration_MM_S2)
-	fmt.Println()
-
-	stepData := make(chan int8, 1024)
-	go GenerateSteps(plotCoords, stepData)
-	switch {
-	case *countFlag:
-		CountSteps(stepData)
-	case *toFileFlag:
-		WriteStepsToFile(stepData)
-	case *toChartFlag:
-		WriteStepsToChart(stepData)
 	default:
-		WriteStepsToSerial(stepData, *pauseOnPenUp)
+		return nil, errUnknownCommand
 	}
-}
 
-func FlipPlotCoords(flipX, flipY bool, coords <-chan Coordinate, flippedCoords chan<- Coordinate) {
-	defer close(flippedCoords)
-	for coord := range coords {
-		if flipX {
-			coord.X = -coord.X
-		}
-		if flipY {
-			coord.Y = -coord.Y
-		}
-		flippedCoords <- coord
-	}
-}
-
-// Remove unnecessary pen up movements, since PenUp for a line segment is determined by the PenUp of the end of the line, need to do special remember previous coord logic
-func RemoveExtraPenUpMovements(coords <-chan Coordinate, cleanedCoords chan<- Coordinate) {
-	defer close(cleanedCoords)
-
-	previous := Coordinate{PenUp: false}
-
-	skipping := false
-
-	for coord := range coords {
-		if previous.PenUp && coord.PenUp {
-			skipping = true
-			previous = coord
-		} else {
-			if skipping {
-				cleanedCoords <- previous
-				skipping = false
-			}
-			cleanedCoords <- coord
-		}
-		previous = coord
-	}
+	return plotCoords, nil
 }
 
 // Parse a series of numbers as floats
@@ -569,10 +789,28 @@ Flags:
 -tofile, outputs step data to a file
 -count, outputs number of steps and render time
 -slowfactor=#, slow down rendering by #x, 2x, 4x slower etc
--flipx, flip the generated image left to right
--flipy, flip the generated image top to bottom
+-drawspeed=#, max speed in mm/s while the pen is down, can not be faster than the motors allow
+-drawaccel=#, acceleration in mm/s^2 while the pen is down
+-travelspeed=#, max speed in mm/s while the pen is up, can not be faster than the motors allow
+-travelaccel=#, acceleration in mm/s^2 while the pen is up
+-fit, scale the drawing to fill the drawing surface, keeping its aspect ratio, centered unless -anchor is given
+-anchor=position, place the drawing at topleft, top, topright, left, center, right, bottomleft, bottom or bottomright of the drawing surface instead of starting at the pen
+-margin=#, distance to keep from the edges of the drawing surface, one value for all sides, top/bottom,left/right, or top,right,bottom,left
+-unchecked, send the drawing even if it runs off the drawing surface, points past the edge are clipped as they are drawn
+-config=path, settings file to use, otherwise the GOCUPI_CONFIG environment variable, gocupi_config.xml in the working directory, then gocupi/gocupi_config.xml in the user config directory, which is created with default settings if missing
+-set Field=value, override a setting from the settings file for this run, such as -set SpoolHorizontalDistance_MM=1200, can be repeated
+-mesh=false, draw without the CorrectionMesh from the settings file
+-profile=name, use the named profile in gocupi_config.xml, for when one checkout drives several plotters
+-transport=type:address, connection to the stepper driver, such as serial:/dev/ttyUSB0, tcp:host:port, unix:/path, pipe:/path or emulator:fast
+
+Filters, applied to the drawing in the order they are given and can be repeated:`)
+
+	for _, name := range FilterNames() {
+		fmt.Println("-" + name + ", " + FilterHelp(name))
+	}
 
-Commands:`)
+	fmt.Println()
+	fmt.Println("Commands:")
 
 	// output list of possible commands
 	var keys []string
@@ -603,6 +841,19 @@ var CommandHelp = map[string]string{
 	radmulty - multiplie the radius of each circle/tsp city
 	cutOff - radius smaler than cutOff will be drawn as single line
 	path - Path to stipple file generated by voronoi_stipple and Chained Lin-Kernighan`,
+	`check`: `Generate the steps for a drawing command without sending them to the stepper driver, and report anything that would stop it being drawn as intended.
+Reports coordinates outside of the drawing surface, slices that need more steps than can be sent, distance drawn and travelled, pen lifts, estimated time and the final pen position.
+
+check COMMAND PARAMETERS...
+	COMMAND - any drawing command, such as svg 300 drawing.svg`,
+
+	`calibrate`: `Draw a 3 x 3 grid of crosses, then enter the distances measured between them to fit the distance between the idlers, the spool circumference and the starting string lengths. Updates the config xml file.
+The crosses are numbered 1 to 9 left to right and top to bottom, the pen should start where the middle of the top row is wanted.
+
+calibrate W H
+	W - distance between the left and right columns of crosses, make it as large as the drawing surface allows
+	H - distance between the top and bottom rows of crosses`,
+
 	`circle`: `Draw a number of corkscrew kind of sliding circle pattern.
 
 circle R d n
@@ -610,6 +861,16 @@ circle R d n
 	d - displacement per revolution
 	n - number of circles`,
 
+	`control`: `Control the job that is currently being drawn, from another terminal. Requests take effect at the next point where the pen can stop without losing steps.
+The running job also aborts on SIGINT or SIGTERM, interrupting twice aborts immediately, and except on windows aborts on SIGHUP when the terminal is lost, pauses on SIGUSR1 and resumes on SIGUSR2.
+
+control pause|park|resume|abort|status
+	pause - raise the pen and wait
+	park - raise the pen and move it to the position the job started at
+	resume - return to where the job was paused and continue drawing
+	abort - raise the pen and end the job, it can be continued later with resume
+	status - print the state of the job`,
+
 	`crosshatch`: `Render an image using a crosshatch pattern.
 
 crosshatch s d "path"
@@ -617,6 +878,13 @@ crosshatch s d "path"
 	d - distance between each crosshatch line
 	path - path to image file`,
 
+	`emulate`: `Emulate the arduino StepperDriver on a pseudo terminal, so gocupi can be run without hardware. Run a second gocupi with the printed -transport flag to send it data.
+The -transport=emulator:realtime or -transport=emulator:fast flag runs the emulator inside a single gocupi process instead.
+
+emulate [realtime|fast]
+	realtime (default) - execute slices at the same rate as the hardware
+	fast - execute data as fast as it is received`,
+
 	`gcode`: `Render a given gcode file, only a subset of valid gcode is recognized.
 
 gcode s "path"
@@ -647,6 +915,11 @@ imageraster s p "path"
 	s - size of long axis
 	p - pen thickness / distance between rows`,
 
+	`jog`: `Enter a keyboard based interactive movement mode that works in any terminal, including over ssh, allows you to position the pen to start a new drawing or to manually move the pen to a known calibration position.
+Arrow keys move the pen by the step size, 1 to 5 select a step size of 0.1, 1, 5, 10 or 50 mm, and space raises or lowers the pen.
+a/z shorten/lengthen only the left string, k/m shorten/lengthen only the right string.
+Enter or ctrl-c exits and saves the position, e exits and asks for the X Y location of the pen.`,
+
 	`lissa`: `Draw a lissajous curve, drawing stops when the pen arrives back at the starting position.
 
 lissa s a b
@@ -660,7 +933,25 @@ line a d
 	a - initial angle to start drawing
 	d - distance in meters for line`,
 
-	`move`: `Enter a mouse based interactive movement mode, allows you to position the pen to start a new drawing or to manually move the pen to a known calibration position.`,
+	`mesh`: `Draw a grid of crosses without the CorrectionMesh and write a file listing them, to measure the distortion left after calibrate.
+Fill in where each cross really is, measured from the top left cross, then run meshfit with the file. The pen should start where the middle of the top row is wanted.
+
+mesh W H c r [file]
+	W - distance between the left and right columns of crosses
+	H - distance between the top and bottom rows of crosses
+	c - number of columns, at least 2
+	r - number of rows, at least 2
+	file - file the crosses are listed in, gocupi_mesh.txt if not given`,
+
+	`meshfit`: `Build a CorrectionMesh from the measurements filled in to the file written by mesh, and save it to the profile. Every position drawn is then moved by the
+offsets interpolated from the mesh so the crosses would land where they were meant to, -mesh=false draws a job without it.
+
+meshfit file
+	file - the file written by mesh with the measured positions filled in`,
+
+	`move`: `Enter a mouse based interactive movement mode, allows you to position the pen to start a new drawing or to manually move the pen to a known calibration position.
+MouseType in gocupi_config.xml selects a relative mouse, an absolute graphics tablet or touchscreen mapped onto the drawing surface, or replay of a recorded input file.
+Left click exits and saves the position, right click exits and asks for the X Y location of the pen, middle click raises or lowers the pen.`,
 
 	`parabolic`: `Draw a series of parabolic curves (curves made out of a series of straight lines).
 
@@ -669,6 +960,24 @@ parabolic R c l
 	c - count of polygon edges
 	l - number of lines per edges`,
 
+	`resume`: `Continue the last job sent to the stepper driver after a power loss or abort. The job is rebuilt from the command line saved in gocupi_journal.xml, the pen travels raised to the start of the line that was being drawn and drawing continues from there.
+The pen must not be moved between the job stopping and resuming it.
+
+resume`,
+
+	`serve`: `Run an HTTP server that queues drawings and sends them to the stepper driver one at a time. Jobs are given as the command line arguments of any drawing command.
+
+serve [address]
+	address - host:port to listen on, defaults to localhost:8080, use :8080 to allow other machines to connect
+
+	GET /commands - help text of each drawing command
+	POST /files?name=NAME - upload a file as the body or as the file field of a form, returns the Path to use in Args
+	POST /preview {"Args": ["svg", "300", "PATH"]} - returns a png of the drawing
+	POST /jobs {"Args": [...]} - queue a drawing
+	GET /jobs, GET /jobs/ID - status and progress of the queued drawings
+	POST /jobs/ID/pause, park, resume or abort - control a drawing
+	DELETE /jobs/ID - cancel a queued drawing or abort a running one`,
+
 	`setup`: `Enter the initial setup measurements of the system. Updates the config xml file.
 Enter 0 for a parameter that you don't want to update, so you can update just distance between the idlers by doing 'setup 500 0 0'.
 
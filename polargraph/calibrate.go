@@ -0,0 +1,365 @@
+package polargraph
+
+// Calibration that draws a pattern of marks with the current settings, then fits the idler distance, spool circumference
+// and starting string lengths to the distances the user measures between the marks
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Returned when calibrating with the layered spool model, which works out the string length from SpoolCoreDiameter_MM instead of SpoolCircumference_MM
+var errLayeredSpoolCalibration = errors.New("Calibration fits SpoolCircumference_MM, which is not used while the layered spool model is enabled. Set StringThickness_MM to 0 to calibrate, then measure SpoolCoreDiameter_MM again")
+
+// Parameters for the calibration pattern, a 3 x 3 grid of crosses numbered 1 to 9 left to right and top to bottom,
+// the pen starts at mark 2 in the middle of the top row
+type Calibration struct {
+
+	// Distance between the left and right columns of marks
+	Width float64
+
+	// Distance between the top and bottom rows of marks
+	Height float64
+}
+
+// Length of each arm of the crosses drawn at the marks
+const calibrationCrossSize_MM = 5
+
+// Pairs of marks, numbered from 1, the user is asked to measure the distance between
+var CalibrationPairs = [][2]int{{1, 3}, {4, 6}, {7, 9}, {1, 7}, {2, 8}, {3, 9}, {1, 9}, {3, 7}}
+
+// Distance measured between two marks, numbered from 1
+type CalibrationMeasurement struct {
+	From, To int
+	Distance float64
+}
+
+// Settings fitted to the measurements
+type CalibrationResult struct {
+	SpoolHorizontalDistance_MM float64
+	SpoolCircumference_MM      float64
+	StartingLeftDist_MM        float64
+	StartingRightDist_MM       float64
+
+	// Fitted distance minus measured distance of each measurement
+	Residuals []float64
+}
+
+// Root mean square of the residuals
+func (result CalibrationResult) RMS() float64 {
+	sum := 0.0
+	for _, residual := range result.Residuals {
+		sum += residual * residual
+	}
+	return math.Sqrt(sum / float64(len(result.Residuals)))
+}
+
+// Position of each mark relative to the pen's starting position
+func (setup Calibration) Marks() []Coordinate {
+	marks := make([]Coordinate, 0, 9)
+	for row := 0.0; row < 3; row++ {
+		for column := -1.0; column <= 1; column++ {
+			marks = append(marks, Coordinate{X: column * setup.Width / 2, Y: row * setup.Height / 2})
+		}
+	}
+	return marks
+}
+
+// Draw a cross at each mark, returning to the starting position
+func GenerateCalibration(setup Calibration, plotCoords chan<- Coordinate) {
+	generateCrosses(setup.Marks(), plotCoords)
+}
+
+// Draw a cross at each of the marks, returning to the starting position
+func generateCrosses(marks []Coordinate, plotCoords chan<- Coordinate) {
+	defer close(plotCoords)
+
+	for _, mark := range marks {
+		plotCoords <- Coordinate{X: mark.X - calibrationCrossSize_MM, Y: mark.Y, PenUp: true}
+		plotCoords <- Coordinate{X: mark.X + calibrationCrossSize_MM, Y: mark.Y}
+		plotCoords <- Coordinate{X: mark.X, Y: mark.Y - calibrationCrossSize_MM, PenUp: true}
+		plotCoords <- Coordinate{X: mark.X, Y: mark.Y + calibrationCrossSize_MM}
+	}
+	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+}
+
+// Fit the settings to the measured distances between the marks drawn with the current settings, using Gauss-Newton with damping.
+// The marks were drawn by changing each string's length by the amount the current settings say, so a different circumference
+// scales every change, and the idler distance and starting lengths decide where the changed lengths put the pen
+func FitCalibration(setup Calibration, measurements []CalibrationMeasurement) (CalibrationResult, error) {
+	if Settings.LayeredSpools() {
+		return CalibrationResult{}, errLayeredSpoolCalibration
+	}
+
+	marks := setup.Marks()
+	for _, measurement := range measurements {
+		if measurement.From < 1 || measurement.From > len(marks) || measurement.To < 1 || measurement.To > len(marks) || measurement.From == measurement.To {
+			return CalibrationResult{}, errors.New(fmt.Sprint("Measurement between marks ", measurement.From, " and ", measurement.To, " does not use two of the marks 1 to ", len(marks)))
+		}
+	}
+	if len(measurements) < 4 {
+		return CalibrationResult{}, errors.New(fmt.Sprint("At least 4 measurements are needed to fit 4 settings, only have ", len(measurements)))
+	}
+
+	// change in string lengths from the start to each mark as commanded with the current settings
+	system := PolarSystemFromSettings()
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	startPos := start.ToCoord(system)
+	if startPos.IsNaN() {
+		return CalibrationResult{}, errors.New("Starting location is not a valid number, setup has impossible values")
+	}
+	system.XOffset, system.YOffset = startPos.X, startPos.Y
+	changes := make([]PolarCoordinate, len(marks))
+	for index, mark := range marks {
+		changes[index] = mark.ToPolar(system).Minus(start)
+	}
+
+	// parameters are the idler distance, the scale of the circumference, and the starting lengths
+	residuals := func(params []float64) []float64 {
+		fitted := PolarSystemFromSettings()
+		fitted.RightMotorDist = params[0]
+
+		positions := make([]Coordinate, len(marks))
+		for index, change := range changes {
+			positions[index] = PolarCoordinate{
+				LeftDist:  params[2] + change.LeftDist*params[1],
+				RightDist: params[3] + change.RightDist*params[1],
+			}.ToCoord(fitted)
+		}
+
+		result := make([]float64, len(measurements))
+		for index, measurement := range measurements {
+			result[index] = positions[measurement.To-1].Minus(positions[measurement.From-1]).Len() - measurement.Distance
+		}
+		return result
+	}
+	sumSquares := func(values []float64) float64 {
+		sum := 0.0
+		for _, value := range values {
+			sum += value * value
+		}
+		if math.IsNaN(sum) {
+			return math.Inf(1)
+		}
+		return sum
+	}
+
+	params := []float64{Settings.SpoolHorizontalDistance_MM, 1, start.LeftDist, start.RightDist}
+	current := residuals(params)
+	if math.IsInf(sumSquares(current), 1) {
+		return CalibrationResult{}, errors.New("Marks can not be placed with the current settings")
+	}
+
+	damping := 0.001
+	for iteration := 0; iteration < 200; iteration++ {
+
+		// jacobian of the residuals found numerically
+		jacobian := make([][]float64, len(current))
+		for row := range jacobian {
+			jacobian[row] = make([]float64, len(params))
+		}
+		for column := range params {
+			step := 1e-6 * math.Max(math.Abs(params[column]), 1)
+			stepped := append([]float64(nil), params...)
+			stepped[column] += step
+			for row, value := range residuals(stepped) {
+				jacobian[row][column] = (value - current[row]) / step
+			}
+		}
+
+		// normal equations J^T J + damping * diag(J^T J) = -J^T r
+		normal := make([][]float64, len(params))
+		gradient := make([]float64, len(params))
+		for i := range params {
+			normal[i] = make([]float64, len(params))
+			for j := range params {
+				for row := range current {
+					normal[i][j] += jacobian[row][i] * jacobian[row][j]
+				}
+			}
+			for row := range current {
+				gradient[i] -= jacobian[row][i] * current[row]
+			}
+		}
+
+		improved := false
+		for damping < 1e12 {
+			damped := make([][]float64, len(params))
+			for i := range normal {
+				damped[i] = append([]float64(nil), normal[i]...)
+				damped[i][i] += damping * math.Max(normal[i][i], 1e-12)
+			}
+			delta, ok := solveLinear(damped, gradient)
+			if !ok {
+				damping *= 10
+				continue
+			}
+
+			trial := make([]float64, len(params))
+			for i := range params {
+				trial[i] = params[i] + delta[i]
+			}
+			if trialResiduals := residuals(trial); sumSquares(trialResiduals) < sumSquares(current) {
+				converged := sumSquares(current)-sumSquares(trialResiduals) < 1e-12*(1+sumSquares(current))
+				params, current = trial, trialResiduals
+				damping = math.Max(damping/10, 1e-9)
+				improved = !converged
+				break
+			}
+			damping *= 10
+		}
+		if !improved {
+			break
+		}
+	}
+
+	return CalibrationResult{
+		SpoolHorizontalDistance_MM: params[0],
+		SpoolCircumference_MM:      Settings.SpoolCircumference_MM * params[1],
+		StartingLeftDist_MM:        params[2],
+		StartingRightDist_MM:       params[3],
+		Residuals:                  current,
+	}, nil
+}
+
+// Solve the square system matrix * x = vector with gaussian elimination, ok is false if the matrix is singular
+func solveLinear(matrix [][]float64, vector []float64) (x []float64, ok bool) {
+	size := len(vector)
+	rows := make([][]float64, size)
+	for i := range rows {
+		rows[i] = append(append([]float64(nil), matrix[i]...), vector[i])
+	}
+
+	for column := 0; column < size; column++ {
+		pivot := column
+		for row := column + 1; row < size; row++ {
+			if math.Abs(rows[row][column]) > math.Abs(rows[pivot][column]) {
+				pivot = row
+			}
+		}
+		if rows[pivot][column] == 0 {
+			return nil, false
+		}
+		rows[column], rows[pivot] = rows[pivot], rows[column]
+
+		for row := column + 1; row < size; row++ {
+			factor := rows[row][column] / rows[column][column]
+			for i := column; i <= size; i++ {
+				rows[row][i] -= factor * rows[column][i]
+			}
+		}
+	}
+
+	x = make([]float64, size)
+	for row := size - 1; row >= 0; row-- {
+		sum := rows[row][size]
+		for i := row + 1; i < size; i++ {
+			sum -= rows[row][i] * x[i]
+		}
+		x[row] = sum / rows[row][row]
+	}
+	return x, true
+}
+
+// Draw the calibration pattern, ask the user for the distances between the marks, then report the fitted settings and write them if the user agrees
+func PerformCalibration(setup Calibration) {
+
+	if Settings.LayeredSpools() {
+		fmt.Println("ERROR: ", errLayeredSpoolCalibration)
+		return
+	}
+
+	// the geometry is fitted without the correction measured for the previous geometry
+	CorrectionMeshEnabled = false
+
+	fmt.Println("Opening", Settings.Transport, "connection to", Settings.TransportAddress)
+	conn, err := OpenStepperConnection()
+	if err != nil {
+		panic(err)
+	}
+
+	plotCoords := make(chan Coordinate, 1024)
+	go GenerateCalibration(setup, plotCoords)
+	stepData := make(chan int8, 1024)
+	go GenerateSteps(plotCoords, stepData)
+	NewStepWriter(false).Write(conn, stepData)
+	conn.Close()
+
+	fmt.Println("Marks are numbered 1 to 9 left to right and top to bottom, the pen started at mark 2")
+	fmt.Println("Measure between the centers of the crosses, enter 0 to skip a pair")
+	var measurements []CalibrationMeasurement
+	for _, pair := range CalibrationPairs {
+		fmt.Printf("Distance from mark %d to mark %d:", pair[0], pair[1])
+		var distance float64
+		if _, err := fmt.Scanln(&distance); err != nil {
+			panic(err)
+		}
+		if distance > 0 {
+			measurements = append(measurements, CalibrationMeasurement{From: pair[0], To: pair[1], Distance: distance})
+		}
+	}
+
+	result, err := FitCalibration(setup, measurements)
+	if err != nil {
+		fmt.Println("ERROR: ", err)
+		return
+	}
+
+	fmt.Println()
+	for index, measurement := range measurements {
+		fmt.Printf("Mark %d to %d measured %.2f, residual %.3f", measurement.From, measurement.To, measurement.Distance, result.Residuals[index])
+		fmt.Println()
+	}
+	fmt.Printf("RMS residual %.3f mm", result.RMS())
+	fmt.Println()
+	fmt.Println("SpoolHorizontalDistance_MM", Settings.SpoolHorizontalDistance_MM, "->", result.SpoolHorizontalDistance_MM)
+	fmt.Println("SpoolCircumference_MM", Settings.SpoolCircumference_MM, "->", result.SpoolCircumference_MM)
+	fmt.Println("StartingLeftDist_MM", Settings.StartingLeftDist_MM, "->", result.StartingLeftDist_MM)
+	fmt.Println("StartingRightDist_MM", Settings.StartingRightDist_MM, "->", result.StartingRightDist_MM)
+
+	fmt.Print("Write these settings? (y/n):")
+	var answer string
+	fmt.Scanln(&answer)
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		return
+	}
+
+	clearMesh := Settings.CorrectionMesh != ""
+	if clearMesh {
+		fmt.Println("Removing the CorrectionMesh measured with the previous settings, run mesh again to measure a new one")
+	}
+	if err := result.Save(clearMesh); err != nil {
+		panic(err)
+	}
+}
+
+// Write the fitted settings to the profile that was read, and update them in memory.
+// Only these settings are written, so values overridden for this run are not saved
+func (result CalibrationResult) Save(clearMesh bool) error {
+	elements := []settingsElement{
+		{XMLName: xml.Name{Local: "SpoolHorizontalDistance_MM"}, Value: strconv.FormatFloat(result.SpoolHorizontalDistance_MM, 'g', -1, 64)},
+		{XMLName: xml.Name{Local: "SpoolCircumference_MM"}, Value: strconv.FormatFloat(result.SpoolCircumference_MM, 'g', -1, 64)},
+		{XMLName: xml.Name{Local: "StartingLeftDist_MM"}, Value: strconv.FormatFloat(result.StartingLeftDist_MM, 'g', -1, 64)},
+		{XMLName: xml.Name{Local: "StartingRightDist_MM"}, Value: strconv.FormatFloat(result.StartingRightDist_MM, 'g', -1, 64)},
+	}
+	if clearMesh {
+		elements = append(elements, settingsElement{XMLName: xml.Name{Local: "CorrectionMesh"}})
+	}
+	if err := saveElements(elements...); err != nil {
+		return err
+	}
+
+	Settings.SpoolHorizontalDistance_MM = result.SpoolHorizontalDistance_MM
+	Settings.SpoolCircumference_MM = result.SpoolCircumference_MM
+	Settings.StartingLeftDist_MM = result.StartingLeftDist_MM
+	Settings.StartingRightDist_MM = result.StartingRightDist_MM
+	if clearMesh {
+		Settings.CorrectionMesh = ""
+	}
+	Settings.CalculateDerivedFields()
+	return nil
+}
@@ -0,0 +1,97 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Distances between marks drawn by a polargraph that differs from its settings should fit back to the real geometry
+func TestFitCalibration(t *testing.T) {
+	setupTestSettings()
+	setup := Calibration{Width: 600, Height: 600}
+
+	// where the marks really end up when the idlers are 1008 apart, the spools are 1.5% bigger, and the strings started at 604 and 597
+	system := PolarSystemFromSettings()
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	startPos := start.ToCoord(system)
+	system.XOffset, system.YOffset = startPos.X, startPos.Y
+	real := PolarSystemFromSettings()
+	real.RightMotorDist = 1008
+
+	var positions []Coordinate
+	for _, mark := range setup.Marks() {
+		change := mark.ToPolar(system).Minus(start)
+		positions = append(positions, PolarCoordinate{LeftDist: 604 + change.LeftDist*1.015, RightDist: 597 + change.RightDist*1.015}.ToCoord(real))
+	}
+	var measurements []CalibrationMeasurement
+	for _, pair := range CalibrationPairs {
+		distance := positions[pair[1]-1].Minus(positions[pair[0]-1]).Len()
+		measurements = append(measurements, CalibrationMeasurement{From: pair[0], To: pair[1], Distance: distance})
+	}
+
+	result, err := FitCalibration(setup, measurements)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if math.Abs(result.SpoolHorizontalDistance_MM-1008) > 0.1 {
+		t.Error("Expected idler distance 1008, got", result.SpoolHorizontalDistance_MM)
+	}
+	if math.Abs(result.SpoolCircumference_MM-60*1.015) > 0.01 {
+		t.Error("Expected circumference", 60*1.015, "got", result.SpoolCircumference_MM)
+	}
+	if math.Abs(result.StartingLeftDist_MM-604) > 0.1 || math.Abs(result.StartingRightDist_MM-597) > 0.1 {
+		t.Error("Expected starting lengths 604 and 597, got", result.StartingLeftDist_MM, result.StartingRightDist_MM)
+	}
+	if result.RMS() > 0.001 {
+		t.Error("Expected the measurements to fit exactly, got residuals", result.Residuals)
+	}
+}
+
+// Too few measurements or marks that do not exist are errors
+func TestFitCalibrationErrors(t *testing.T) {
+	setupTestSettings()
+	setup := Calibration{Width: 600, Height: 600}
+
+	if _, err := FitCalibration(setup, []CalibrationMeasurement{{From: 1, To: 3, Distance: 600}}); err == nil {
+		t.Error("Expected an error for a single measurement")
+	}
+	if _, err := FitCalibration(setup, []CalibrationMeasurement{{1, 3, 600}, {4, 6, 600}, {7, 10, 600}, {1, 7, 600}}); err == nil {
+		t.Error("Expected an error for mark 10")
+	}
+
+	Settings.StringThickness_MM, Settings.SpoolCoreDiameter_MM, Settings.SpoolLayerWidth_MM, Settings.StringLength_MM = 0.5, 20, 10, 3000
+	if _, err := FitCalibration(setup, []CalibrationMeasurement{{1, 3, 600}, {4, 6, 600}, {7, 9, 600}, {1, 7, 600}}); err != errLayeredSpoolCalibration {
+		t.Error("Expected calibration to be refused with the layered spool model, got", err)
+	}
+}
+
+// Saving the calibration should only write the fitted settings, not values overridden for this run
+func TestSaveCalibration(t *testing.T) {
+	_, restore := useSettingsFile(t, `<GocupiConfig SchemaVersion="2" DefaultProfile="a">
+	<Profile Name="a"><DrawSpeed_MM_S>50</DrawSpeed_MM_S><SpoolCircumference_MM>60</SpoolCircumference_MM></Profile>
+</GocupiConfig>`)
+	defer restore()
+
+	if err := Settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+	Settings.DrawSpeed_MM_S = 80
+	result := CalibrationResult{SpoolHorizontalDistance_MM: 1008, SpoolCircumference_MM: 60.9, StartingLeftDist_MM: 604, StartingRightDist_MM: 597}
+	if err := result.Save(false); err != nil {
+		t.Fatal(err)
+	}
+	if Settings.SpoolCircumference_MM != 60.9 || Settings.DrawSpeed_MM_S != 80 {
+		t.Error("Expected the fitted settings to be used and the override kept in memory, got", Settings.SpoolCircumference_MM, Settings.DrawSpeed_MM_S)
+	}
+
+	var saved SettingsData
+	if err := saved.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if saved.SpoolHorizontalDistance_MM != 1008 || saved.SpoolCircumference_MM != 60.9 || saved.StartingLeftDist_MM != 604 || saved.StartingRightDist_MM != 597 {
+		t.Error("Expected the fitted settings to be saved, got", saved.SpoolHorizontalDistance_MM, saved.SpoolCircumference_MM, saved.StartingLeftDist_MM, saved.StartingRightDist_MM)
+	}
+	if saved.DrawSpeed_MM_S != 50 {
+		t.Error("Expected the overridden speed to not be saved, got", saved.DrawSpeed_MM_S)
+	}
+}
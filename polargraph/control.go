@@ -0,0 +1,309 @@
+package polargraph
+
+// Runtime control of a job while it is being sent to the stepper driver, requests arrive from signals or a local control socket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// State of a job as reported by the StepWriter
+const (
+	PlotRunning  = "running"  // sending step data
+	PlotPausing  = "pausing"  // pause requested, waiting for a safe point
+	PlotPaused   = "paused"   // pen raised, sending zero steps
+	PlotParked   = "parked"   // pen raised and moved to the park position, sending zero steps
+	PlotAborting = "aborting" // abort requested, waiting for a safe point
+	PlotAborted  = "aborted"  // stopped, the journal is kept so the job can be resumed
+	PlotDone     = "done"     // all step data has been sent
+)
+
+// Fixed point steps per slice at or below which the motors are moving slowly enough to stop without losing steps, a quarter step
+const safeStopSteps = 8
+
+// Requests made to a running job, safe to use from multiple goroutines
+type PlotControl struct {
+	mutex sync.Mutex
+
+	pause    bool // stop at the next safe point and raise the pen
+	park     bool // also move to the park position once paused
+	abort    bool // stop at the next safe point and end the job
+	abortNow bool // stop immediately, even if the motors are moving quickly
+	status   string
+
+	slicesSent int64           // number of step data pairs from the job that have been sent
+	position   PolarCoordinate // string lengths that have been sent
+}
+
+// Create a PlotControl for a running job
+func NewPlotControl() *PlotControl {
+	return &PlotControl{status: PlotRunning}
+}
+
+// Pause at the next safe point
+func (control *PlotControl) Pause() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.pause = true
+}
+
+// Pause at the next safe point and then move the pen to the park position
+func (control *PlotControl) Park() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.pause = true
+	control.park = true
+}
+
+// Continue a paused or parked job
+func (control *PlotControl) Resume() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.pause = false
+	control.park = false
+}
+
+// End the job at the next safe point, a second abort ends it immediately
+func (control *PlotControl) Abort() {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.abort {
+		control.abortNow = true
+	}
+	control.abort = true
+}
+
+// Current state of the job, one of the Plot constants
+func (control *PlotControl) Status() string {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.status
+}
+
+// Called by the writer when the state of the job changes
+func (control *PlotControl) setStatus(status string) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	if control.status != status {
+		fmt.Println("Job", status)
+	}
+	control.status = status
+}
+
+// Number of step data pairs from the job that have been sent to the stepper driver
+func (control *PlotControl) SlicesSent() int64 {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.slicesSent
+}
+
+// String lengths that have been sent to the stepper driver, once the job is done or aborted this is where the pen is
+func (control *PlotControl) Position() PolarCoordinate {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.position
+}
+
+// Called by the writer after each block of step data is sent
+func (control *PlotControl) setProgress(slicesSent int64, position PolarCoordinate) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	control.slicesSent = slicesSent
+	control.position = position
+}
+
+// Return the outstanding requests
+func (control *PlotControl) requests() (pause, park, abort, abortNow bool) {
+	control.mutex.Lock()
+	defer control.mutex.Unlock()
+
+	return control.pause, control.park, control.abort, control.abortNow
+}
+
+// Perform a text command, one of pause, park, resume, abort or status, and return the resulting status
+func (control *PlotControl) Command(command string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(command)) {
+	case "pause":
+		control.Pause()
+	case "park":
+		control.Park()
+	case "resume":
+		control.Resume()
+	case "abort":
+		control.Abort()
+	case "status":
+	default:
+		return "", fmt.Errorf("Unknown control command %q, expected pause, park, resume, abort or status", command)
+	}
+	return control.Status(), nil
+}
+
+// Abort on SIGINT or SIGTERM, and where the platform has them pause and resume on the signals listed in controlSignals.
+// A second interrupt aborts immediately, and a third exits in case the stepper driver has stopped responding
+func (control *PlotControl) ListenForSignals() {
+	signals := make(chan os.Signal, 4)
+	signal.Notify(signals, append(controlSignals(), syscall.SIGINT, syscall.SIGTERM)...)
+
+	go func() {
+		interrupts := 0
+		for sig := range signals {
+			if control.handleSignal(sig) {
+				continue
+			}
+
+			interrupts++
+			switch interrupts {
+			case 1:
+				fmt.Println("Aborting at the next safe point, interrupt again to stop immediately")
+			case 2:
+				fmt.Println("Aborting immediately, interrupt again to exit without waiting for the stepper driver")
+			default:
+				os.Exit(1)
+			}
+			control.Abort()
+		}
+	}()
+}
+
+// Accept control commands, one per line, on a unix socket at the given path until the returned listener is closed
+func (control *PlotControl) ServeSocket(path string) (net.Listener, error) {
+
+	// remove a socket left behind by a previous job that did not exit cleanly
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go control.serveConnection(conn)
+		}
+	}()
+
+	return listener, nil
+}
+
+// Reply to each command received on the connection
+func (control *PlotControl) serveConnection(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		status, err := control.Command(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(conn, "ERROR:", err)
+		} else {
+			fmt.Fprintln(conn, status)
+		}
+	}
+}
+
+// Send a command to the job listening on the control socket at path, returns its reply
+func SendControlCommand(path, command string) (string, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "ERROR:") {
+		return "", errors.New(strings.TrimSpace(strings.TrimPrefix(reply, "ERROR:")))
+	}
+	return reply, nil
+}
+
+// Generate step data pairs that travel in a straight line between two string lengths, used to park the pen while paused.
+// The steps always total the rounded difference between the lengths, so travelling there and back returns to the same position
+func travelSteps(from, to PolarCoordinate) []int8 {
+	polarSystem := PolarSystemFromSettings()
+	origin := from.ToCoord(polarSystem)
+	dest := to.ToCoord(polarSystem)
+
+	spool := SpoolModelFromSettings()
+	total := spool.StepsBetween(from, to)
+	totalLeft := roundSteps(total.LeftDist)
+	totalRight := roundSteps(total.RightDist)
+
+	var stepData []int8
+	sentLeft, sentRight := 0, 0
+
+	if !origin.IsNaN() && !dest.IsNaN() {
+		interp := new(TrapezoidInterpolater)
+		interp.Setup(origin, dest, dest)
+
+		for slice := 1.0; slice <= interp.Slices(); slice++ {
+			sliceTarget := interp.Position(slice).ToPolar(polarSystem)
+
+			steps := spool.StepsBetween(from, sliceTarget)
+			left := clampSteps(roundSteps(steps.LeftDist)-sentLeft, int(StepsMaxValue))
+			right := clampSteps(roundSteps(steps.RightDist)-sentRight, int(StepsMaxValue))
+			sentLeft += left
+			sentRight += right
+
+			stepData = append(stepData, int8(-left), int8(right))
+		}
+	}
+
+	// make up any remaining difference slowly, so the total is exact
+	for sentLeft != totalLeft || sentRight != totalRight {
+		left := clampSteps(totalLeft-sentLeft, safeStopSteps)
+		right := clampSteps(totalRight-sentRight, safeStopSteps)
+		sentLeft += left
+		sentRight += right
+
+		stepData = append(stepData, int8(-left), int8(right))
+	}
+
+	return stepData
+}
+
+// Round a number of fixed point steps to the nearest integer, halves are rounded away from zero so that -x rounds to the negative of x
+func roundSteps(steps float64) int {
+	if steps < 0 {
+		return -int(math.Floor(-steps + 0.5))
+	}
+	return int(math.Floor(steps + 0.5))
+}
+
+// Clamp a number of fixed point steps to +-limit
+func clampSteps(steps, limit int) int {
+	if steps > limit {
+		return limit
+	} else if steps < -limit {
+		return -limit
+	}
+	return steps
+}
@@ -0,0 +1,18 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package polargraph
+
+import (
+	"os"
+)
+
+// There are no signals to pause or resume a job, use the control socket instead
+func controlSignals() []os.Signal {
+	return nil
+}
+
+// Every signal is an interrupt
+func (control *PlotControl) handleSignal(sig os.Signal) bool {
+	return false
+}
@@ -0,0 +1,148 @@
+package polargraph
+
+// Tests for pausing, parking and aborting a running job
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Send a short job to a fast emulator using the given writer, returns the emulator's final state
+func writeTestJob(writer *StepWriter, journal *JobJournal) EmulatorState {
+	plotCoords := make(chan Coordinate, 1024)
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	plotCoords <- Coordinate{X: 50, Y: 0}
+	plotCoords <- Coordinate{X: 50, Y: 50}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Journal = journal
+	writer.Journal = journal
+
+	stepData := make(chan int8, 1024)
+	generated := make(chan bool)
+	go func() {
+		generator.Generate(plotCoords, stepData)
+		close(generated)
+	}()
+
+	transport, emulator := StartEmulator(false)
+	conn, err := NewStepperConnection(transport)
+	if err != nil {
+		panic(err)
+	}
+	writer.Write(conn, stepData)
+	conn.Close()
+
+	// an aborted job leaves the generator running until it has drained, wait so it does not overlap the next test
+	<-generated
+	return emulator.Wait()
+}
+
+// Parking should move the pen away and back, leaving the job to finish where it would have without the pause
+func TestParkAndResume(t *testing.T) {
+	setupTestSettings()
+
+	writer := NewStepWriter(false)
+	writer.Park = PolarCoordinate{LeftDist: 580, RightDist: 650}
+	writer.Control.Park()
+
+	go func() {
+		for writer.Control.Status() != PlotParked {
+			time.Sleep(time.Millisecond)
+		}
+		writer.Control.Resume()
+	}()
+
+	state := writeTestJob(writer, nil)
+
+	polarSystem := PolarSystemFromSettings()
+	startingLocation := writer.Start.ToCoord(polarSystem)
+	polarSystem.XOffset = startingLocation.X
+	polarSystem.YOffset = startingLocation.Y
+	expected := Coordinate{X: 50, Y: 50}.ToPolar(polarSystem)
+	final := state.PolarPosition(writer.Start)
+
+	if math.Abs(final.LeftDist-expected.LeftDist) > Settings.StepSize_MM || math.Abs(final.RightDist-expected.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected to end at", expected, "and ended at", final)
+	}
+	if writer.Control.Status() != PlotDone {
+		t.Error("Expected job to be done but was", writer.Control.Status())
+	}
+}
+
+// Aborting should stop at the first safe point with the pen raised and keep the journal
+func TestAbortKeepsJournal(t *testing.T) {
+	setupTestSettings()
+
+	dir, err := ioutil.TempDir("", "gocupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "journal.xml")
+
+	writer := NewStepWriter(false)
+	writer.Control.Abort()
+	journal := NewJobJournal(fileName, []string{"test"}, writer.Start)
+
+	state := writeTestJob(writer, journal)
+
+	if state.LeftPos != 0 || state.RightPos != 0 || !state.PenUp {
+		t.Error("Expected abort before any movement", state)
+	}
+	if writer.Control.Status() != PlotAborted {
+		t.Error("Expected job to be aborted but was", writer.Control.Status())
+	}
+	if _, err := os.Stat(fileName); err != nil {
+		t.Error("Expected journal to be kept after abort", err)
+	}
+}
+
+// Travelling somewhere and back should total exactly zero steps
+func TestTravelStepsReturn(t *testing.T) {
+	setupTestSettings()
+
+	from := PolarCoordinate{LeftDist: 600.01, RightDist: 612.3}
+	to := PolarCoordinate{LeftDist: 512.77, RightDist: 700.2}
+
+	left, right := 0, 0
+	for _, stepData := range [][]int8{travelSteps(from, to), travelSteps(to, from)} {
+		for i := 0; i < len(stepData); i += 2 {
+			left += int(stepData[i])
+			right += int(stepData[i+1])
+		}
+	}
+	if left != 0 || right != 0 {
+		t.Error("Expected to return to the start and was off by", left, right)
+	}
+}
+
+// A job that ends away from where it started saves where the pen really is, without saving settings changed for the job
+func TestSavePositionAtJobEnd(t *testing.T) {
+	path, restore := useSettingsFile(t, `<GocupiConfig SchemaVersion="2"><Profile Name="a"><StartingLeftDist_MM>600</StartingLeftDist_MM><SerialBaud>9600</SerialBaud></Profile></GocupiConfig>`)
+	defer restore()
+	setupTestSettings()
+	settingsFile = path
+	Settings.DrawSpeed_MM_S = 10
+
+	writer := NewStepWriter(false)
+	writer.SavePosition = true
+	state := writeTestJob(writer, nil)
+	final := state.PolarPosition(writer.Start)
+
+	var saved SettingsData
+	if err := saved.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(saved.StartingLeftDist_MM-final.LeftDist) > 1e-9 || math.Abs(saved.StartingRightDist_MM-final.RightDist) > 1e-9 {
+		t.Error("Expected the saved position to be", final, "got", saved.StartingLeftDist_MM, saved.StartingRightDist_MM)
+	}
+	if saved.SerialBaud != 9600 || saved.DrawSpeed_MM_S != 0 {
+		t.Error("Expected only the position to be saved, got", saved.SerialBaud, saved.DrawSpeed_MM_S)
+	}
+}
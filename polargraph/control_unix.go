@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package polargraph
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that pause and resume a job, and SIGHUP which aborts it like an interrupt when the terminal or ssh session is lost
+func controlSignals() []os.Signal {
+	return []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP}
+}
+
+// Pause on SIGUSR1 and resume on SIGUSR2, returns false for an interrupt
+func (control *PlotControl) handleSignal(sig os.Signal) bool {
+	switch sig {
+	case syscall.SIGUSR1:
+		control.Pause()
+	case syscall.SIGUSR2:
+		control.Resume()
+	default:
+		return false
+	}
+	return true
+}
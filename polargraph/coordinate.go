@@ -11,6 +11,9 @@ import (
 type Coordinate struct {
 	X, Y  float64
 	PenUp bool
+
+	// Optional curve followed from the previous coordinate to this one, nil to move in a straight line
+	Curve Curve
 }
 
 // Coordinate ToString
@@ -31,43 +34,52 @@ func (coord Coordinate) Len() float64 {
 
 // Add two coordinates together
 func (source Coordinate) Add(dest Coordinate) Coordinate {
-	return Coordinate{dest.X + source.X, dest.Y + source.Y, dest.PenUp || source.PenUp}
+	return Coordinate{X: dest.X + source.X, Y: dest.Y + source.Y, PenUp: dest.PenUp || source.PenUp}
 }
 
 // Return the vector from source to dest
 func (source Coordinate) Minus(dest Coordinate) Coordinate {
-	return Coordinate{source.X - dest.X, source.Y - dest.Y, source.PenUp || dest.PenUp}
+	return Coordinate{X: source.X - dest.X, Y: source.Y - dest.Y, PenUp: source.PenUp || dest.PenUp}
 }
 
 // Scales the Coordinate by the specified factor
 func (coord Coordinate) Scaled(factor float64) Coordinate {
-	return Coordinate{coord.X * factor, coord.Y * factor, coord.PenUp}
+	return Coordinate{X: coord.X * factor, Y: coord.Y * factor, PenUp: coord.PenUp}
 }
 
 // Scale each axis seperately
 func (coord Coordinate) ScaledBoth(xfactor, yfactor float64) Coordinate {
-	return Coordinate{coord.X * xfactor, coord.Y * yfactor, coord.PenUp}
+	return Coordinate{X: coord.X * xfactor, Y: coord.Y * yfactor, PenUp: coord.PenUp}
 }
 
 // Apply math.Ceil to each value
 func (coord Coordinate) Ceil() Coordinate {
-	return Coordinate{math.Ceil(coord.X), math.Ceil(coord.Y), coord.PenUp}
+	return Coordinate{X: math.Ceil(coord.X), Y: math.Ceil(coord.Y), PenUp: coord.PenUp}
 }
 
 // Apply math.Floor to each value
 func (coord Coordinate) Floor() Coordinate {
-	return Coordinate{math.Floor(coord.X), math.Floor(coord.Y), coord.PenUp}
+	return Coordinate{X: math.Floor(coord.X), Y: math.Floor(coord.Y), PenUp: coord.PenUp}
 }
 
 // Clamp the values of X,Y to the given max/min
 func (coord Coordinate) Clamp(max, min float64) Coordinate {
-	return Coordinate{math.Min(max, math.Max(coord.X, min)), math.Min(max, math.Max(coord.Y, min)), coord.PenUp}
+	return Coordinate{X: math.Min(max, math.Max(coord.X, min)), Y: math.Min(max, math.Max(coord.Y, min)), PenUp: coord.PenUp}
 }
 
 // Normalize the vector
 func (coord Coordinate) Normalized() Coordinate {
 	len := coord.Len()
-	return Coordinate{coord.X / len, coord.Y / len, coord.PenUp}
+	return Coordinate{X: coord.X / len, Y: coord.Y / len, PenUp: coord.PenUp}
+}
+
+// Pass the coordinate and its curve through an affine transform
+func (coord Coordinate) Transformed(transform func(Coordinate) Coordinate) Coordinate {
+	result := transform(coord)
+	if coord.Curve != nil {
+		result.Curve = coord.Curve.Transformed(transform)
+	}
+	return result
 }
 
 // Dot product between two vectors
@@ -94,6 +106,15 @@ type PolarSystem struct {
 	YMin, YMax float64
 
 	RightMotorDist float64
+
+	// Optional model of the strings sagging under their own weight, nil to treat the strings as straight lines
+	Sag *SagModel
+
+	// Optional points the strings attach to the gondola, nil when both strings attach at the pen
+	Gondola *Gondola
+
+	// Optional correction of measured distortion, applied to every position before it becomes string lengths
+	Mesh *CorrectionMesh
 }
 
 // Create a PolarSystem from the settings object
@@ -106,6 +127,9 @@ func PolarSystemFromSettings() PolarSystem {
 		YMin:           Settings.DrawingSurfaceMinY_MM,
 		YMax:           Settings.DrawingSurfaceMaxY_MM,
 		RightMotorDist: Settings.SpoolHorizontalDistance_MM,
+		Sag:            SagModelFromSettings(),
+		Gondola:        GondolaFromSettings(),
+		Mesh:           CorrectionMeshFromSettings(),
 	}
 }
 
@@ -148,30 +172,45 @@ func (coord PolarCoordinate) Clamp(max, min float64) PolarCoordinate {
 // Convert the given coordinate from X,Y to polar in the given PolarSystem
 func (coord Coordinate) ToPolar(system PolarSystem) (polarCoord PolarCoordinate) {
 
-	coord.X += system.XOffset
-	coord.Y += system.YOffset
+	absolute := Coordinate{X: coord.X + system.XOffset, Y: coord.Y + system.YOffset}
 
 	// clip coordinates to system's area
-	if coord.X < system.XMin {
-		fmt.Println("WARNING: X value was outside left bounds, clipping", coord.X, "to", system.XMin)
-		coord.X = system.XMin
+	if absolute.X < system.XMin {
+		fmt.Println("WARNING: X value was outside left bounds, clipping", absolute.X, "to", system.XMin)
+	}
+	if absolute.X > system.XMax {
+		fmt.Println("WARNING: X value was outside right bounds, clipping", absolute.X, "to", system.XMax)
 	}
-	if coord.X > system.XMax {
-		fmt.Println("WARNING: X value was outside right bounds, clipping", coord.X, "to", system.XMax)
-		coord.X = system.XMax
+	if absolute.Y < system.YMin {
+		fmt.Println("WARNING: Y value was outside top bounds, clipping", absolute.Y, "to", system.YMin)
 	}
-	if coord.Y < system.YMin {
-		fmt.Println("WARNING: Y value was outside top bounds, clipping", coord.Y, "to", system.YMin)
-		coord.Y = system.YMin
+	if absolute.Y > system.YMax {
+		fmt.Println("WARNING: Y value was outside bottom bounds, clipping", absolute.Y, "to", system.YMax)
+	}
+
+	polarCoord, _ = coord.ToPolarClipped(system)
+	return
+}
+
+// Convert the given coordinate from X,Y to polar in the given PolarSystem without printing warnings, inBounds is false if it had to be clipped to the system's area
+func (coord Coordinate) ToPolarClipped(system PolarSystem) (polarCoord PolarCoordinate, inBounds bool) {
+
+	coord.X += system.XOffset
+	coord.Y += system.YOffset
+
+	// clip coordinates to system's area
+	clipped := Coordinate{
+		X:     math.Min(system.XMax, math.Max(coord.X, system.XMin)),
+		Y:     math.Min(system.YMax, math.Max(coord.Y, system.YMin)),
+		PenUp: coord.PenUp,
 	}
-	if coord.Y > system.YMax {
-		fmt.Println("WARNING: Y value was outside bottom bounds, clipping", coord.Y, "to", system.YMax)
-		coord.Y = system.YMax
+	inBounds = clipped.X == coord.X && clipped.Y == coord.Y
+	coord = clipped
+	if system.Mesh != nil {
+		coord = system.Mesh.Correct(coord)
 	}
 
-	polarCoord.LeftDist = math.Sqrt(coord.X*coord.X + coord.Y*coord.Y)
-	xDiff := system.RightMotorDist - coord.X
-	polarCoord.RightDist = math.Sqrt(xDiff*xDiff + coord.Y*coord.Y)
+	polarCoord.LeftDist, polarCoord.RightDist = system.stringLengths(coord.X, coord.Y)
 	polarCoord.PenUp = coord.PenUp
 	return
 }
@@ -179,9 +218,15 @@ func (coord Coordinate) ToPolar(system PolarSystem) (polarCoord PolarCoordinate)
 // Convert the given polarCoordinate from polar to X,Y in the given PolarSystem
 func (polarCoord PolarCoordinate) ToCoord(system PolarSystem) (coord Coordinate) {
 
-	coord.X = ((polarCoord.LeftDist * polarCoord.LeftDist) - (polarCoord.RightDist * polarCoord.RightDist) + (system.RightMotorDist * system.RightMotorDist)) / (2.0 * system.RightMotorDist)
-	coord.Y = math.Sqrt((polarCoord.LeftDist * polarCoord.LeftDist) - (coord.X * coord.X))
+	if system.Sag != nil {
+		coord.X, coord.Y = system.sagPosition(polarCoord.LeftDist, polarCoord.RightDist)
+	} else {
+		coord.X, coord.Y = system.straightPosition(polarCoord.LeftDist, polarCoord.RightDist)
+	}
 	coord.PenUp = polarCoord.PenUp
+	if system.Mesh != nil {
+		coord = system.Mesh.Uncorrect(coord)
+	}
 
 	//fmt.Println("Polar ToCoord", polarCoord, system.RightMotorDist, coord)
 
@@ -279,18 +324,20 @@ func (coords Coordinates) Extents() (Coordinate, Coordinate) {
 	minPoint := Coordinate{X: 100000, Y: 100000, PenUp: false}
 	maxPoint := Coordinate{X: -100000, Y: -10000, PenUp: false}
 
-	for _, point := range coords {
+	for _, coord := range coords {
+		for _, point := range pathPoints(coord) {
 
-		if point.X < minPoint.X {
-			minPoint.X = point.X
-		} else if point.X > maxPoint.X {
-			maxPoint.X = point.X
-		}
+			if point.X < minPoint.X {
+				minPoint.X = point.X
+			} else if point.X > maxPoint.X {
+				maxPoint.X = point.X
+			}
 
-		if point.Y < minPoint.Y {
-			minPoint.Y = point.Y
-		} else if point.Y > maxPoint.Y {
-			maxPoint.Y = point.Y
+			if point.Y < minPoint.Y {
+				minPoint.Y = point.Y
+			} else if point.Y > maxPoint.Y {
+				maxPoint.Y = point.Y
+			}
 		}
 	}
 
@@ -0,0 +1,201 @@
+package polargraph
+
+// Curved moves carried in the coordinate stream, so the interpolater can follow them exactly instead of stopping at each short line of an approximation
+
+import (
+	"math"
+	"sort"
+)
+
+// A curved move, a Coordinate with a Curve follows it from the previous coordinate instead of moving in a straight line
+type Curve interface {
+	// Point on the curve, t goes from 0 at the previous coordinate to 1 at this one
+	Point(t float64) Coordinate
+
+	// The same curve with every point passed through transform, which must be affine such as a scale, rotation, mirror or translation
+	Transformed(transform func(Coordinate) Coordinate) Curve
+}
+
+// Elliptical arc, points are Center + Major*cos(angle) + Minor*sin(angle).
+// Circular when Major and Minor are perpendicular and the same length, it stays an exact arc through any affine transform
+type EllipticalArc struct {
+	Center       Coordinate
+	Major, Minor Coordinate
+
+	StartAngle float64 // radians
+	Sweep      float64 // radians, positive turns from Major towards Minor
+}
+
+// Create a circular arc around center starting at start, a positive sweep in radians is clockwise on the drawing since Y is down
+func NewCircularArc(center, start Coordinate, sweep float64) *EllipticalArc {
+	radius := start.Minus(center)
+	radius.PenUp = false
+	return &EllipticalArc{
+		Center: center,
+		Major:  radius,
+		Minor:  Coordinate{X: -radius.Y, Y: radius.X},
+		Sweep:  sweep,
+	}
+}
+
+// Point along the arc
+func (arc *EllipticalArc) Point(t float64) Coordinate {
+	sin, cos := math.Sincos(arc.StartAngle + arc.Sweep*t)
+	return Coordinate{
+		X: arc.Center.X + arc.Major.X*cos + arc.Minor.X*sin,
+		Y: arc.Center.Y + arc.Major.Y*cos + arc.Minor.Y*sin,
+	}
+}
+
+// Arc through the transform
+func (arc *EllipticalArc) Transformed(transform func(Coordinate) Coordinate) Curve {
+	center := transform(arc.Center)
+	major := transform(arc.Center.Add(arc.Major)).Minus(center)
+	minor := transform(arc.Center.Add(arc.Minor)).Minus(center)
+	center.PenUp, major.PenUp, minor.PenUp = false, false, false
+	return &EllipticalArc{Center: center, Major: major, Minor: minor, StartAngle: arc.StartAngle, Sweep: arc.Sweep}
+}
+
+// Cubic Bezier curve
+type CubicBezier struct {
+	Start, Control1, Control2, End Coordinate
+}
+
+// Create the cubic Bezier that draws the same curve as a quadratic Bezier
+func NewQuadraticBezier(start, control, end Coordinate) *CubicBezier {
+	return &CubicBezier{
+		Start:    start,
+		Control1: start.Add(control.Minus(start).Scaled(2.0 / 3.0)),
+		Control2: end.Add(control.Minus(end).Scaled(2.0 / 3.0)),
+		End:      end,
+	}
+}
+
+// Point along the curve
+func (bezier *CubicBezier) Point(t float64) Coordinate {
+	u := 1 - t
+	a, b, c, d := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+	return Coordinate{
+		X: a*bezier.Start.X + b*bezier.Control1.X + c*bezier.Control2.X + d*bezier.End.X,
+		Y: a*bezier.Start.Y + b*bezier.Control1.Y + c*bezier.Control2.Y + d*bezier.End.Y,
+	}
+}
+
+// Curve through the transform
+func (bezier *CubicBezier) Transformed(transform func(Coordinate) Coordinate) Curve {
+	transformed := &CubicBezier{
+		Start:    transform(bezier.Start),
+		Control1: transform(bezier.Control1),
+		Control2: transform(bezier.Control2),
+		End:      transform(bezier.End),
+	}
+	transformed.Start.PenUp, transformed.Control1.PenUp, transformed.Control2.PenUp, transformed.End.PenUp = false, false, false, false
+	return transformed
+}
+
+// Points the pen passes through when moving to coord, the points its curve was sampled at or just coord for a straight line
+func pathPoints(coord Coordinate) []Coordinate {
+	if coord.Curve == nil {
+		return []Coordinate{coord}
+	}
+	return NewCurvePath(coord.Curve).Points()
+}
+
+// Spacing of the points a curve is measured at, small enough that the distance along the curve is accurate to well under a step
+const curveSampleDist_MM = 0.2
+
+// A curve sampled into short lines, so a point a given distance along it can be found
+type CurvePath struct {
+	points  []Coordinate
+	lengths []float64 // distance along the curve to each point
+}
+
+// Sample the curve, the number of points depends on its length
+func NewCurvePath(curve Curve) *CurvePath {
+	roughLength := 0.0
+	previous := curve.Point(0)
+	for sample := 1; sample <= 32; sample++ {
+		point := curve.Point(float64(sample) / 32)
+		roughLength += point.Minus(previous).Len()
+		previous = point
+	}
+	count := int(math.Min(math.Max(math.Ceil(roughLength/curveSampleDist_MM), 16), 8192))
+
+	path := &CurvePath{
+		points:  make([]Coordinate, count+1),
+		lengths: make([]float64, count+1),
+	}
+	for sample := 0; sample <= count; sample++ {
+		path.points[sample] = curve.Point(float64(sample) / float64(count))
+		if sample > 0 {
+			path.lengths[sample] = path.lengths[sample-1] + path.points[sample].Minus(path.points[sample-1]).Len()
+		}
+	}
+	return path
+}
+
+// Total length of the curve
+func (path *CurvePath) Length() float64 {
+	return path.lengths[len(path.lengths)-1]
+}
+
+// Points the curve was sampled at
+func (path *CurvePath) Points() []Coordinate {
+	return path.points
+}
+
+// Index of the sampled line that contains the given distance along the curve
+func (path *CurvePath) lineAt(distance float64) int {
+	index := sort.SearchFloat64s(path.lengths, distance)
+	return int(math.Min(math.Max(float64(index), 1), float64(len(path.points)-1)))
+}
+
+// Point the given distance along the curve
+func (path *CurvePath) At(distance float64) Coordinate {
+	distance = math.Min(math.Max(distance, 0), path.Length())
+	index := path.lineAt(distance)
+
+	lineLength := path.lengths[index] - path.lengths[index-1]
+	if lineLength == 0 {
+		return path.points[index]
+	}
+	fraction := (distance - path.lengths[index-1]) / lineLength
+	start := path.points[index-1]
+	return start.Add(path.points[index].Minus(start).Scaled(fraction))
+}
+
+// Unit direction of travel the given distance along the curve, zero if the curve does not go anywhere
+func (path *CurvePath) DirectionAt(distance float64) Coordinate {
+	index := path.lineAt(math.Min(math.Max(distance, 0), path.Length()))
+
+	// look past repeated points, such as a Bezier whose control point is on its end
+	for offset := 0; offset < len(path.points); offset++ {
+		for _, line := range []int{index + offset, index - offset} {
+			if line < 1 || line >= len(path.points) {
+				continue
+			}
+			if direction := path.points[line].Minus(path.points[line-1]); direction.Len() > 0 {
+				direction = direction.Normalized()
+				direction.PenUp = false
+				return direction
+			}
+		}
+	}
+	return Coordinate{}
+}
+
+// Largest curvature, 1 / radius, anywhere along the curve
+func (path *CurvePath) MaxCurvature() float64 {
+	maxCurvature := 0.0
+	for index := 2; index < len(path.points); index++ {
+		first := path.points[index-1].Minus(path.points[index-2])
+		second := path.points[index].Minus(path.points[index-1])
+		if first.Len() == 0 || second.Len() == 0 {
+			continue
+		}
+		cosAngle := math.Min(math.Max(first.Normalized().DotProduct(second.Normalized()), -1), 1)
+		curvature := math.Acos(cosAngle) / ((first.Len() + second.Len()) / 2)
+		maxCurvature = math.Max(maxCurvature, curvature)
+	}
+	return maxCurvature
+}
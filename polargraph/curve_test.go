@@ -0,0 +1,136 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Curves should start and end where expected and be measured along their length
+func TestCurvePath(t *testing.T) {
+	arc := NewCircularArc(Coordinate{X: 10, Y: 10}, Coordinate{X: 20, Y: 10}, math.Pi/2)
+	path := NewCurvePath(arc)
+	if end := path.At(path.Length()); end.Minus(Coordinate{X: 10, Y: 20}).Len() > 0.0001 {
+		t.Error("Expected the clockwise quarter arc to end at 10,20 but ended at", end)
+	}
+	if expected := 10 * math.Pi / 2; math.Abs(path.Length()-expected) > 0.01 {
+		t.Error("Expected length", expected, "got", path.Length())
+	}
+	if curvature := path.MaxCurvature(); math.Abs(curvature-0.1) > 0.001 {
+		t.Error("Expected curvature 0.1, got", curvature)
+	}
+
+	// scaling the arc keeps it an exact arc with the scaled radius
+	scaled := NewCurvePath(arc.Transformed(func(point Coordinate) Coordinate { return point.Scaled(2) }))
+	if math.Abs(scaled.Length()-2*path.Length()) > 0.01 {
+		t.Error("Expected the scaled arc to be twice as long, got", scaled.Length())
+	}
+
+	bezier := NewQuadraticBezier(Coordinate{X: 0, Y: 0}, Coordinate{X: 5, Y: 5}, Coordinate{X: 10, Y: 0})
+	if middle := bezier.Point(0.5); middle.Minus(Coordinate{X: 5, Y: 2.5}).Len() > 0.0001 {
+		t.Error("Expected the quadratic curve to pass through 5,2.5 but saw", middle)
+	}
+}
+
+// Svg curve and arc commands should produce coordinates carrying curves that start at the previous coordinate
+func TestSVGCurves(t *testing.T) {
+	result, err := NewParser("M 0,0 C 0,10 10,10 10,0 s 10,-10 10,0 A 5,5 0 0 1 30,0 h 5 v 5 q 5,5 10,0 t 10,0", 1, 1).Parse()
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	expectedResult := []Coordinate{
+		{X: 0, Y: 0, PenUp: true},
+		{X: 10, Y: 0},
+		{X: 20, Y: 0},
+		{X: 30, Y: 0},
+		{X: 35, Y: 0},
+		{X: 35, Y: 5},
+		{X: 45, Y: 5},
+		{X: 55, Y: 5},
+	}
+	assertAreEqual(expectedResult, result, t)
+
+	for index, coord := range result {
+		hasCurve := coord.Curve != nil
+		if expected := index == 1 || index == 2 || index == 3 || index == 6 || index == 7; hasCurve != expected {
+			t.Error("Index", index, "expected a curve", expected, "got", hasCurve)
+			continue
+		}
+		if hasCurve {
+			if start := coord.Curve.Point(0); start.Minus(result[index-1]).Len() > 0.0001 {
+				t.Error("Index", index, "curve starts at", start, "instead of", result[index-1])
+			}
+			if end := coord.Curve.Point(1); end.Minus(coord).Len() > 0.0001 {
+				t.Error("Index", index, "curve ends at", end, "instead of", coord)
+			}
+		}
+	}
+
+	// the smooth curve reflects the previous control point, so it leaves downwards after arriving upwards
+	if control := result[2].Curve.(*CubicBezier).Control1; control.Minus(Coordinate{X: 10, Y: -10}).Len() > 0.0001 {
+		t.Error("Expected the reflected control point 10,-10, got", control)
+	}
+	// the arc from 20,0 to 30,0 with positive sweep passes through the bottom of the circle
+	if middle := result[3].Curve.Point(0.5); middle.Minus(Coordinate{X: 25, Y: -5}).Len() > 0.0001 {
+		t.Error("Expected the arc to pass through 25,-5 but saw", middle)
+	}
+}
+
+// G02 and G03 should follow arcs around the center offset
+func TestGcodeArcs(t *testing.T) {
+	data, err := ParseGcode([]string{"G00 X10 Y0", "G02 X0 Y-10 I-10 J0", "G02 X10 Y0"})
+	if err == nil {
+		t.Error("Expected an error for an arc without a center")
+	}
+
+	data, err = ParseGcode([]string{"G00 X10 Y0", "G02 X0 Y-10 I-10 J0", "G03 X-10 Y0 I0 J10"})
+	if err != nil || len(data.Lines) != 3 {
+		t.Fatal("Expected 3 lines and no error, got", data.Lines, err)
+	}
+
+	// Y is flipped, so the clockwise arc from 10,0 to 0,-10 passes through 7.07,7.07
+	clockwise := data.Lines[1].Dest
+	if middle := clockwise.Curve.Point(0.5); middle.Minus(Coordinate{X: 10 / math.Sqrt2, Y: 10 / math.Sqrt2}).Len() > 0.0001 {
+		t.Error("Expected the clockwise arc to pass through 7.07,7.07 but saw", middle)
+	}
+
+	// the counterclockwise arc from 0,10 to -10,0 goes the long way around, through 7.07,-7.07
+	counterClockwise := data.Lines[2].Dest
+	if middle := counterClockwise.Curve.Point(0.5); middle.Minus(Coordinate{X: 10 / math.Sqrt2, Y: -10 / math.Sqrt2}).Len() > 0.0001 {
+		t.Error("Expected the counterclockwise arc to pass through 7.07,-7.07 but saw", middle)
+	}
+	if counterClockwise.Minus(Coordinate{X: -10, Y: 0}).Len() > 0.0001 {
+		t.Error("Expected the second arc to end at -10,0 but ended at", counterClockwise)
+	}
+}
+
+// A job of curves should follow each curve and finish where it ends
+func TestCurvesGenerateJob(t *testing.T) {
+	setupTestSettings()
+
+	circle := NewCircularArc(Coordinate{X: 50, Y: 50}, Coordinate{X: 100, Y: 50}, 2*math.Pi)
+	bezier := &CubicBezier{Start: Coordinate{X: 100, Y: 50}, Control1: Coordinate{X: 100, Y: 100}, Control2: Coordinate{X: 150, Y: 100}, End: Coordinate{X: 150, Y: 50}}
+
+	plotCoords := make(chan Coordinate, 10)
+	plotCoords <- Coordinate{X: 100, Y: 50, PenUp: true}
+	plotCoords <- Coordinate{X: 100, Y: 50, Curve: circle}
+	plotCoords <- Coordinate{X: 150, Y: 50, Curve: bezier}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Stats = new(StepStats)
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+	for range stepData {
+	}
+
+	if generator.Stats.ClampedSlices != 0 {
+		t.Error("Expected no clamped slices and saw", generator.Stats.ClampedSlices)
+	}
+	if generator.Stats.FinalCoordinate.Minus(Coordinate{X: 150, Y: 50}).Len() > 1 {
+		t.Error("Expected to finish at 150,50 and saw", generator.Stats.FinalCoordinate)
+	}
+	if expected := 2*math.Pi*50 + NewCurvePath(bezier).Length(); generator.Stats.DrawDistance_MM < expected-1 {
+		t.Error("Expected to draw along the curves for", expected, "but drew", generator.Stats.DrawDistance_MM)
+	}
+}
@@ -1,11 +1,10 @@
 package polargraph
 
-// Handles sending data over serial to the arduino
+// Handles sending data to the arduino over the configured transport
 
 import (
 	"bufio"
 	"fmt"
-	serial "github.com/tarm/goserial"
 	"io"
 	"math"
 	"os"
@@ -23,14 +22,44 @@ func OutputCoords(plotCoords <-chan Coordinate) {
 	fmt.Println("Done plotting")
 }
 
+// Converts a stream of coordinates into stepData
+type StepGenerator struct {
+	// String lengths at the job's 0,0 position
+	Origin PolarCoordinate
+
+	// String lengths at the pen's actual position when the job starts, the first move is from here
+	Start PolarCoordinate
+
+	// Index of the coordinate to resume drawing at, earlier coordinates are skipped and the pen travels raised to it
+	ResumeFrom int
+
+	// Optional journal that is told where each coordinate begins in the stepData
+	Journal *JobJournal
+
+	// Optional statistics collected while generating, out of bounds warnings are recorded here instead of printed
+	Stats *StepStats
+}
+
+// Create a StepGenerator for a job starting at the pen position in settings
+func NewStepGenerator() *StepGenerator {
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	return &StepGenerator{Origin: start, Start: start}
+}
+
 // Takes in coordinates and outputs stepData
 func GenerateSteps(plotCoords <-chan Coordinate, stepData chan<- int8) {
+	NewStepGenerator().Generate(plotCoords, stepData)
+}
+
+// Takes in coordinates and outputs stepData
+func (gen *StepGenerator) Generate(plotCoords <-chan Coordinate, stepData chan<- int8) {
 
 	defer close(stepData)
 
 	polarSystem := PolarSystemFromSettings()
-	previousPolarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
-	startingLocation := previousPolarPos.ToCoord(polarSystem)
+	spool := SpoolModelFromSettings()
+	previousPolarPos := gen.Start
+	startingLocation := gen.Origin.ToCoord(polarSystem)
 
 	fmt.Println("Start Location", startingLocation, "Initial Polar", previousPolarPos)
 
@@ -38,27 +67,61 @@ func GenerateSteps(plotCoords <-chan Coordinate, stepData chan<- int8) {
 		panic(fmt.Sprint("Starting location is not a valid number, setup has impossible values"))
 	}
 
-	// setup 0,0 as the initial location of the plot head
+	// setup 0,0 as the location of the plot head when the job began
 	polarSystem.XOffset = startingLocation.X
 	polarSystem.YOffset = startingLocation.Y
 
-	//var interp PositionInterpolater = new(LinearInterpolater)
-	var interp PositionInterpolater = new(TrapezoidInterpolater)
+	interp, err := NewPositionInterpolater(Settings.Interpolater)
+	if err != nil {
+		panic(err)
+	}
 
-	origin := Coordinate{X: 0, Y: 0}
-	target, chanOpen := <-plotCoords
-	if !chanOpen {
-		return
+	origin := previousPolarPos.ToCoord(polarSystem)
+	if origin.IsNaN() {
+		panic(fmt.Sprint("Resume location is not a valid number, journal has impossible values"))
+	}
+
+	// skip coordinates that were already drawn
+	coordinateIndex := 0
+	for ; coordinateIndex < gen.ResumeFrom; coordinateIndex++ {
+		if _, chanOpen := <-plotCoords; !chanOpen {
+			return
+		}
 	}
 
 	var currentPenUp bool = true // arduino code defaults to pen up on ResetCommand
-	var anotherTarget bool = true
+	var slices int64 = 0
+
+	planner := NewMotionPlanner(origin, Settings.LookaheadSegments)
+	planner.PolarSystem = &polarSystem
+	chanOpen := true
+	firstTarget := true
 
-	for anotherTarget {
-		nextTarget, chanOpen := <-plotCoords
-		if !chanOpen {
-			anotherTarget = false
-			nextTarget = target
+	for {
+		// keep the planner's window full so it can see far enough ahead to plan speeds
+		for chanOpen && !planner.Full() {
+			var target Coordinate
+			if target, chanOpen = <-plotCoords; chanOpen {
+				if firstTarget && gen.ResumeFrom > 0 {
+					target.PenUp = true
+					target.Curve = nil
+				}
+				firstTarget = false
+				planner.Add(target)
+			}
+		}
+		if planner.Len() == 0 {
+			break
+		}
+
+		segment := planner.Next()
+		target := segment.Dest
+
+		if gen.Journal != nil {
+			gen.Journal.CoordinateStarted(coordinateIndex, slices, target.PenUp)
+		}
+		if gen.Stats != nil {
+			gen.Stats.addCoordinate(coordinateIndex, origin, target, polarSystem)
 		}
 
 		if target.PenUp != currentPenUp {
@@ -71,31 +134,58 @@ func GenerateSteps(plotCoords <-chan Coordinate, stepData chan<- int8) {
 				stepData <- PenDownCommand
 			}
 			currentPenUp = target.PenUp
+			slices++
+
+			if gen.Stats != nil {
+				gen.Stats.PenTransitions++
+				if currentPenUp {
+					gen.Stats.PenLifts++
+				}
+			}
 		}
 
-		interp.Setup(origin, target, nextTarget)
+		// start from where the previous steps actually left the pen
+		segment.Origin = origin
+		interp.SetupSegment(segment)
 
 		//fmt.Println("Slices", interp.Slices(), "------------------------")
 
 		for slice := 1.0; slice <= interp.Slices(); slice++ {
 
 			sliceTarget := interp.Position(slice)
-			polarSliceTarget := sliceTarget.ToPolar(polarSystem)
+			var polarSliceTarget PolarCoordinate
+			if gen.Stats != nil {
+				polarSliceTarget, _ = sliceTarget.ToPolarClipped(polarSystem)
+			} else {
+				polarSliceTarget = sliceTarget.ToPolar(polarSystem)
+			}
 
 			// calc number of steps that will be made this time slice, have to precision that can be sent in a single value from StepsMaxValue to -StepsMaxValue
-			sliceSteps := polarSliceTarget.
-				Minus(previousPolarPos).
-				Scaled(StepsFixedPointFactor/Settings.StepSize_MM).
-				Ceil().
-				Clamp(StepsMaxValue, -StepsMaxValue)
-			previousPolarPos = previousPolarPos.
-				Add(sliceSteps.Scaled(Settings.StepSize_MM / StepsFixedPointFactor))
+			unclampedSteps := spool.StepsBetween(previousPolarPos, polarSliceTarget).Ceil()
+			sliceSteps := unclampedSteps.Clamp(StepsMaxValue, -StepsMaxValue)
+			previousPolarPos = spool.Moved(previousPolarPos, sliceSteps)
 
 			stepData <- int8(-sliceSteps.LeftDist)
 			stepData <- int8(sliceSteps.RightDist)
+			slices++
+
+			if gen.Stats != nil {
+				gen.Stats.Slices++
+				if currentPenUp {
+					gen.Stats.TravelSlices++
+				}
+				if unclampedSteps != sliceSteps {
+					gen.Stats.ClampedSlices++
+				}
+			}
 		}
 		origin = previousPolarPos.ToCoord(polarSystem)
-		target = nextTarget
+		coordinateIndex++
+	}
+
+	if gen.Stats != nil {
+		gen.Stats.FinalPosition = previousPolarPos
+		gen.Stats.FinalCoordinate = origin
 	}
 	fmt.Println("Done generating steps")
 }
@@ -104,20 +194,27 @@ func GenerateSteps(plotCoords <-chan Coordinate, stepData chan<- int8) {
 func CountSteps(stepData <-chan int8) {
 
 	sliceCount := 0
+	travelCount := 0
 	penTransition := 0
+	penUp := true // arduino code defaults to pen up on ResetCommand
 	for step := range stepData {
 
 		if step == PenUpCommand || step == PenDownCommand {
 			penTransition++
+			penUp = step == PenUpCommand
 		} else {
 			sliceCount++
+			if penUp {
+				travelCount++
+			}
 		}
 	}
 	// since data is sent once for left and right spools, have to divide by 2
 	sliceCount = sliceCount >> 1
+	travelCount = travelCount >> 1
 	penTransition = penTransition >> 1
-	penTransitionCooldown_US := 1250000.0 // as defined in the microcontroller code
-	fmt.Println("Steps", sliceCount, "Pen Transitions", penTransition, "Time", time.Duration(float64(sliceCount)*TimeSlice_US+float64(penTransition)*penTransitionCooldown_US)*time.Microsecond)
+	fmt.Println("Steps", sliceCount, "Pen Transitions", penTransition, "Time", time.Duration(float64(sliceCount)*TimeSlice_US+float64(penTransition)*PenTransitionCooldown_US)*time.Microsecond)
+	fmt.Println("Drawing Time", time.Duration(float64(sliceCount-travelCount)*TimeSlice_US)*time.Microsecond, "Travel Time", time.Duration(float64(travelCount)*TimeSlice_US)*time.Microsecond)
 }
 
 // Sends the given stepData to a file
@@ -144,64 +241,168 @@ func WriteStepsToFile(stepData <-chan int8) {
 	}
 }
 
-// Sends the given stepData to the stepper driver
-func WriteStepsToSerial(stepData <-chan int8, pauseOnPenUp bool) {
-	if pauseOnPenUp {
-		fmt.Println("Pause on PenUp enabled!")
-	}
+// Sends stepData to the stepper driver, tracking the position that has been sent
+type StepWriter struct {
+	// Pause after each PenUp until the user presses enter
+	PauseOnPenUp bool
 
-	fmt.Println("Opening com port")
-	c := &serial.Config{Name: "/dev/ttyAMA0", Baud: 57600}
-	s, err := serial.OpenPort(c)
-	if err != nil {
-		panic(err)
+	// String lengths when the first step is sent
+	Start PolarCoordinate
+
+	// String lengths the pen is moved to when the job is parked
+	Park PolarCoordinate
+
+	// Optional journal that is updated as steps are sent
+	Journal *JobJournal
+
+	// Pause, park, resume and abort requests for the job
+	Control *PlotControl
+
+	// Save the position the pen was left at to the settings file when the job ends, is aborted or the connection fails,
+	// so the next job starts from where the pen really is
+	SavePosition bool
+}
+
+// Create a StepWriter for a job starting at the pen position in settings, the job is parked at its starting position
+func NewStepWriter(pauseOnPenUp bool) *StepWriter {
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	return &StepWriter{
+		PauseOnPenUp: pauseOnPenUp,
+		Start:        start,
+		Park:         start,
+		Control:      NewPlotControl(),
 	}
-	defer s.Close()
+}
+
+// Sends the given stepData to the stepper driver at the other end of conn.
+// Pause and abort requests take effect at the next safe point, a pen transition or a slice slow enough to stop on.
+// While paused zero step pairs are sent so the stepper driver keeps requesting data
+func (writer *StepWriter) Write(conn *StepperConnection, stepData <-chan int8) {
 
-	// buffers to use during serial communication
-	writeData := make([]byte, 128)
-	readData := make([]byte, 1)
+	if writer.Control == nil {
+		writer.Control = NewPlotControl()
+	}
+	control := writer.Control
 
 	previousSend := time.Now()
 	var totalSends int = 0
-	var byteData int8 = 0
-
-	// send a -128 to force the arduino to restart and rerequest data
-	s.Write([]byte{ResetCommand})
+	var leftData, rightData int8 = 0, 0
+
+	position := writer.Start
+	position.PenUp = true // arduino code defaults to pen up on ResetCommand
+	control.setProgress(0, position)
+	spool := SpoolModelFromSettings()
+	var slices int64 = 0
+
+	sent := position // position once the stepper driver has received the data
+	if writer.SavePosition {
+		defer func() {
+			fmt.Println("Saving pen position", sent.LeftDist, sent.RightDist, "to", settingsFile)
+			if err := SavePosition(sent); err != nil {
+				fmt.Println("Failed to save pen position:", err)
+			}
+		}()
+	}
 
-	var pauseAfterWrite = false
+	var pending []int8 // pairs inserted by pausing or parking, sent before any more stepData
+	var pausedAt PolarCoordinate
+	var paused, parked, liftedPen, aborted bool
+	safePoint := true // the previous pair left the motors slow enough to stop
 
-	for stepDataOpen := true; stepDataOpen; {
+	for stepDataOpen := true; (stepDataOpen && !aborted) || len(pending) > 0; {
 		// wait for next data request
-		n, err := s.Read(readData)
+		writeData, err := conn.WaitForRequest()
 		if err != nil {
 			panic(err)
 		}
-		if n != 1 {
-			panic(err)
-		}
 
-		dataToWrite := int(readData[0])
+		dataToWrite := len(writeData)
 		for i := 0; i < dataToWrite; i += 2 {
 
-			if pauseAfterWrite {
-				// want to fill remainder of buffer with 0s before writing it to serial
-				writeData[i] = byte(0)
-				writeData[i+1] = byte(0)
+			fromStepData := false
+			pause, park, abort, abortNow := control.requests()
+
+			switch {
+			case abortNow && !aborted:
+				// stop immediately, dropping any park travel that has not been sent
+				aborted = true
+				pending = nil
+				if !position.PenUp {
+					pending = append(pending, PenUpCommand, PenUpCommand)
+				}
+
+			case len(pending) > 0:
+
+			case aborted || !stepDataOpen:
+
+			case !paused && (pause || abort) && safePoint:
+				paused = true
+				pausedAt = position
+				if !position.PenUp {
+					pending = append(pending, PenUpCommand, PenUpCommand)
+					liftedPen = true
+				}
+
+			case paused && abort:
+				aborted = true
+
+			case paused && park && !parked:
+				pending = travelSteps(position, writer.Park)
+				parked = true
+
+			case paused && !pause:
+				if parked {
+					pending = travelSteps(position, pausedAt)
+					parked = false
+				}
+				if liftedPen {
+					pending = append(pending, PenDownCommand, PenDownCommand)
+					liftedPen = false
+				}
+				paused = false
+
+			case !paused:
+				fromStepData = true
+			}
+
+			if len(pending) > 0 {
+				leftData, rightData = pending[0], pending[1]
+				pending = pending[2:]
+			} else if fromStepData {
+				// even if stepData is closed and empty, receiving from it will return default value 0 and false for stepDataOpen
+				leftData, stepDataOpen = <-stepData
+				rightData, stepDataOpen = <-stepData
+				if stepDataOpen {
+					slices++
+				}
 			} else {
-				// even if stepData is closed and empty, receiving from it will return default value 0 for byteData and false for stepDataOpen
-				byteData, stepDataOpen = <-stepData
-				writeData[i] = byte(byteData)
-				byteData, stepDataOpen = <-stepData
-				writeData[i+1] = byte(byteData)
-
-				// pause on pen up
-				if byteData == PenUpCommand {
-					fmt.Println("PenUp...")
-					pauseAfterWrite = pauseOnPenUp
-				} else if byteData == PenDownCommand {
-					fmt.Println("PenDown...")
+				// want to fill remainder of buffer with 0s before writing it to serial
+				leftData, rightData = 0, 0
+			}
+			writeData[i] = byte(leftData)
+			writeData[i+1] = byte(rightData)
+
+			switch leftData {
+			case PenUpCommand:
+				fmt.Println("PenUp...")
+				position.PenUp = true
+				safePoint = true
+				if fromStepData && writer.PauseOnPenUp {
+					control.Pause()
+					go func() {
+						fmt.Println("Press any key to continue...")
+						reader := bufio.NewReader(os.Stdin)
+						reader.ReadString('\n')
+						control.Resume()
+					}()
 				}
+			case PenDownCommand:
+				fmt.Println("PenDown...")
+				position.PenUp = false
+				safePoint = true
+			default:
+				position = spool.Moved(position, PolarCoordinate{LeftDist: -float64(leftData), RightDist: float64(rightData)})
+				safePoint = -safeStopSteps <= leftData && leftData <= safeStopSteps && -safeStopSteps <= rightData && rightData <= safeStopSteps
 			}
 		}
 
@@ -215,14 +416,51 @@ func WriteStepsToSerial(stepData <-chan int8, pauseOnPenUp bool) {
 			previousSend = curTime
 		}
 
-		s.Write(writeData)
+		if err := conn.Send(writeData); err != nil {
+			panic(err)
+		}
+		sent = position
+
+		control.setProgress(slices, position)
+		pause, _, abort, _ := control.requests()
+		switch {
+		case aborted:
+			control.setStatus(PlotAborted)
+		case paused && parked:
+			control.setStatus(PlotParked)
+		case paused:
+			control.setStatus(PlotPaused)
+		case abort:
+			control.setStatus(PlotAborting)
+		case pause:
+			control.setStatus(PlotPausing)
+		default:
+			control.setStatus(PlotRunning)
+		}
+
+		if writer.Journal != nil {
+			if err := writer.Journal.StepsSent(slices, position); err != nil {
+				fmt.Println("Failed to write journal:", err)
+			}
+		}
+	}
+
+	if aborted {
+		go drainSteps(stepData)
 
-		if pauseAfterWrite {
-			pauseAfterWrite = false
+		// keep the journal so the job can be resumed
+		if writer.Journal != nil {
+			if err := writer.Journal.Write(); err != nil {
+				fmt.Println("Failed to write journal:", err)
+			}
+		}
+		return
+	}
 
-			fmt.Println("Press any key to continue...")
-			reader := bufio.NewReader(os.Stdin)
-			reader.ReadString('\n')
+	control.setStatus(PlotDone)
+	if writer.Journal != nil {
+		if err := writer.Journal.Complete(); err != nil {
+			fmt.Println("Failed to remove journal:", err)
 		}
 	}
 }
@@ -249,31 +487,54 @@ func InteractiveMoveSpool() {
 // Move a specific spool a given distance
 func MoveSpool(leftSpool bool, distance float64) {
 
-	alignStepData := make(chan int8, 1024)
-	go WriteStepsToSerial(alignStepData, false)
+	// connect first, so the steps are generated with the constants the stepper driver reports
+	conn, err := OpenStepperConnection()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	// the step size depends on how much string is wound on, which is known from the starting length of the string
+	spool := SpoolModelFromSettings()
+	startLength := Settings.StartingRightDist_MM
+	if leftSpool {
+		startLength = Settings.StartingLeftDist_MM
+	}
 
-	interp := new(TrapezoidInterpolater)
-	interp.Setup(Coordinate{}, Coordinate{X: distance, Y: 0}, Coordinate{})
-	position := 0.0
+	alignStepData := make(chan int8, 1024)
+	go func() {
+		interp := new(TrapezoidInterpolater)
+		interp.Setup(Coordinate{}, Coordinate{X: distance, Y: 0}, Coordinate{})
+		position := spool.Steps(startLength) * StepsFixedPointFactor
 
-	for slice := 1.0; slice <= interp.Slices(); slice++ {
+		for slice := 1.0; slice <= interp.Slices(); slice++ {
 
-		sliceTarget := interp.Position(slice)
+			sliceTarget := interp.Position(slice)
 
-		// calc integer number of steps that will be made this time slice
-		sliceSteps := math.Ceil((sliceTarget.X - position) * (StepsFixedPointFactor / Settings.StepSize_MM))
-		position = position + sliceSteps*(Settings.StepSize_MM/StepsFixedPointFactor)
+			// calc integer number of steps that will be made this time slice
+			sliceSteps := math.Ceil(spool.Steps(startLength+sliceTarget.X)*StepsFixedPointFactor - position)
+			position += sliceSteps
 
-		if leftSpool {
-			alignStepData <- int8(-sliceSteps)
-			alignStepData <- 0
-		} else {
-			alignStepData <- 0
-			alignStepData <- int8(sliceSteps)
+			if leftSpool {
+				alignStepData <- int8(-sliceSteps)
+				alignStepData <- 0
+			} else {
+				alignStepData <- 0
+				alignStepData <- int8(sliceSteps)
+			}
 		}
-	}
 
-	close(alignStepData)
+		close(alignStepData)
+	}()
+
+	writer := NewStepWriter(false)
+	writer.SavePosition = true
+	writer.Write(conn, alignStepData)
+
+	// the next move starts from the new length
+	final := writer.Control.Position()
+	Settings.StartingLeftDist_MM = final.LeftDist
+	Settings.StartingRightDist_MM = final.RightDist
 }
 
 // Do mouse tracking, must open up serial port directly in order to send steps in realtime as requested
@@ -283,88 +544,185 @@ func PerformMouseTracking() {
 	mouse := CreateAndStartMouseReader()
 	defer mouse.Close()
 
-	fmt.Println("Opening com port")
-	c := &serial.Config{Name: "/dev/ttyAMA0", Baud: 57600}
-	s, err := serial.OpenPort(c)
+	fmt.Println("Opening", Settings.Transport, "connection to", Settings.TransportAddress)
+	conn, err := OpenStepperConnection()
 	if err != nil {
 		panic(err)
 	}
-	defer s.Close()
+	defer conn.Close()
 
-	fmt.Println("Left click to exit, Right click to exit and enter X Y location of pen")
+	fmt.Println("Left click to exit, Right click to exit and enter X Y location of pen, Middle click to raise or lower the pen")
 
-	// buffers to use during serial communication
-	writeData := make([]byte, 128)
-	readData := make([]byte, 1)
+	realtime := NewRealtimeMover()
+	tracker := newMouseTracker(mouse, realtime.PolarSystem)
 
-	polarSystem := PolarSystemFromSettings()
-	previousPolarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
-	startingPos := previousPolarPos.ToCoord(polarSystem)
-	polarSystem.XOffset = startingPos.X
-	polarSystem.YOffset = startingPos.Y
+	realtime.Run(conn, func(current PolarCoordinate, slices int) (targets []PolarCoordinate, done bool) {
+		targets, clicked, exit := tracker.Targets(current, slices)
+		if exit && clicked == MouseLeft {
+			updateSettingsPosition(current.ToCoord(realtime.PolarSystem), realtime.PolarSystem)
+		} else if exit {
+			promptForSettingsPosition(realtime.PolarSystem)
+		}
+		return targets, exit
+	})
+}
 
-	currentPos := Coordinate{X: 0, Y: 0}
+// Moves the pen towards the mouse pointer, and turns presses and releases of its buttons into clicks
+type mouseTracker struct {
+	mouse       MouseReader
+	events      <-chan MouseButtonEvent
+	polarSystem PolarSystem
+	maxDistance float64 // max distance that can be travelled in one batch
 
-	// max distance that can be travelled in one batch
-	maxDistance := 64 * (Settings.MaxSpeed_MM_S * TimeSlice_US / 1000000.0)
+	pressed map[MouseButton]bool
+	penUp   bool
+}
 
-	// send a -128 to force the arduino to restart and rerequest data
-	s.Write([]byte{ResetCommand})
-	for stepDataOpen := true; stepDataOpen; {
+// Create a tracker following mouse in the system, the pen starts up
+func newMouseTracker(mouse MouseReader, polarSystem PolarSystem) *mouseTracker {
+	return &mouseTracker{
+		mouse:       mouse,
+		events:      mouse.ButtonEvents(),
+		polarSystem: polarSystem,
+		maxDistance: 64 * (Settings.MaxSpeed_MM_S * TimeSlice_US / 1000000.0),
+		pressed:     make(map[MouseButton]bool),
+		penUp:       true,
+	}
+}
+
+// String lengths to move to in each slice of the next batch, or exit and the left or right button that was clicked.
+// A middle click raises or lowers the pen
+func (tracker *mouseTracker) Targets(current PolarCoordinate, slices int) (targets []PolarCoordinate, clicked MouseButton, exit bool) {
+	for pending := true; pending; {
+		select {
+		case event, open := <-tracker.events:
+			if !open {
+				tracker.events = nil
+				break
+			}
+
+			// a click is a press followed by a release
+			if event.Pressed {
+				tracker.pressed[event.Button] = true
+				break
+			}
+			if !tracker.pressed[event.Button] {
+				break
+			}
+			tracker.pressed[event.Button] = false
+			if event.Button == MouseMiddle {
+				tracker.penUp = !tracker.penUp
+			} else {
+				return nil, event.Button, true
+			}
+		default:
+			pending = false
+		}
+	}
+
+	currentPos := current.ToCoord(tracker.polarSystem)
+	mousePos, ok := tracker.mouse.GetPos()
+	if !ok {
+		mousePos = currentPos
+	} else if tracker.mouse.Absolute() {
+		mousePos = mousePos.Minus(Coordinate{X: tracker.polarSystem.XOffset, Y: tracker.polarSystem.YOffset})
+	}
+	//fmt.Println("Got mouse pos", mousePos)
+
+	direction := mousePos.Minus(currentPos)
+	distance := direction.Len()
+	if distance == 0.0 {
+		direction = Coordinate{X: 1, Y: 0}
+	} else {
+		direction = direction.Normalized()
+	}
+	if distance > tracker.maxDistance {
+		distance = tracker.maxDistance
+	}
+
+	targets = make([]PolarCoordinate, slices)
+	for slice := range targets {
+		sliceTarget := currentPos.Add(direction.Scaled(float64(2*slice) * distance / 128.0))
+		sliceTarget.PenUp = tracker.penUp
+		targets[slice] = sliceTarget.ToPolar(tracker.polarSystem)
+	}
+	return targets, 0, false
+}
+
+// Sends steps in realtime as the stepper driver requests them, used to move the pen interactively
+type RealtimeMover struct {
+	PolarSystem PolarSystem // with 0,0 at the position of the pen when the mover was created
+
+	spool    *SpoolModel
+	position PolarCoordinate // string lengths the steps sent so far move to
+}
+
+// Create a mover starting from the position in settings, the pen is up after the ResetCommand sent when connecting
+func NewRealtimeMover() *RealtimeMover {
+	realtime := &RealtimeMover{
+		PolarSystem: PolarSystemFromSettings(),
+		spool:       SpoolModelFromSettings(),
+		position:    PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM, PenUp: true},
+	}
+	startingPos := realtime.position.ToCoord(realtime.PolarSystem)
+	realtime.PolarSystem.XOffset = startingPos.X
+	realtime.PolarSystem.YOffset = startingPos.Y
+	return realtime
+}
+
+// String lengths the steps sent so far move to
+func (realtime *RealtimeMover) Position() PolarCoordinate {
+	return realtime.position
+}
+
+// Each time the stepper driver requests data, ask next for the string lengths to move to in each of the requested slices, starting from the current lengths.
+// A target with a different PenUp raises or lowers the pen instead of moving, returns once next is done
+func (realtime *RealtimeMover) Run(conn *StepperConnection, next func(current PolarCoordinate, slices int) (targets []PolarCoordinate, done bool)) {
+	for {
 		// wait for next data request
-		n, err := s.Read(readData)
+		writeData, err := conn.WaitForRequest()
 		if err != nil {
 			panic(err)
 		}
-		if n != 1 {
-			panic(err)
-		}
 
-		if mouse.GetLeftButton() {
-			updateSettingsPosition(currentPos, polarSystem)
-			return
-		} else if mouse.GetRightButton() {
-			promptForSettingsPosition(polarSystem)
+		targets, done := next(realtime.position, len(writeData)/2)
+		if done {
 			return
 		}
 
-		mouseX, mouseY := mouse.GetPos()
-		mousePos := Coordinate{X: float64(mouseX) / 20.0, Y: float64(mouseY) / 20.0}
-		direction := mousePos.Minus(currentPos)
-		distance := direction.Len()
-		if distance == 0.0 {
-			direction = Coordinate{X: 1, Y: 0}
-		} else {
-			direction = direction.Normalized()
-		}
-		if distance > maxDistance {
-			distance = maxDistance
+		for i := 0; i < len(writeData); i += 2 {
+			if i/2 >= len(targets) {
+				writeData[i], writeData[i+1] = 0, 0
+				continue
+			}
+			writeData[i], writeData[i+1] = realtime.sliceData(targets[i/2])
 		}
-		//fmt.Println("Got mouse pos", mousePos)
 
-		dataToWrite := int(readData[0])
-		for i := 0; i < dataToWrite; i += 2 {
-
-			sliceTarget := currentPos.Add(direction.Scaled(float64(i) * distance / 128.0))
-			polarSliceTarget := sliceTarget.ToPolar(polarSystem)
+		if err := conn.Send(writeData); err != nil {
+			panic(err)
+		}
+	}
+}
 
-			//fmt.Println("i", i, "pos", currentPos, "target", sliceTarget);
+// Step data pair for a slice moving towards target
+func (realtime *RealtimeMover) sliceData(target PolarCoordinate) (left, right byte) {
+	if target.PenUp != realtime.position.PenUp {
+		realtime.position.PenUp = target.PenUp
+		command := PenDownCommand
+		if target.PenUp {
+			command = PenUpCommand
+		}
+		return byte(command), byte(command)
+	}
 
-			sliceSteps := polarSliceTarget.
-				Minus(previousPolarPos).
-				Scaled(StepsFixedPointFactor/Settings.StepSize_MM).
-				Ceil().
-				Clamp(StepsMaxValue, -StepsMaxValue)
-			previousPolarPos = previousPolarPos.
-				Add(sliceSteps.Scaled(Settings.StepSize_MM / StepsFixedPointFactor))
+	//fmt.Println("target", target);
 
-			writeData[i] = byte(int8(-sliceSteps.LeftDist))
-			writeData[i+1] = byte(int8(sliceSteps.RightDist))
-		}
-		currentPos = previousPolarPos.ToCoord(polarSystem)
+	sliceSteps := realtime.spool.StepsBetween(realtime.position, target).
+		Ceil().
+		Clamp(StepsMaxValue, -StepsMaxValue)
+	realtime.position = realtime.spool.Moved(realtime.position, sliceSteps)
 
-		s.Write(writeData)
-	}
+	return byte(int8(-sliceSteps.LeftDist)), byte(int8(sliceSteps.RightDist))
 }
 
 // Update settings with the current position of the pen
@@ -376,7 +734,9 @@ func updateSettingsPosition(currentPos Coordinate, polarSystem PolarSystem) {
 
 	Settings.StartingLeftDist_MM = finalPolarPos.LeftDist
 	Settings.StartingRightDist_MM = finalPolarPos.RightDist
-	Settings.Write()
+	if err := SavePosition(finalPolarPos); err != nil {
+		panic(err)
+	}
 }
 
 // Ask user for X Y location and then update settings
@@ -393,5 +753,7 @@ func promptForSettingsPosition(polarSystem PolarSystem) {
 
 	Settings.StartingLeftDist_MM = finalPolarPos.LeftDist
 	Settings.StartingRightDist_MM = finalPolarPos.RightDist
-	Settings.Write()
+	if err := SavePosition(finalPolarPos); err != nil {
+		panic(err)
+	}
 }
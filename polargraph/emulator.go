@@ -0,0 +1,263 @@
+package polargraph
+
+// Software emulation of the StepperDriver.ino side of the protocol, so the driver can be run without an arduino
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// These values mirror StepperDriver.ino
+const (
+	// Size of the arduino's circular move data buffer
+	emulatorMoveDataCapacity = 1024
+
+	// Number of bytes requested at a time
+	emulatorRequestSize = 128
+)
+
+// Transport type that runs a StepperEmulator in process, address is either fast or realtime
+const EmulatorTransport = "emulator"
+
+// State tracked by the emulator, matches the variables kept by the arduino
+type EmulatorState struct {
+	// Position of each spool in fixed point steps, StepsFixedPointFactor per step, positive left is retracting string
+	LeftPos, RightPos int64
+
+	// Current pen state, the arduino raises the pen on reset
+	PenUp bool
+
+	// Number of pen up or pen down commands executed
+	PenTransitions int
+
+	// Number of move slices executed, not counting idle slices when the buffer was empty
+	Slices int64
+
+	// Number of ResetCommands received
+	Resets int
+
+	// Time the real hardware would have spent executing the received data
+	SimulatedTime time.Duration
+}
+
+// Convert the spool positions into string lengths, given the lengths when the emulator was last reset
+func (state EmulatorState) PolarPosition(start PolarCoordinate) PolarCoordinate {
+	position := SpoolModelFromSettings().Moved(start, PolarCoordinate{LeftDist: -float64(state.LeftPos), RightDist: float64(state.RightPos)})
+	position.PenUp = state.PenUp
+	return position
+}
+
+// Emulates the arduino, reading move data from a connection and requesting more as its buffer empties
+type StepperEmulator struct {
+	// When true slices are executed at the rate the hardware would, otherwise data is consumed as fast as it arrives
+	Realtime bool
+
+	// Constants reported in reply to two ResetCommands in a row
+	Firmware FirmwareInfo
+
+	// When true the emulator behaves like firmware from before the handshake and never reports its constants
+	Legacy bool
+
+	mutex sync.Mutex
+	state EmulatorState
+
+	moveData       []int8 // move data that has been received but not executed
+	requestPending int    // number of bytes requested and not yet received
+	cooldownSlices int    // remaining slices to wait for a pen transition, only used when Realtime
+	started        bool   // requests are only sent once the host has reset the emulator
+	previousReset  bool   // the last byte received was a ResetCommand that was not replied to
+
+	done chan struct{} // closed when Run returns
+}
+
+// Create an emulator in its power on state
+func NewStepperEmulator(realtime bool) *StepperEmulator {
+	return &StepperEmulator{
+		Realtime: realtime,
+		Firmware: HostFirmwareInfo(),
+		state:    EmulatorState{PenUp: true},
+		moveData: make([]int8, 0, emulatorMoveDataCapacity),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start an emulator in a goroutine, returning the host end of an in process connection to it
+func StartEmulator(realtime bool) (Transport, *StepperEmulator) {
+	host, device := net.Pipe()
+	emulator := NewStepperEmulator(realtime)
+
+	go func() {
+		emulator.Run(device)
+		device.Close()
+	}()
+
+	return host, emulator
+}
+
+// Return a copy of the current state
+func (emu *StepperEmulator) State() EmulatorState {
+	emu.mutex.Lock()
+	defer emu.mutex.Unlock()
+
+	return emu.state
+}
+
+// Wait for Run to return and then return the final state
+func (emu *StepperEmulator) Wait() EmulatorState {
+	<-emu.done
+	return emu.State()
+}
+
+// Serve the protocol on the given connection until it is closed, any remaining buffered data is executed before returning
+func (emu *StepperEmulator) Run(conn io.ReadWriter) error {
+	defer close(emu.done)
+
+	incoming := make(chan byte, emulatorRequestSize)
+	readErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			value, err := reader.ReadByte()
+			if err != nil {
+				readErr <- err
+				close(incoming)
+				return
+			}
+			incoming <- value
+		}
+	}()
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if emu.Realtime {
+		ticker = time.NewTicker(time.Duration(TimeSlice_US) * time.Microsecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if err := emu.requestMoreData(conn); err != nil {
+			// the host hung up instead of reading the request
+			emu.executeAll()
+			if err == io.ErrClosedPipe {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case value, open := <-incoming:
+			if !open {
+				emu.executeAll()
+
+				if err := <-readErr; err != io.EOF {
+					return err
+				}
+				return nil
+			}
+			if emu.receive(value) {
+				reply := append([]byte{CapabilitiesHeader}, emu.Firmware.encode()...)
+				if _, err := conn.Write(reply); err != nil {
+					emu.executeAll()
+					return err
+				}
+			}
+
+			if !emu.Realtime && emu.requestPending == 0 {
+				emu.executeAll()
+			}
+
+		case <-tick:
+			emu.executeSlice()
+		}
+	}
+}
+
+// Request another block of data if there is room for it, same as RequestMoreSerialMoveData
+func (emu *StepperEmulator) requestMoreData(conn io.Writer) error {
+	if !emu.started || emu.requestPending > 0 || emulatorMoveDataCapacity-len(emu.moveData) < emulatorRequestSize {
+		return nil
+	}
+
+	emu.requestPending = emulatorRequestSize
+	_, err := conn.Write([]byte{emulatorRequestSize})
+	return err
+}
+
+// Handle a single received byte, same as ReadSerialMoveData, returns true if the constants should be reported
+func (emu *StepperEmulator) receive(value byte) bool {
+
+	if value == ResetCommand {
+		emu.mutex.Lock()
+		emu.state = EmulatorState{
+			PenUp:         true,
+			Resets:        emu.state.Resets + 1,
+			SimulatedTime: emu.state.SimulatedTime,
+		}
+		emu.mutex.Unlock()
+
+		emu.moveData = emu.moveData[:0]
+		emu.requestPending = 0
+		emu.cooldownSlices = 0
+		emu.started = true
+
+		reply := emu.previousReset && !emu.Legacy
+		emu.previousReset = !reply
+		return reply
+	}
+
+	emu.previousReset = false
+	if !emu.started {
+		return false
+	}
+
+	emu.moveData = append(emu.moveData, int8(value))
+	emu.requestPending--
+	return false
+}
+
+// Execute every complete slice in the buffer
+func (emu *StepperEmulator) executeAll() {
+	for len(emu.moveData) >= 2 {
+		emu.executeSlice()
+	}
+}
+
+// Execute the next slice, same as SetSliceVariables
+func (emu *StepperEmulator) executeSlice() {
+
+	if emu.cooldownSlices > 0 {
+		emu.cooldownSlices--
+		return
+	}
+	if len(emu.moveData) < 2 {
+		return
+	}
+
+	leftDelta := emu.moveData[0]
+	rightDelta := emu.moveData[1]
+	emu.moveData = emu.moveData[:copy(emu.moveData, emu.moveData[2:])]
+
+	emu.mutex.Lock()
+	defer emu.mutex.Unlock()
+
+	switch leftDelta {
+	case PenUpCommand, PenDownCommand:
+		emu.state.PenUp = leftDelta == PenUpCommand
+		emu.state.PenTransitions++
+		emu.state.SimulatedTime += time.Duration(PenTransitionCooldown_US) * time.Microsecond
+		if emu.Realtime {
+			emu.cooldownSlices = int(math.Ceil(PenTransitionCooldown_US / TimeSlice_US))
+		}
+
+	default:
+		emu.state.LeftPos += int64(leftDelta)
+		emu.state.RightPos += int64(rightDelta)
+		emu.state.Slices++
+		emu.state.SimulatedTime += time.Duration(TimeSlice_US) * time.Microsecond
+	}
+}
@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package polargraph
+
+// Serves the StepperEmulator on a pseudo terminal, so that a separate gocupi process can open it as a serial port
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Open a pseudo terminal and run the emulator on its master side in a goroutine, returns the path of the device to open as a serial port
+func ServeEmulatorOnPty(emulator *StepperEmulator) (string, error) {
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var unlock int32 = 0
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return "", err
+	}
+	var ptyNumber uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&ptyNumber)); err != nil {
+		master.Close()
+		return "", err
+	}
+	devicePath := fmt.Sprint("/dev/pts/", ptyNumber)
+
+	// keep the device open so the master side does not see an error each time a host disconnects,
+	// and put it in raw mode so the requests written by the emulator are not echoed back to it
+	device, err := os.OpenFile(devicePath, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return "", err
+	}
+	var termios syscall.Termios
+	if err := ioctl(device, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		device.Close()
+		return "", err
+	}
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	if err := ioctl(device, syscall.TCSETS, unsafe.Pointer(&termios)); err != nil {
+		master.Close()
+		device.Close()
+		return "", err
+	}
+
+	go func() {
+		emulator.Run(master)
+		master.Close()
+		device.Close()
+	}()
+
+	return devicePath, nil
+}
+
+// Perform an ioctl on the given file
+func ioctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package polargraph
+
+import (
+	"errors"
+)
+
+// Pseudo terminals are only supported on linux, use -transport=emulator:realtime instead
+func ServeEmulatorOnPty(emulator *StepperEmulator) (string, error) {
+	return "", errors.New("Serving the emulator on a pseudo terminal is only supported on linux")
+}
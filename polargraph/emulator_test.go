@@ -0,0 +1,97 @@
+package polargraph
+
+// Tests for the stepper driver emulator
+
+import (
+	"math"
+	"testing"
+)
+
+// Setup Settings with a typical machine, so tests do not depend on gocupi_config.xml
+func setupTestSettings() {
+	Settings = SettingsData{
+		SpoolCircumference_MM:      60,
+		SpoolSingleStep_Degrees:    0.225,
+		Acceleration_Seconds:       0.5,
+		SpoolHorizontalDistance_MM: 1000,
+		DrawingSurfaceMinY_MM:      50,
+		DrawingSurfaceMaxY_MM:      2000,
+		DrawingSurfaceMinX_MM:      25,
+		StartingLeftDist_MM:        600,
+		StartingRightDist_MM:       600,
+	}
+	Settings.CalculateDerivedFields()
+}
+
+// A job sent through the full driver path should leave the emulator back at the starting position
+func TestEmulatorRunsJob(t *testing.T) {
+	setupTestSettings()
+
+	plotCoords := make(chan Coordinate, 1024)
+	go func() {
+		plotCoords <- Coordinate{X: 0, Y: 0}
+		plotCoords <- Coordinate{X: 50, Y: 0}
+		plotCoords <- Coordinate{X: 50, Y: 50}
+		plotCoords <- Coordinate{X: 0, Y: 50, PenUp: true}
+		plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+		close(plotCoords)
+	}()
+
+	stepData := make(chan int8, 1024)
+	go GenerateSteps(plotCoords, stepData)
+
+	transport, emulator := StartEmulator(false)
+	conn, err := NewStepperConnection(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewStepWriter(false).Write(conn, stepData)
+	conn.Close()
+
+	state := emulator.Wait()
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	final := state.PolarPosition(start)
+
+	if math.Abs(final.LeftDist-start.LeftDist) > Settings.StepSize_MM || math.Abs(final.RightDist-start.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected to end at", start, "and ended at", final)
+	}
+	if state.Resets != 2 {
+		t.Error("Expected the two handshake resets and saw", state.Resets)
+	}
+	if state.PenTransitions != 2 || !state.PenUp {
+		t.Error("Expected pen down then pen up and saw", state.PenTransitions, "transitions, PenUp", state.PenUp)
+	}
+	if state.Slices == 0 {
+		t.Error("Expected slices to be executed")
+	}
+}
+
+// Data received before the host resets the emulator is ignored, and reset clears the position
+func TestEmulatorReset(t *testing.T) {
+	emulator := NewStepperEmulator(false)
+
+	emulator.receive(10)
+	if len(emulator.moveData) != 0 {
+		t.Error("Expected data before reset to be ignored")
+	}
+
+	emulator.receive(ResetCommand)
+	emulator.requestPending = 4
+	leftSteps := int8(-5)
+	emulator.receive(byte(leftSteps))
+	emulator.receive(7)
+	emulator.receive(byte(PenDownCommand))
+	emulator.receive(byte(PenDownCommand))
+	emulator.executeAll()
+
+	state := emulator.State()
+	if state.LeftPos != -5 || state.RightPos != 7 || state.PenUp || state.Slices != 1 {
+		t.Error("Unexpected state after executing slices", state)
+	}
+
+	emulator.receive(ResetCommand)
+	state = emulator.State()
+	if state.LeftPos != 0 || state.RightPos != 0 || !state.PenUp || state.Resets != 2 {
+		t.Error("Unexpected state after reset", state)
+	}
+}
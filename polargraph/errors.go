@@ -0,0 +1,74 @@
+package polargraph
+
+// Errors found while reading input files, carrying where in the file the problem is
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// An error in an input file
+type ParseError struct {
+	// Name of the file, empty when the data did not come from a file
+	File string
+
+	// Where in the file the error is, such as line 12, path "outline" token 7 "1.2.3" or field SerialBaud
+	Location string
+
+	// What is wrong
+	Err error
+}
+
+// ParseError ToString
+func (err *ParseError) Error() string {
+	message := err.Err.Error()
+	if err.Location != "" {
+		message = err.Location + ": " + message
+	}
+	if err.File != "" {
+		message = err.File + ": " + message
+	}
+	return message
+}
+
+// Create a ParseError at the given location
+func newParseError(location string, err error) *ParseError {
+	return &ParseError{Location: location, Err: err}
+}
+
+// Create a ParseError at the given location with a formatted message
+func parseErrorf(location string, format string, args ...interface{}) *ParseError {
+	return &ParseError{Location: location, Err: fmt.Errorf(format, args...)}
+}
+
+// Add the file name to err, and a location in front of any location it already has
+func wrapParseError(err error, fileName, location string) error {
+	if err == nil {
+		return nil
+	}
+
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		parseErr = &ParseError{Err: err}
+		// xml syntax errors know which line they are on
+		if syntaxErr, isSyntax := err.(*xml.SyntaxError); isSyntax {
+			parseErr.Location = fmt.Sprint("line ", syntaxErr.Line)
+			parseErr.Err = fmt.Errorf("%s", syntaxErr.Msg)
+		}
+	} else {
+		copied := *parseErr
+		parseErr = &copied
+	}
+
+	if location != "" {
+		if parseErr.Location != "" {
+			parseErr.Location = location + " " + parseErr.Location
+		} else {
+			parseErr.Location = location
+		}
+	}
+	if fileName != "" {
+		parseErr.File = fileName
+	}
+	return parseErr
+}
@@ -0,0 +1,62 @@
+package polargraph
+
+import (
+	"strings"
+	"testing"
+)
+
+// Errors in svg path data should name the path and the token
+func TestSvgErrorLocation(t *testing.T) {
+	svgText := `<svg>
+  <path id="first" d="M 0,0 10,10"/>
+  <path id="outline" d="M 0,0 L 10,1.2.3"/>
+</svg>`
+
+	_, err := ParseSvg(strings.NewReader(svgText))
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	expected := `path "outline" token 6 "1.2.3": Expected a number`
+	if err.Error() != expected {
+		t.Error("Expected", expected, "got", err)
+	}
+
+	_, err = ParseSvg(strings.NewReader(`<svg><path d="M 0,0 Q 1,1"/></svg>`))
+	if err == nil || !strings.HasPrefix(err.Error(), `path 0 token 7: Expected a number but the path ended`) {
+		t.Error("Expected an error after the Q control point, got", err)
+	}
+
+	_, err = ParseSvg(strings.NewReader("<svg>\n<path d=\"M 0,0\">\n</svg>"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Error("Expected an xml syntax error on line 3, got", err)
+	}
+}
+
+// Errors in gcode should give the line number
+func TestGcodeErrorLocation(t *testing.T) {
+	_, err := ParseGcode([]string{"G00 X1 Y2", "G01 X3 Yfour"})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Error("Expected an error on line 2, got", err)
+	}
+
+	data, err := ParseGcode([]string{"G00 X1 Y2;", "G01 X3 Y4"})
+	if err != nil || len(data.Lines) != 2 {
+		t.Error("Expected 2 lines and no error, got", data.Lines, err)
+	}
+}
+
+// Errors in a setting should name the field
+func TestSettingsErrorLocation(t *testing.T) {
+	var settings SettingsData
+	err := settings.unmarshal([]byte("<SettingsData><SerialBaud>57600</SerialBaud><SpoolCircumference_MM>sixty</SpoolCircumference_MM></SettingsData>"))
+	if err == nil || !strings.HasPrefix(err.Error(), "field SpoolCircumference_MM:") {
+		t.Error("Expected an error in SpoolCircumference_MM, got", err)
+	}
+	if settings.SerialBaud != 57600 {
+		t.Error("Expected SerialBaud 57600, got", settings.SerialBaud)
+	}
+
+	if err := settings.SetField("StepSize_MM", "1"); err == nil {
+		t.Error("Expected derived fields to not be settable")
+	}
+}
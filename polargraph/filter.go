@@ -0,0 +1,354 @@
+package polargraph
+
+// Filters that transform a stream of coordinates, and a registry of them so they can be chained from command line flags
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Transforms a stream of coordinates
+type Filter interface {
+	// Read coordinates until coords is closed, writing the result to filtered, and then close filtered
+	Filter(coords <-chan Coordinate, filtered chan<- Coordinate)
+}
+
+// Adapts a function to the Filter interface
+type FilterFunc func(coords <-chan Coordinate, filtered chan<- Coordinate)
+
+// Call the function
+func (filterFunc FilterFunc) Filter(coords <-chan Coordinate, filtered chan<- Coordinate) {
+	filterFunc(coords, filtered)
+}
+
+// Describes a filter that can be created by name
+type FilterDefinition struct {
+	// Description of the filter and its parameters, shown in the help
+	Help string
+
+	// The filter takes no parameters, so its flag is used like -flipx instead of -flipx=value
+	Boolean bool
+
+	// Create the filter from its parameters, such as 10,20 for -translate=10,20
+	Create func(params string) (Filter, error)
+}
+
+// All filters that can be created by name
+var filterRegistry = map[string]FilterDefinition{}
+
+// Add a filter to the registry, making it available as a command line flag
+func RegisterFilter(name string, definition FilterDefinition) {
+	if _, exists := filterRegistry[name]; exists {
+		panic(fmt.Sprint("Filter ", name, " is already registered"))
+	}
+	filterRegistry[name] = definition
+}
+
+// Create a registered filter
+func NewFilter(name, params string) (Filter, error) {
+	definition, ok := filterRegistry[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprint("Unknown filter ", name))
+	}
+	filter, err := definition.Create(params)
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("Invalid parameters for -", name, ": ", err))
+	}
+	return filter, nil
+}
+
+// Names of the registered filters in sorted order
+func FilterNames() []string {
+	var names []string
+	for name := range filterRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Help text of a registered filter
+func FilterHelp(name string) string {
+	return filterRegistry[name].Help
+}
+
+// Filters applied in order
+type FilterChain []Filter
+
+// Create a registered filter and add it to the end of the chain
+func (chain *FilterChain) Add(name, params string) error {
+	filter, err := NewFilter(name, params)
+	if err != nil {
+		return err
+	}
+	*chain = append(*chain, filter)
+	return nil
+}
+
+// Run each filter in its own goroutine, returning the output of the last one
+func (chain FilterChain) Apply(coords <-chan Coordinate) <-chan Coordinate {
+	for _, filter := range chain {
+		filtered := make(chan Coordinate, 1024)
+		go filter.Filter(coords, filtered)
+		coords = filtered
+	}
+	return coords
+}
+
+// Register a flag for each filter, filters are added to the chain in the order the flags are given
+func (chain *FilterChain) AddFlags(flagSet *flag.FlagSet) {
+	for _, name := range FilterNames() {
+		flagSet.Var(&filterFlag{name: name, chain: chain}, name, FilterHelp(name))
+	}
+}
+
+// flag.Value that adds a filter to a chain each time the flag is given
+type filterFlag struct {
+	name  string
+	chain *FilterChain
+}
+
+// Value of the flag, there is no single value since the flag can be repeated
+func (value *filterFlag) String() string {
+	return ""
+}
+
+// Add the filter for this occurrence of the flag
+func (value *filterFlag) Set(params string) error {
+	if value.IsBoolFlag() {
+		enabled, err := strconv.ParseBool(params)
+		if err != nil || !enabled {
+			return err
+		}
+		params = ""
+	}
+	return value.chain.Add(value.name, params)
+}
+
+// Remove every filter from the chain
+func (value *filterFlag) Reset() {
+	*value.chain = nil
+}
+
+// Used by the flag package to allow -flipx without a value
+func (value *filterFlag) IsBoolFlag() bool {
+	return filterRegistry[value.name].Boolean
+}
+
+// Applies the same transform to every coordinate
+type TransformFilter struct {
+	// Must be affine, so curves are transformed by transforming their points
+	Transform func(Coordinate) Coordinate
+}
+
+// Transform each coordinate
+func (filter TransformFilter) Filter(coords <-chan Coordinate, filtered chan<- Coordinate) {
+	defer close(filtered)
+	for coord := range coords {
+		filtered <- coord.Transformed(filter.Transform)
+	}
+}
+
+// Draws the coordinates several times, each copy offset from the previous one
+type RepeatFilter struct {
+	Count  int
+	Offset Coordinate
+}
+
+// Buffer all the coordinates and then output them Count times, travelling with the pen up to the start of each copy
+func (filter RepeatFilter) Filter(coords <-chan Coordinate, filtered chan<- Coordinate) {
+	defer close(filtered)
+
+	var drawing []Coordinate
+	for coord := range coords {
+		drawing = append(drawing, coord)
+	}
+	if len(drawing) == 0 {
+		return
+	}
+
+	for copyIndex := 0; copyIndex < filter.Count; copyIndex++ {
+		offset := filter.Offset.Scaled(float64(copyIndex))
+		offset.PenUp = false
+		move := func(coord Coordinate) Coordinate {
+			return coord.Add(offset)
+		}
+
+		if copyIndex > 0 {
+			start := drawing[0].Add(offset)
+			start.PenUp = true
+			filtered <- start
+		}
+		for _, coord := range drawing {
+			filtered <- coord.Transformed(move)
+		}
+	}
+}
+
+// Remove unnecessary pen up movements, since PenUp for a line segment is determined by the PenUp of the end of the line, need to do special remember previous coord logic
+func RemoveExtraPenUpMovements(coords <-chan Coordinate, cleanedCoords chan<- Coordinate) {
+	defer close(cleanedCoords)
+
+	previous := Coordinate{PenUp: false}
+
+	skipping := false
+
+	for coord := range coords {
+		if previous.PenUp && coord.PenUp {
+			skipping = true
+			previous = coord
+		} else {
+			if skipping {
+				// the skipped moves are where the curve would have started from
+				previous.Curve = nil
+				cleanedCoords <- previous
+				skipping = false
+			}
+			cleanedCoords <- coord
+		}
+		previous = coord
+	}
+}
+
+// Parse a comma separated list of between minCount and maxCount numbers
+func parseFilterParams(params string, minCount, maxCount int) ([]float64, error) {
+	if params == "" {
+		return nil, errors.New(fmt.Sprint("Expected at least ", minCount, " comma separated numbers"))
+	}
+	values := strings.Split(params, ",")
+	if len(values) < minCount || len(values) > maxCount {
+		return nil, errors.New(fmt.Sprint("Expected between ", minCount, " and ", maxCount, " comma separated numbers and saw ", len(values)))
+	}
+
+	numbers := make([]float64, len(values))
+	for index, value := range values {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprint("Unable to parse ", value, " as a float"))
+		}
+		numbers[index] = number
+	}
+	return numbers, nil
+}
+
+// Create a filter that mirrors coordinates across the given axes, x flips left to right and y flips top to bottom
+func mirrorFilter(axes string) (Filter, error) {
+	axes = strings.ToLower(axes)
+	if axes == "" || strings.Trim(axes, "xy") != "" {
+		return nil, errors.New("Expected x, y or xy")
+	}
+
+	flipX := strings.Contains(axes, "x")
+	flipY := strings.Contains(axes, "y")
+	return TransformFilter{func(coord Coordinate) Coordinate {
+		if flipX {
+			coord.X = -coord.X
+		}
+		if flipY {
+			coord.Y = -coord.Y
+		}
+		return coord
+	}}, nil
+}
+
+// Register the built in filters
+func init() {
+	RegisterFilter("rotate", FilterDefinition{
+		Help: "rotate the drawing by # radians clockwise around 0,0",
+		Create: func(params string) (Filter, error) {
+			numbers, err := parseFilterParams(params, 1, 1)
+			if err != nil {
+				return nil, err
+			}
+			sin, cos := math.Sincos(numbers[0])
+			return TransformFilter{func(coord Coordinate) Coordinate {
+				return Coordinate{X: coord.X*cos - coord.Y*sin, Y: coord.X*sin + coord.Y*cos, PenUp: coord.PenUp}
+			}}, nil
+		},
+	})
+
+	RegisterFilter("scale", FilterDefinition{
+		Help: "scale the drawing by # around 0,0, or by x,y to scale each axis separately",
+		Create: func(params string) (Filter, error) {
+			numbers, err := parseFilterParams(params, 1, 2)
+			if err != nil {
+				return nil, err
+			}
+			if len(numbers) == 1 {
+				numbers = append(numbers, numbers[0])
+			}
+			return TransformFilter{func(coord Coordinate) Coordinate {
+				return coord.ScaledBoth(numbers[0], numbers[1])
+			}}, nil
+		},
+	})
+
+	RegisterFilter("translate", FilterDefinition{
+		Help: "move the drawing by x,y",
+		Create: func(params string) (Filter, error) {
+			numbers, err := parseFilterParams(params, 2, 2)
+			if err != nil {
+				return nil, err
+			}
+			offset := Coordinate{X: numbers[0], Y: numbers[1]}
+			return TransformFilter{func(coord Coordinate) Coordinate {
+				return coord.Add(offset)
+			}}, nil
+		},
+	})
+
+	RegisterFilter("mirror", FilterDefinition{
+		Help:   "mirror the drawing across x, y or xy",
+		Create: mirrorFilter,
+	})
+
+	RegisterFilter("flipx", FilterDefinition{
+		Help:    "flip the drawing left to right, same as -mirror=x",
+		Boolean: true,
+		Create: func(params string) (Filter, error) {
+			return mirrorFilter("x")
+		},
+	})
+
+	RegisterFilter("flipy", FilterDefinition{
+		Help:    "flip the drawing top to bottom, same as -mirror=y",
+		Boolean: true,
+		Create: func(params string) (Filter, error) {
+			return mirrorFilter("y")
+		},
+	})
+
+	RegisterFilter("repeat", FilterDefinition{
+		Help: "draw the drawing # times, or n,x,y to offset each copy by x,y from the previous one",
+		Create: func(params string) (Filter, error) {
+			numbers, err := parseFilterParams(params, 1, 3)
+			if err != nil {
+				return nil, err
+			}
+			if numbers[0] < 1 || numbers[0] != math.Floor(numbers[0]) {
+				return nil, errors.New("Expected a whole number of repeats")
+			}
+			if len(numbers) == 2 {
+				return nil, errors.New("Expected both an x and y offset")
+			}
+			filter := RepeatFilter{Count: int(numbers[0])}
+			if len(numbers) == 3 {
+				filter.Offset = Coordinate{X: numbers[1], Y: numbers[2]}
+			}
+			return filter, nil
+		},
+	})
+
+	RegisterFilter("removepenup", FilterDefinition{
+		Help:    "remove consecutive pen up moves, only travelling to the start of the next line",
+		Boolean: true,
+		Create: func(params string) (Filter, error) {
+			return FilterFunc(RemoveExtraPenUpMovements), nil
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package polargraph
+
+// Tests for coordinate filters
+
+import (
+	"flag"
+	"math"
+	"testing"
+)
+
+// Send coordinates through a chain and collect the result
+func applyTestChain(chain FilterChain, coords ...Coordinate) []Coordinate {
+	input := make(chan Coordinate, len(coords))
+	for _, coord := range coords {
+		input <- coord
+	}
+	close(input)
+
+	var result []Coordinate
+	for coord := range chain.Apply(input) {
+		result = append(result, coord)
+	}
+	return result
+}
+
+// Filters should be applied in the order their flags are given
+func TestFilterFlagsOrder(t *testing.T) {
+	var chain FilterChain
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	chain.AddFlags(flagSet)
+
+	if err := flagSet.Parse([]string{"-translate=10,0", "-scale=2", "-flipy", "-rotate=1.5707963267948966", "command"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 4 || flagSet.Arg(0) != "command" {
+		t.Fatal("Expected 4 filters and saw", len(chain))
+	}
+
+	result := applyTestChain(chain, Coordinate{X: 1, Y: 1})
+	// translate to 11,1, scale to 22,2, flipy to 22,-2, rotate to 2,22
+	if len(result) != 1 || math.Abs(result[0].X-2) > 1e-9 || math.Abs(result[0].Y-22) > 1e-9 {
+		t.Error("Expected [ 2.00, 22.00 ] and saw", result)
+	}
+}
+
+// Repeat should draw each copy offset from the previous one, travelling to each start with the pen up
+func TestRepeatFilter(t *testing.T) {
+	var chain FilterChain
+	if err := chain.Add("repeat", "3,5,0"); err != nil {
+		t.Fatal(err)
+	}
+
+	result := applyTestChain(chain, Coordinate{X: 0, Y: 0}, Coordinate{X: 1, Y: 0})
+	expected := []Coordinate{
+		{X: 0, Y: 0}, {X: 1, Y: 0},
+		{X: 5, Y: 0, PenUp: true}, {X: 5, Y: 0}, {X: 6, Y: 0},
+		{X: 10, Y: 0, PenUp: true}, {X: 10, Y: 0}, {X: 11, Y: 0},
+	}
+	if len(result) != len(expected) {
+		t.Fatal("Expected", expected, "and saw", result)
+	}
+	for index := range expected {
+		if result[index] != expected[index] {
+			t.Error("Expected", expected, "and saw", result)
+			break
+		}
+	}
+}
+
+// Invalid parameters should be reported when the flag is parsed
+func TestFilterInvalidParams(t *testing.T) {
+	var chain FilterChain
+	for _, params := range [][2]string{{"translate", "10"}, {"repeat", "0"}, {"mirror", "z"}, {"scale", "a"}, {"unknown", ""}} {
+		if err := chain.Add(params[0], params[1]); err == nil {
+			t.Error("Expected an error for", params)
+		}
+	}
+}
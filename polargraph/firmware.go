@@ -0,0 +1,100 @@
+package polargraph
+
+// Handshake that asks the stepper driver for the timing constants it was built with, so a mismatch with the host can not silently scale every drawing
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Version of the step data protocol spoken by the host
+const ProtocolVersion = 1
+
+// Sent by the stepper driver before its constants in reply to two ResetCommands in a row.
+// Data requests are never more than 128, so it can not be mistaken for one
+const CapabilitiesHeader byte = 0xC1
+
+// Number of bytes of constants following the CapabilitiesHeader
+const capabilitiesSize = 9
+
+// Constants the stepper driver was built with
+type FirmwareInfo struct {
+	ProtocolVersion          int
+	TimeSlice_US             float64
+	StepsFixedPointFactor    float64
+	StepsMaxValue            float64
+	PenTransitionCooldown_US float64
+}
+
+// FirmwareInfo ToString
+func (info FirmwareInfo) String() string {
+	return fmt.Sprintf("protocol %d, time slice %v us, fixed point factor %v, max steps %v, pen cooldown %v us",
+		info.ProtocolVersion, info.TimeSlice_US, info.StepsFixedPointFactor, info.StepsMaxValue, info.PenTransitionCooldown_US)
+}
+
+// Constants the host is currently generating step data with
+func HostFirmwareInfo() FirmwareInfo {
+	return FirmwareInfo{
+		ProtocolVersion:          ProtocolVersion,
+		TimeSlice_US:             TimeSlice_US,
+		StepsFixedPointFactor:    StepsFixedPointFactor,
+		StepsMaxValue:            StepsMaxValue,
+		PenTransitionCooldown_US: PenTransitionCooldown_US,
+	}
+}
+
+// Encode the constants as sent by the stepper driver after the CapabilitiesHeader,
+// the protocol version, time slice as 2 bytes, fixed point factor, max steps, and pen cooldown as 4 bytes, all little endian
+func (info FirmwareInfo) encode() []byte {
+	data := make([]byte, capabilitiesSize)
+	data[0] = byte(info.ProtocolVersion)
+	binary.LittleEndian.PutUint16(data[1:3], uint16(info.TimeSlice_US))
+	data[3] = byte(info.StepsFixedPointFactor)
+	data[4] = byte(info.StepsMaxValue)
+	binary.LittleEndian.PutUint32(data[5:9], uint32(info.PenTransitionCooldown_US))
+	return data
+}
+
+// Decode the constants sent by the stepper driver after the CapabilitiesHeader
+func decodeFirmwareInfo(data []byte) FirmwareInfo {
+	return FirmwareInfo{
+		ProtocolVersion:          int(data[0]),
+		TimeSlice_US:             float64(binary.LittleEndian.Uint16(data[1:3])),
+		StepsFixedPointFactor:    float64(data[3]),
+		StepsMaxValue:            float64(data[4]),
+		PenTransitionCooldown_US: float64(binary.LittleEndian.Uint32(data[5:9])),
+	}
+}
+
+// Returns an error if the host can not drive firmware with these constants
+func (info FirmwareInfo) Validate() error {
+	if info.ProtocolVersion > ProtocolVersion {
+		return errors.New(fmt.Sprint("Stepper driver uses protocol version ", info.ProtocolVersion, " but gocupi only supports up to ", ProtocolVersion, ", update gocupi"))
+	}
+	if info.ProtocolVersion < 1 || info.TimeSlice_US <= 0 || info.StepsFixedPointFactor <= 0 || info.StepsMaxValue <= 1 || info.StepsMaxValue > 126 {
+		return errors.New(fmt.Sprint("Stepper driver reported constants that can not be used, ", info))
+	}
+	return nil
+}
+
+// Generate all step data from now on with the firmware's constants, recalculating the settings derived from them.
+// Returns an error without changing anything if the constants can not be used
+func (info FirmwareInfo) Adopt() error {
+	if err := info.Validate(); err != nil {
+		return err
+	}
+	host := HostFirmwareInfo()
+	host.ProtocolVersion = info.ProtocolVersion
+	if info == host {
+		return nil
+	}
+
+	fmt.Println("Using the stepper driver's constants,", info)
+	TimeSlice_US = info.TimeSlice_US
+	StepsFixedPointFactor = info.StepsFixedPointFactor
+	StepsMaxValue = info.StepsMaxValue
+	PenTransitionCooldown_US = info.PenTransitionCooldown_US
+	Settings.CalculateDerivedFields()
+	return nil
+}
@@ -0,0 +1,129 @@
+package polargraph
+
+import (
+	"testing"
+	"time"
+)
+
+// Put the host constants back after a test adopts different ones
+func restoreFirmwareInfo(info FirmwareInfo) {
+	TimeSlice_US = info.TimeSlice_US
+	StepsFixedPointFactor = info.StepsFixedPointFactor
+	StepsMaxValue = info.StepsMaxValue
+	PenTransitionCooldown_US = info.PenTransitionCooldown_US
+	Settings.CalculateDerivedFields()
+}
+
+// Constants should survive being sent by the stepper driver
+func TestFirmwareInfoEncoding(t *testing.T) {
+	info := FirmwareInfo{ProtocolVersion: 1, TimeSlice_US: 4096, StepsFixedPointFactor: 16, StepsMaxValue: 100, PenTransitionCooldown_US: 800000}
+	if decoded := decodeFirmwareInfo(info.encode()); decoded != info {
+		t.Error("Expected", info, "got", decoded)
+	}
+
+	info.ProtocolVersion = ProtocolVersion + 1
+	if err := info.Validate(); err == nil {
+		t.Error("Expected a newer protocol version to be refused")
+	}
+}
+
+// The host should adopt the constants the stepper driver reports
+func TestHandshakeAdoptsFirmwareInfo(t *testing.T) {
+	setupTestSettings()
+	host := HostFirmwareInfo()
+	defer restoreFirmwareInfo(host)
+
+	transport, emulator := StartEmulator(false)
+	emulator.Firmware.TimeSlice_US = 2 * host.TimeSlice_US
+	conn, err := NewStepperConnection(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !conn.FirmwareReported || TimeSlice_US != 2*host.TimeSlice_US {
+		t.Error("Expected the reported time slice to be adopted, reported", conn.FirmwareReported, "time slice", TimeSlice_US)
+	}
+	if data, err := conn.WaitForRequest(); err != nil || len(data) != emulatorRequestSize {
+		t.Error("Expected a data request after the handshake, got", len(data), err)
+	}
+}
+
+// Firmware that does not answer the handshake should be driven with the host constants, and firmware that is too new refused
+func TestHandshakeFallback(t *testing.T) {
+	setupTestSettings()
+	host := HostFirmwareInfo()
+	defer restoreFirmwareInfo(host)
+	defer func(timeout time.Duration) { HandshakeTimeout = timeout }(HandshakeTimeout)
+	HandshakeTimeout = 50 * time.Millisecond
+
+	transport, emulator := StartEmulator(false)
+	emulator.Legacy = true
+	conn, err := NewStepperConnection(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.FirmwareReported || HostFirmwareInfo() != host {
+		t.Error("Expected the host constants to be kept")
+	}
+	if data, err := conn.WaitForRequest(); err != nil || len(data) != emulatorRequestSize {
+		t.Error("Expected the request read during the handshake, got", len(data), err)
+	}
+	conn.Close()
+
+	transport, emulator = StartEmulator(false)
+	emulator.Firmware.ProtocolVersion = ProtocolVersion + 1
+	emulator.Firmware.TimeSlice_US = 1024
+	if _, err := NewStepperConnection(transport); err == nil {
+		t.Error("Expected a newer protocol version to be refused")
+	}
+	if TimeSlice_US != host.TimeSlice_US {
+		t.Error("Expected the refused constants to not be adopted, time slice", TimeSlice_US)
+	}
+}
+
+// Transport of an arduino that is still booting, writes are lost until it sends its first data request
+type bootingTransport struct {
+	Transport
+	booted chan bool
+}
+
+func (boot *bootingTransport) Read(data []byte) (int, error) {
+	select {
+	case <-boot.booted:
+		return boot.Transport.Read(data)
+	case <-time.After(100 * time.Millisecond):
+		close(boot.booted)
+		data[0] = emulatorRequestSize
+		return 1, nil
+	}
+}
+
+func (boot *bootingTransport) Write(data []byte) (int, error) {
+	select {
+	case <-boot.booted:
+		return boot.Transport.Write(data)
+	default:
+		return len(data), nil
+	}
+}
+
+// The handshake should wait for a stepper driver reset by opening its serial port to boot
+func TestHandshakeWaitsForBoot(t *testing.T) {
+	setupTestSettings()
+	host := HostFirmwareInfo()
+	defer restoreFirmwareInfo(host)
+	defer func(timeout time.Duration) { HandshakeTimeout = timeout }(HandshakeTimeout)
+	HandshakeTimeout = 50 * time.Millisecond
+
+	transport, _ := StartEmulator(false)
+	conn, err := newStepperConnection(&bootingTransport{Transport: transport, booted: make(chan bool)}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if !conn.FirmwareReported {
+		t.Error("Expected the constants to be reported once the stepper driver booted")
+	}
+}
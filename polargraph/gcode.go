@@ -4,6 +4,7 @@ package polargraph
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"math"
 	"os"
@@ -17,8 +18,10 @@ type GcodeCommand int32
 var penupcode float64
 
 const (
-	MOVE_RAPID GcodeCommand = 0
-	MOVE                    = 1
+	MOVE_RAPID           GcodeCommand = 0
+	MOVE                              = 1
+	ARC_CLOCKWISE                     = 2
+	ARC_COUNTERCLOCKWISE              = 3
 
 	SET_UNITS_INCHES = 20
 	SET_UNITS_MM     = 21
@@ -37,63 +40,83 @@ type GcodeData struct {
 }
 
 // read a file and parse its Gcode
-func ParseGcodeFile(fileName string) GcodeData {
+func ParseGcodeFile(fileName string) (GcodeData, error) {
 
 	file, err := os.Open(fileName)
 	if err != nil {
-		panic(err)
+		return GcodeData{}, err
 	}
+	defer file.Close()
 	reader := bufio.NewReader(file)
 
 	lines := make([]string, 0)
 	for {
 		l, err := reader.ReadString('\n')
 		if err == io.EOF {
+			if l != "" {
+				lines = append(lines, strings.TrimSpace(l))
+			}
 			break
 		} else if err != nil {
-			panic(err)
+			return GcodeData{}, err
 		}
 		lines = append(lines, strings.TrimSpace(l))
 	}
 
-	return ParseGcode(lines)
+	data, err := ParseGcode(lines)
+	return data, wrapParseError(err, fileName, "")
+}
+
+// Parse the number following the letter of a part such as X12.5, returns an error located at lineNumber
+func parseGcodeValue(part string, lineNumber int) (float64, error) {
+	part = strings.TrimSuffix(part, ";")
+	value, err := strconv.ParseFloat(part[1:], 64)
+	if err != nil {
+		return 0, parseErrorf(fmt.Sprint("line ", lineNumber), "Expected a number after %s but saw %q", part[:1], part[1:])
+	}
+	return value, nil
 }
 
 // read all of the fileData lines, generating a GcodeData object
-func ParseGcode(fileData []string) (data GcodeData) {
+func ParseGcode(fileData []string) (data GcodeData, err error) {
 
 	data = GcodeData{make([]GcodeLine, 0)}
 
-	for _, fileLine := range fileData {
+	for lineIndex, fileLine := range fileData {
+		lineNumber := lineIndex + 1
 
-		if strings.HasPrefix(fileLine, "G00") || strings.HasPrefix(fileLine, "G01") {
+		isArc := strings.HasPrefix(fileLine, "G02") || strings.HasPrefix(fileLine, "G03")
+		if strings.HasPrefix(fileLine, "G00") || strings.HasPrefix(fileLine, "G01") || isArc {
 
 			coord := Coordinate{X: math.MaxFloat64, Y: math.MaxFloat64, PenUp: false}
+			var centerOffset Coordinate
+			hasCenter := false
 
 			for _, part := range strings.Split(fileLine, " ") {
 
-				var err interface{}
 				if strings.HasPrefix(part, "X") {
-					coord.X, err = strconv.ParseFloat(part[1:], 64)
-					if err != nil {
-						panic(err)
+					if coord.X, err = parseGcodeValue(part, lineNumber); err != nil {
+						return data, err
 					}
 				} else if strings.HasPrefix(part, "Y") {
-					if strings.HasSuffix(part, ";") {
-						part = part[:len(part)-len(";")]
-					}
-					coord.Y, err = strconv.ParseFloat(part[1:], 64)
-					if err != nil {
-						panic(err)
+					if coord.Y, err = parseGcodeValue(part, lineNumber); err != nil {
+						return data, err
 					}
 					coord.Y = -coord.Y
-				} else if strings.HasPrefix(part, "Z") {
-					if strings.HasSuffix(part, ";") {
-						part = part[:len(part)-len(";")]
+				} else if strings.HasPrefix(part, "I") {
+					if centerOffset.X, err = parseGcodeValue(part, lineNumber); err != nil {
+						return data, err
 					}
-					penupcode, err = strconv.ParseFloat(part[1:], 64)
-					if err != nil {
-						panic(err)
+					hasCenter = true
+				} else if strings.HasPrefix(part, "J") {
+					if centerOffset.Y, err = parseGcodeValue(part, lineNumber); err != nil {
+						return data, err
+					}
+					centerOffset.Y = -centerOffset.Y
+					hasCenter = true
+				} else if strings.HasPrefix(part, "Z") {
+					if penupcode, err = parseGcodeValue(part, lineNumber); err != nil {
+						return data, err
 					}
 
 				}
@@ -107,12 +130,52 @@ func ParseGcode(fileData []string) (data GcodeData) {
 				//fmt.Println("PENDOWN")
 			}
 			if coord.X != math.MaxFloat64 && coord.Y != math.MaxFloat64 {
-				data.Lines = append(data.Lines, GcodeLine{Command: MOVE, Dest: coord})
+				if !isArc {
+					data.Lines = append(data.Lines, GcodeLine{Command: MOVE, Dest: coord})
+					continue
+				}
+				if !hasCenter {
+					return data, parseErrorf(fmt.Sprint("line ", lineNumber), "Expected an I or J center offset for the arc")
+				}
+
+				var previous Coordinate
+				if len(data.Lines) > 0 {
+					previous = data.Lines[len(data.Lines)-1].Dest
+				}
+				command := GcodeCommand(ARC_CLOCKWISE)
+				if strings.HasPrefix(fileLine, "G03") {
+					command = ARC_COUNTERCLOCKWISE
+				}
+				data.Lines = append(data.Lines, GcodeLine{Command: command, Dest: gcodeArc(previous, coord, centerOffset, command == ARC_CLOCKWISE)})
 			}
 		}
 	}
 
-	return
+	return data, nil
+}
+
+// Move from previous to dest around the center at previous + centerOffset, the same point for both means a full circle.
+// Y is already negated, which turns the clockwise arcs of G02 into positive sweeps. Dest is moved onto the arc so rounding in the file does not leave a gap
+func gcodeArc(previous, dest, centerOffset Coordinate, clockwise bool) Coordinate {
+	center := previous.Add(centerOffset)
+	center.PenUp = false
+
+	startAngle := math.Atan2(previous.Y-center.Y, previous.X-center.X)
+	endAngle := math.Atan2(dest.Y-center.Y, dest.X-center.X)
+	sweep := endAngle - startAngle
+	if clockwise {
+		for sweep <= 1e-9 {
+			sweep += 2 * math.Pi
+		}
+	} else {
+		for sweep >= -1e-9 {
+			sweep -= 2 * math.Pi
+		}
+	}
+
+	arc := NewCircularArc(center, previous, sweep)
+	end := arc.Point(1)
+	return Coordinate{X: end.X, Y: end.Y, PenUp: dest.PenUp, Curve: arc}
 }
 
 // Given GCodeData, returns all of the
@@ -121,6 +184,6 @@ func GenerateGcodePath(data GcodeData, scale float64, plotCoords chan<- Coordina
 	defer close(plotCoords)
 
 	for _, curTarget := range data.Lines {
-		plotCoords <- curTarget.Dest.Scaled(scale)
+		plotCoords <- curTarget.Dest.Transformed(func(coord Coordinate) Coordinate { return coord.Scaled(scale) })
 	}
 }
@@ -0,0 +1,72 @@
+package polargraph
+
+// Geometry of a gondola whose strings attach away from the pen, so the string lengths put the pen itself where it was asked to go
+
+import (
+	"math"
+)
+
+// Where the strings attach to the gondola relative to the pen tip, +x is to the right and +y is down.
+// The gondola is assumed to hang without rotating
+type Gondola struct {
+	LeftAnchor, RightAnchor Coordinate
+}
+
+// Create the gondola described by settings, nil if both strings attach at the pen
+func GondolaFromSettings() *Gondola {
+	penOffset := Coordinate{X: Settings.PenOffsetX_MM, Y: Settings.PenOffsetY_MM}
+	gondola := &Gondola{
+		LeftAnchor:  Coordinate{X: Settings.GondolaLeftAnchorX_MM, Y: Settings.GondolaLeftAnchorY_MM}.Minus(penOffset),
+		RightAnchor: Coordinate{X: Settings.GondolaRightAnchorX_MM, Y: Settings.GondolaRightAnchorY_MM}.Minus(penOffset),
+	}
+	if gondola.LeftAnchor.Len() == 0 && gondola.RightAnchor.Len() == 0 {
+		return nil
+	}
+	return gondola
+}
+
+// Absolute positions the left and right strings end at when the pen is at the absolute position x,y
+func (system PolarSystem) stringEnds(x, y float64) (left, right Coordinate) {
+	pen := Coordinate{X: x, Y: y}
+	if system.Gondola == nil {
+		return pen, pen
+	}
+	return pen.Add(system.Gondola.LeftAnchor), pen.Add(system.Gondola.RightAnchor)
+}
+
+// Length of the strings when the pen is at the absolute position x,y
+func (system PolarSystem) stringLengths(x, y float64) (left, right float64) {
+	leftEnd, rightEnd := system.stringEnds(x, y)
+	if system.Sag != nil {
+		return system.Sag.StringLengths(leftEnd, rightEnd, system.RightMotorDist)
+	}
+
+	left = math.Sqrt(leftEnd.X*leftEnd.X + leftEnd.Y*leftEnd.Y)
+	xDiff := system.RightMotorDist - rightEnd.X
+	right = math.Sqrt(xDiff*xDiff + rightEnd.Y*rightEnd.Y)
+	return
+}
+
+// Absolute position of the pen that gives the lengths of straight strings. Each string ending at the pen
+// is the same as the motor moved by the opposite of its anchor, so the pen is where circles around the moved motors meet
+func (system PolarSystem) straightPosition(left, right float64) (x, y float64) {
+	if system.Gondola == nil {
+		x = ((left * left) - (right * right) + (system.RightMotorDist * system.RightMotorDist)) / (2.0 * system.RightMotorDist)
+		y = math.Sqrt((left * left) - (x * x))
+		return
+	}
+
+	leftMotor := Coordinate{}.Minus(system.Gondola.LeftAnchor)
+	rightMotor := Coordinate{X: system.RightMotorDist}.Minus(system.Gondola.RightAnchor)
+	between := rightMotor.Minus(leftMotor)
+	distance := between.Len()
+	direction := between.Normalized()
+
+	along := (left*left - right*right + distance*distance) / (2.0 * distance)
+	across := math.Sqrt(left*left - along*along)
+
+	// of the two places the circles meet take the one below the motors
+	x = leftMotor.X + direction.X*along - direction.Y*across
+	y = leftMotor.Y + direction.Y*along + direction.X*across
+	return
+}
@@ -0,0 +1,43 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Strings 30 mm apart with the pen 40 mm below them end at the anchors, and ToCoord finds the pen again
+func TestGondolaAnchors(t *testing.T) {
+	setupTestSettings()
+	Settings.GondolaLeftAnchorX_MM = -15
+	Settings.GondolaRightAnchorX_MM = 15
+	Settings.PenOffsetY_MM = 40
+	system := PolarSystemFromSettings()
+
+	polar := Coordinate{X: 300, Y: 440}.ToPolar(system)
+	if expected := math.Hypot(285, 400); math.Abs(polar.LeftDist-expected) > 1e-9 {
+		t.Error("Expected left string", expected, "got", polar.LeftDist)
+	}
+	if expected := math.Hypot(685, 400); math.Abs(polar.RightDist-expected) > 1e-9 {
+		t.Error("Expected right string", expected, "got", polar.RightDist)
+	}
+
+	for _, coord := range []Coordinate{{X: 300, Y: 440}, {X: 500, Y: 100}, {X: 900, Y: 1800}} {
+		if back := coord.ToPolar(system).ToCoord(system); back.Minus(coord).Len() > 1e-6 {
+			t.Error("Expected", coord, "got", back)
+		}
+	}
+}
+
+// Anchors at different heights work with sagging strings as well
+func TestGondolaAnchorsWithSag(t *testing.T) {
+	system := PolarSystem{
+		XMin: 25, XMax: 975, YMin: 50, YMax: 2000, RightMotorDist: 1000,
+		Sag:     &SagModel{StringWeight: 0.0005, GondolaWeight: 200},
+		Gondola: &Gondola{LeftAnchor: Coordinate{X: -20, Y: -35}, RightAnchor: Coordinate{X: 10, Y: -45}},
+	}
+	for _, coord := range []Coordinate{{X: 500, Y: 800}, {X: 100, Y: 150}, {X: 850, Y: 1500}} {
+		if back := coord.ToPolar(system).ToCoord(system); back.Minus(coord).Len() > 0.001 {
+			t.Error("Expected", coord, "got", back)
+		}
+	}
+}
@@ -9,31 +9,53 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"io"
 	"os"
 )
 
 // Draw coordinates to image
 func DrawToImage(imageName string, plotCoords <-chan Coordinate) {
 
+	file, err := os.OpenFile(imageName, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	if err = WriteImage(file, plotCoords); err != nil {
+		panic(err)
+	}
+}
+
+// 4 pixels = 1mm
+func scaleToPixels(coord Coordinate) Coordinate {
+	return coord.Scaled(4.0)
+}
+
+// Draw coordinates to a png image written to writer
+func WriteImage(writer io.Writer, plotCoords <-chan Coordinate) error {
+
 	// buffer all of the coordinates into a slice in order to figure out the min and max points to know how big the image needs to be
 	points := make([]Coordinate, len(plotCoords))
 	minPoint := Coordinate{X: 100000, Y: 100000}
 	maxPoint := Coordinate{X: -100000, Y: -10000}
 
 	for point := range plotCoords {
-		point = point.Scaled(4.0) // 4 pixels = 1mm
+		point = point.Transformed(scaleToPixels)
 		points = append(points, point)
 
-		if point.X < minPoint.X {
-			minPoint.X = point.X
-		} else if point.X > maxPoint.X {
-			maxPoint.X = point.X
-		}
+		for _, pathPoint := range pathPoints(point) {
+			if pathPoint.X < minPoint.X {
+				minPoint.X = pathPoint.X
+			} else if pathPoint.X > maxPoint.X {
+				maxPoint.X = pathPoint.X
+			}
 
-		if point.Y < minPoint.Y {
-			minPoint.Y = point.Y
-		} else if point.Y > maxPoint.Y {
-			maxPoint.Y = point.Y
+			if pathPoint.Y < minPoint.Y {
+				minPoint.Y = pathPoint.Y
+			} else if pathPoint.Y > maxPoint.Y {
+				maxPoint.Y = pathPoint.Y
+			}
 		}
 	}
 
@@ -46,24 +68,23 @@ func DrawToImage(imageName string, plotCoords <-chan Coordinate) {
 
 	image := image.NewRGBA(image.Rect(0, 0, int(maxPoint.X-minPoint.X), int(maxPoint.Y-minPoint.Y)))
 
-	// plot each point in the image
+	// plot each point in the image, following curves through the points they were sampled at
 	previousPoint := Coordinate{X: 0, Y: 0}
 	for _, point := range points {
 		//image.Set(int(point.X-minPoint.X), int(-(point.Y-minPoint.Y)+2*maxPoint.Y), color.RGBA{0, 0, 0, 255})
+		if point.Curve != nil {
+			for _, pathPoint := range pathPoints(point) {
+				pathPoint.PenUp = point.PenUp
+				drawLine(previousPoint, pathPoint, minPoint, maxPoint, image)
+				previousPoint = pathPoint
+			}
+		}
 		drawLine(previousPoint, point, minPoint, maxPoint, image)
 
 		previousPoint = point
 	}
 
-	file, err := os.OpenFile(imageName, os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	if err = png.Encode(file, image); err != nil {
-		panic(err)
-	}
+	return png.Encode(writer, image)
 }
 
 // Draw a line, from http://41j.com/blog/2012/09/bresenhams-line-drawing-algorithm-implemetations-in-go-and-c/
@@ -133,22 +154,22 @@ func drawLine(start Coordinate, end Coordinate, minPoint Coordinate, maxPoint Co
 }
 
 // Load image data
-func LoadImage(imageFileName string) image.Image {
+func LoadImage(imageFileName string) (image.Image, error) {
 
 	file, err := os.Open(imageFileName)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer file.Close()
 
 	image, format, err := image.Decode(file)
 	if err != nil {
-		panic(err)
+		return nil, &ParseError{File: imageFileName, Err: err}
 	}
 
 	fmt.Println("Loaded", format, "Size", image.Bounds())
 
-	return image
+	return image, nil
 	//return SobelImage(image) // turns out that runnign edge detection first does NOT help the image, it only removes information from the picture
 }
 
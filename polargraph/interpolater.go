@@ -10,14 +10,33 @@ import (
 // Given an origin, dest, nextDest, returns how many slices it will takes to traverse it and what the position at a given slice is
 type PositionInterpolater interface {
 	Setup(origin, dest, nextDest Coordinate)
+	SetupSegment(segment PlannedSegment)
 	Slices() float64
 	Position(slice float64) Coordinate
 	WriteData()
 }
 
+// Names of the interpolaters that can be chosen with Settings.Interpolater
+const (
+	TrapezoidInterpolation = "trapezoid" // acceleration changes instantly
+	SCurveInterpolation    = "scurve"    // acceleration changes at a limited jerk
+)
+
+// Create the interpolater with the given name, an empty name is the trapezoid interpolater
+func NewPositionInterpolater(name string) (PositionInterpolater, error) {
+	switch name {
+	case TrapezoidInterpolation, "":
+		return new(TrapezoidInterpolater), nil
+	case SCurveInterpolation:
+		return new(SCurveInterpolater), nil
+	}
+	return nil, fmt.Errorf("Unknown interpolater %q, expected %s or %s", name, TrapezoidInterpolation, SCurveInterpolation)
+}
+
 type LinearInterpolater struct {
 	origin, destination Coordinate // positions currently interpolating between
 	movement            Coordinate
+	path                *CurvePath // curve followed instead of a straight line, nil for a straight line
 
 	distance float64
 	time     float64
@@ -31,11 +50,25 @@ func (data *LinearInterpolater) Setup(origin, dest, nextDest Coordinate) {
 	data.destination = dest
 	data.movement = data.destination.Minus(data.origin)
 	data.distance = data.movement.Len()
+	data.path = nil
 
 	data.time = data.distance / Settings.MaxSpeed_MM_S
 	data.slices = math.Ceil(data.time / (TimeSlice_US / 1000000))
 }
 
+// setup data for the linear interpolater, moving at the segment's max speed
+func (data *LinearInterpolater) SetupSegment(segment PlannedSegment) {
+
+	data.origin = segment.Origin
+	data.destination = segment.Dest
+	data.movement = data.destination.Minus(data.origin)
+	data.distance = segment.Distance()
+	data.path = segment.Path
+
+	data.time = data.distance / segment.MaxSpeed
+	data.slices = math.Ceil(data.time / (TimeSlice_US / 1000000))
+}
+
 // number of slices needed
 func (data *LinearInterpolater) Slices() float64 {
 	return data.slices
@@ -45,6 +78,9 @@ func (data *LinearInterpolater) Slices() float64 {
 func (data *LinearInterpolater) Position(slice float64) Coordinate {
 
 	percentage := slice / data.slices
+	if data.path != nil {
+		return data.path.At(percentage * data.distance)
+	}
 	return data.origin.Add(data.movement.Scaled(percentage))
 }
 
@@ -58,12 +94,13 @@ type TrapezoidInterpolater struct {
 	origin      Coordinate // positions currently interpolating from
 	destination Coordinate // position currently interpolating towards
 	direction   Coordinate // unit direction vector from origin to destination
+	path        *CurvePath // curve followed instead of a straight line, nil for a straight line
 
 	entrySpeed  float64 // speed at beginning at origin
 	cruiseSpeed float64 // maximum speed reached
 	exitSpeed   float64 // target speed when we reach destination
 
-	acceleration float64 // acceleration, only differs from Settings.Acceleration_MM_S2 when decelerating and there is not enough distance to hit exit speed
+	acceleration float64 // acceleration, only differs from the planned acceleration when decelerating and there is not enough distance to hit exit speed
 
 	distance float64 // total distance travelled
 	time     float64 // total time to go from origin to destination
@@ -95,6 +132,7 @@ func (data *TrapezoidInterpolater) Setup(origin, dest, nextDest Coordinate) {
 
 	// entry speed is whatever the previous exit speed was
 	data.entrySpeed = data.exitSpeed
+	data.path = nil
 
 	// special case of not going anywhere
 	if origin == dest {
@@ -201,6 +239,55 @@ func (data *TrapezoidInterpolater) Setup(origin, dest, nextDest Coordinate) {
 	data.slices = data.time / (TimeSlice_US / 1000000)
 }
 
+// Calculate all fields needed for a segment whose entry and exit speeds were chosen by the MotionPlanner
+func (data *TrapezoidInterpolater) SetupSegment(segment PlannedSegment) {
+
+	data.origin = segment.Origin
+	data.destination = segment.Dest
+	data.direction = data.destination.Minus(data.origin)
+	data.distance = segment.Distance()
+	data.path = segment.Path
+	data.entrySpeed = segment.EntrySpeed
+	data.exitSpeed = segment.ExitSpeed
+	data.acceleration = segment.Acceleration
+
+	if data.distance == 0 {
+		data.direction = Coordinate{X: 0, Y: 1}
+		data.cruiseSpeed = data.entrySpeed
+		data.accelDist, data.accelTime = 0, 0
+		data.cruiseDist, data.cruiseTime = 0, 0
+		data.decelDist, data.decelTime = 0, 0
+		data.time = 0
+		data.slices = 0
+		return
+	}
+	if data.direction.Len() > 0 {
+		// a closed curve ends where it started, its direction is only used for straight lines
+		data.direction = data.direction.Normalized()
+	}
+
+	// the actual origin can differ slightly from the planned one, make sure the speeds are still reachable
+	accel := segment.Acceleration
+	data.exitSpeed = math.Min(data.exitSpeed, reachableSpeed(data.entrySpeed, accel, data.distance))
+	data.entrySpeed = math.Min(data.entrySpeed, reachableSpeed(data.exitSpeed, accel, data.distance))
+
+	// fastest speed that still leaves room to slow down to the exit speed, derived from
+	// distance = (cruise^2 - entry^2) / 2a + (cruise^2 - exit^2) / 2a
+	peakSpeed := math.Sqrt((2*accel*data.distance + data.entrySpeed*data.entrySpeed + data.exitSpeed*data.exitSpeed) / 2)
+	data.cruiseSpeed = math.Max(math.Min(segment.MaxSpeed, peakSpeed), math.Max(data.entrySpeed, data.exitSpeed))
+
+	data.accelTime = (data.cruiseSpeed - data.entrySpeed) / accel
+	data.accelDist = (data.cruiseSpeed*data.cruiseSpeed - data.entrySpeed*data.entrySpeed) / (2 * accel)
+	data.decelTime = (data.cruiseSpeed - data.exitSpeed) / accel
+	data.decelDist = (data.cruiseSpeed*data.cruiseSpeed - data.exitSpeed*data.exitSpeed) / (2 * accel)
+
+	data.cruiseDist = math.Max(data.distance-(data.accelDist+data.decelDist), 0)
+	data.cruiseTime = data.cruiseDist / data.cruiseSpeed
+
+	data.time = data.accelTime + data.cruiseTime + data.decelTime
+	data.slices = data.time / (TimeSlice_US / 1000000)
+}
+
 // Calculate current position at the given time
 func (data *TrapezoidInterpolater) Position(slice float64) Coordinate {
 
@@ -211,9 +298,9 @@ func (data *TrapezoidInterpolater) Position(slice float64) Coordinate {
 
 	if time < data.accelTime { // in acceleration
 
-		distanceAlongMovement = 0.5*Settings.Acceleration_MM_S2*time*time + data.entrySpeed*time
+		distanceAlongMovement = 0.5*data.acceleration*time*time + data.entrySpeed*time
 
-		//fmt.Println("Accel", time, distanceAlongMovement, "speed is", Settings.Acceleration_MM_S2*time+data.entrySpeed)
+		//fmt.Println("Accel", time, distanceAlongMovement, "speed is", data.acceleration*time+data.entrySpeed)
 	} else if time < data.accelTime+data.cruiseTime { // in cruise
 
 		time = time - data.accelTime
@@ -228,6 +315,9 @@ func (data *TrapezoidInterpolater) Position(slice float64) Coordinate {
 		//fmt.Println("Decel", time, distanceAlongMovement, "speed is", -data.acceleration*time+data.cruiseSpeed)
 	}
 
+	if data.path != nil {
+		return data.path.At(distanceAlongMovement)
+	}
 	return data.origin.Add(data.direction.Scaled(distanceAlongMovement))
 }
 
@@ -236,72 +326,6 @@ func (data *TrapezoidInterpolater) Slices() float64 {
 	return data.slices
 }
 
-// Test code to use a larger buffer to do the look ahead:
-// package main
-
-// import "fmt"
-
-// func main() {
-// 	firstChannel := make(chan int, 5)
-// 	secondChannel := make(chan int, 5)
-
-// 	//go DoProcessing(firstChannel, secondChannel)
-// 	go DoProcessingBuffer(5, firstChannel, secondChannel)
-
-// 	go func() {
-// 		for data := 0; data < 10; data++ {
-// 			firstChannel <- data
-// 		}
-// 		close(firstChannel)
-// 	}()
-
-// 	// write resulting data to screen
-// 	for resultData := range secondChannel {
-// 		fmt.Println("=", resultData)
-// 	}
-// }
-
-// // Do processing with no buffer, just looks one value ahead
-// func DoProcessing(incomingData <-chan int, outgoingData chan<- int) {
-// 	defer close(outgoingData)
-
-// 	for data := range incomingData{
-// 		outgoingData <- data + 1
-// 	}
-// }
-
-// // Do processing with a buffer of size bufferSize
-// func DoProcessingBuffer(bufferSize int, incomingData <-chan int, outgoingData chan<- int) {
-// 	defer close(outgoingData)
-
-//  // would be more efficient with a circular buffer
-// 	dataBuffer := make([]int, 0, bufferSize)
-// 	chanOpen := true
-// 	var data int
-// 	for fillBuffer := 0; fillBuffer < bufferSize && chanOpen; fillBuffer++ {
-// 		data, chanOpen = <-incomingData
-// 		if chanOpen {
-// 			dataBuffer = append(dataBuffer, data)
-// 		}
-// 	}
-
-// 	for len(dataBuffer) > 0{
-// 		total := 0
-// 		for _, bufferValue := range dataBuffer {
-// 			total += bufferValue;
-// 			fmt.Print(bufferValue, ", ")
-// 		}
-// 		outgoingData <- total
-// 		fmt.Println()
-
-// 		dataBuffer = dataBuffer[1:]
-// 		data, chanOpen = <-incomingData
-// 		if chanOpen {
-// 			dataBuffer = append(dataBuffer, data)
-// 		}
-// 	}
-// }
-
 // A ring buffer used to store coordinates
 type CoordinateRingBuffer struct {
 	data     []Coordinate // data in the buffer
@@ -353,6 +377,19 @@ func (ring *CoordinateRingBuffer) Dequeue() Coordinate {
 	return result
 }
 
+// Coordinate at index from the beginning of the buffer, without removing it
+func (ring *CoordinateRingBuffer) At(index int) Coordinate {
+	if index < 0 || index >= ring.length {
+		panic(fmt.Sprint("Index ", index, " is outside of buffer with length ", ring.length))
+	}
+
+	readIndex := ring.start + index
+	if readIndex >= ring.capacity {
+		readIndex -= ring.capacity
+	}
+	return ring.data[readIndex]
+}
+
 // Amount of data in the buffer
 func (ring *CoordinateRingBuffer) Len() int {
 	return ring.length
@@ -0,0 +1,192 @@
+package polargraph
+
+// Records the progress of a job as it is sent to the stepper driver, so that it can be resumed after a power loss or abort
+
+import (
+	"encoding/xml"
+	"flag"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// name of the file the journal of the current job is written to
+var JournalFile string = "gocupi_journal.xml"
+
+// How often the journal is written to disk while steps are being sent
+const journalWriteInterval = time.Second
+
+// Progress of a job, the exported fields are what is written to disk
+type JobJournal struct {
+	// Command line arguments used to create the job
+	Args []string `xml:"Arg"`
+
+	// String lengths at the job's 0,0 position
+	OriginLeftDist_MM  float64
+	OriginRightDist_MM float64
+
+	// Index of the coordinate that was being moved towards when the journal was written
+	CoordinateIndex int
+
+	// Index of the last pen up coordinate that had been moved towards, resuming restarts the line that begins there
+	ResumeIndex int
+
+	// String lengths that had been sent to the stepper driver when the journal was written
+	LeftDist_MM  float64
+	RightDist_MM float64
+
+	// Pen state that had been sent to the stepper driver when the journal was written
+	PenUp bool
+
+	fileName  string
+	mutex     sync.Mutex
+	marks     []journalMark // coordinates that have been generated but not yet sent
+	lastWrite time.Time
+}
+
+// Slice at which step data for a coordinate begins
+type journalMark struct {
+	coordinateIndex int
+	slice           int64
+	penUp           bool
+}
+
+// Create a journal for a new job
+func NewJobJournal(fileName string, args []string, origin PolarCoordinate) *JobJournal {
+	return &JobJournal{
+		Args:               args,
+		OriginLeftDist_MM:  origin.LeftDist,
+		OriginRightDist_MM: origin.RightDist,
+		LeftDist_MM:        origin.LeftDist,
+		RightDist_MM:       origin.RightDist,
+		PenUp:              true,
+		fileName:           fileName,
+	}
+}
+
+// Read the journal of an interrupted job
+func ReadJobJournal(fileName string) (*JobJournal, error) {
+	fileData, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &JobJournal{fileName: fileName}
+	if err := xml.Unmarshal(fileData, journal); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// flag.Value that adds to a list each time the flag is given
+type listFlag interface {
+	Reset()
+}
+
+// Parse the command line the job was started with into flagSet.
+// Flags that can be repeated are cleared first, so ones already parsed from the resume command line are not given twice
+func (journal *JobJournal) ParseArgs(flagSet *flag.FlagSet) error {
+	flagSet.VisitAll(func(defined *flag.Flag) {
+		if list, ok := defined.Value.(listFlag); ok {
+			list.Reset()
+		}
+	})
+	return flagSet.Parse(journal.Args)
+}
+
+// Position of the job's 0,0
+func (journal *JobJournal) Origin() PolarCoordinate {
+	return PolarCoordinate{LeftDist: journal.OriginLeftDist_MM, RightDist: journal.OriginRightDist_MM}
+}
+
+// Position that had been sent to the stepper driver when the journal was written
+func (journal *JobJournal) Position() PolarCoordinate {
+	return PolarCoordinate{LeftDist: journal.LeftDist_MM, RightDist: journal.RightDist_MM, PenUp: journal.PenUp}
+}
+
+// Called by the step generator before generating the steps moving towards the given coordinate, slice is the number of step pairs generated so far
+func (journal *JobJournal) CoordinateStarted(coordinateIndex int, slice int64, penUp bool) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	journal.marks = append(journal.marks, journalMark{coordinateIndex, slice, penUp})
+}
+
+// Called as step data is sent, slices is the number of step pairs sent so far and position is the resulting string lengths
+func (journal *JobJournal) StepsSent(slices int64, position PolarCoordinate) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	consumed := 0
+	for consumed < len(journal.marks) && journal.marks[consumed].slice <= slices {
+		journal.CoordinateIndex = journal.marks[consumed].coordinateIndex
+		if journal.marks[consumed].penUp {
+			journal.ResumeIndex = journal.CoordinateIndex
+		}
+		consumed++
+	}
+	journal.marks = journal.marks[consumed:]
+
+	journal.LeftDist_MM = position.LeftDist
+	journal.RightDist_MM = position.RightDist
+	journal.PenUp = position.PenUp
+
+	if time.Since(journal.lastWrite) < journalWriteInterval {
+		return nil
+	}
+	return journal.write()
+}
+
+// Write the journal to disk immediately
+func (journal *JobJournal) Write() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	return journal.write()
+}
+
+// Remove the journal once the job has completed, there is nothing left to resume
+func (journal *JobJournal) Complete() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	if err := os.Remove(journal.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Write the journal to disk, caller must hold the mutex
+func (journal *JobJournal) write() error {
+	journal.lastWrite = time.Now()
+
+	fileData, err := xml.MarshalIndent(journal, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(journal.fileName, fileData)
+}
+
+// Write to a temporary file and rename it over fileName, so a power loss never leaves a partially written file
+func writeFileAtomic(fileName string, fileData []byte) error {
+	tempFile := fileName + ".tmp"
+	file, err := os.Create(tempFile)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(fileData); err != nil {
+		file.Close()
+		return err
+	}
+	// make sure the data is on disk before it replaces the previous file
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, fileName)
+}
@@ -0,0 +1,140 @@
+package polargraph
+
+// Tests for journaling job progress and resuming from it
+
+import (
+	"flag"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The journal should advance to the coordinates whose steps have been sent and survive a round trip to disk
+func TestJournalTracksProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gocupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "journal.xml")
+
+	origin := PolarCoordinate{LeftDist: 600, RightDist: 700}
+	journal := NewJobJournal(fileName, []string{"grid", "100", "4"}, origin)
+	journal.CoordinateStarted(0, 0, true)
+	journal.CoordinateStarted(1, 10, false)
+	journal.CoordinateStarted(2, 20, true)
+	journal.CoordinateStarted(3, 30, false)
+
+	position := PolarCoordinate{LeftDist: 610, RightDist: 690}
+	if err := journal.StepsSent(25, position); err != nil {
+		t.Fatal(err)
+	}
+	if journal.CoordinateIndex != 2 || journal.ResumeIndex != 2 {
+		t.Error("Expected coordinate 2 and resume 2 but got", journal.CoordinateIndex, journal.ResumeIndex)
+	}
+
+	journal.StepsSent(35, position)
+	if journal.CoordinateIndex != 3 || journal.ResumeIndex != 2 {
+		t.Error("Expected coordinate 3 and resume 2 but got", journal.CoordinateIndex, journal.ResumeIndex)
+	}
+	if err := journal.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadJobJournal(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Args) != 3 || read.Args[0] != "grid" || read.ResumeIndex != 2 || read.Origin() != origin || read.Position().LeftDist != 610 {
+		t.Error("Expected journal to match after reading it back", read)
+	}
+
+	if err := read.Complete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Error("Expected journal to be removed on completion")
+	}
+}
+
+// Resuming should travel with the pen up to the resume coordinate and finish where the full job would have
+func TestGenerateStepsResumes(t *testing.T) {
+	setupTestSettings()
+
+	coords := []Coordinate{
+		{X: 0, Y: 0},
+		{X: 50, Y: 0},
+		{X: 50, Y: 50, PenUp: true},
+		{X: 100, Y: 50},
+		{X: 100, Y: 100},
+	}
+	plotCoords := make(chan Coordinate, len(coords))
+	for _, coord := range coords {
+		plotCoords <- coord
+	}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Start = PolarCoordinate{LeftDist: 610, RightDist: 620}
+	generator.ResumeFrom = 2
+
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+
+	transport, emulator := StartEmulator(false)
+	conn, err := NewStepperConnection(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewStepWriter(false)
+	writer.Start = generator.Start
+	writer.Write(conn, stepData)
+	conn.Close()
+
+	state := emulator.Wait()
+	final := state.PolarPosition(generator.Start)
+
+	polarSystem := PolarSystemFromSettings()
+	startingLocation := generator.Origin.ToCoord(polarSystem)
+	polarSystem.XOffset = startingLocation.X
+	polarSystem.YOffset = startingLocation.Y
+	expected := coords[len(coords)-1].ToPolar(polarSystem)
+
+	if math.Abs(final.LeftDist-expected.LeftDist) > Settings.StepSize_MM || math.Abs(final.RightDist-expected.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected to end at", expected, "and ended at", final)
+	}
+	if state.PenTransitions != 1 || state.PenUp {
+		t.Error("Expected a single pen down and saw", state.PenTransitions, "transitions, PenUp", state.PenUp)
+	}
+}
+
+// Resuming should parse the job's filters and overrides once, even when they were also on the resume command line
+func TestJournalParseArgs(t *testing.T) {
+	var filters FilterChain
+	var overrides SettingOverrides
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	filters.AddFlags(flagSet)
+	flagSet.Var(&overrides, "set", "")
+
+	args := []string{"-rotate=1.5707963267948966", "-set", "DrawSpeed_MM_S=40", "line", "10"}
+	if err := flagSet.Parse(append(args[:4:4], "resume")); err != nil {
+		t.Fatal(err)
+	}
+	journal := &JobJournal{Args: args}
+	if err := journal.ParseArgs(flagSet); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(filters) != 1 || len(overrides) != 1 {
+		t.Error("Expected one filter and one override, saw", len(filters), "filters and", overrides)
+	}
+	if flagSet.Arg(0) != "line" || flagSet.NArg() != 2 {
+		t.Error("Expected the job's command, saw", flagSet.Args())
+	}
+	result := applyTestChain(filters, Coordinate{X: 1, Y: 0})
+	if len(result) != 1 || math.Abs(result[0].X) > 1e-9 || math.Abs(result[0].Y-1) > 1e-9 {
+		t.Error("Expected the rotation to be applied once, saw", result)
+	}
+}
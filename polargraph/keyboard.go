@@ -0,0 +1,216 @@
+package polargraph
+
+// Jogs the pen with the keyboard of the terminal gocupi runs in, so it can be positioned over ssh without a mouse
+
+import (
+	"fmt"
+	"math"
+)
+
+// A key pressed in jog mode, the character typed or one of the arrow keys
+type JogKey int
+
+// Arrow keys, negative so they can not be mistaken for a typed character
+const (
+	KeyUp JogKey = -(iota + 1)
+	KeyDown
+	KeyRight
+	KeyLeft
+)
+
+// Reads keys from the terminal without waiting for enter or echoing them
+type KeyboardReader interface {
+	Keys() <-chan JogKey
+
+	// Restore the terminal, safe to call more than once
+	Close()
+}
+
+// Converts the bytes typed on a terminal into keys, arrow keys are sent as ESC [ A to D, or ESC O A to D in application mode
+type keyParser struct {
+	escape int // number of bytes of an escape sequence seen so far
+}
+
+// Parse the next byte, ok is false while in the middle of an escape sequence
+func (parser *keyParser) feed(b byte) (key JogKey, ok bool) {
+	switch {
+	case parser.escape == 0 && b == 0x1b:
+		parser.escape = 1
+		return 0, false
+	case parser.escape == 1 && (b == '[' || b == 'O'):
+		parser.escape = 2
+		return 0, false
+	case parser.escape == 2:
+		parser.escape = 0
+		if b >= 'A' && b <= 'D' {
+			return KeyUp - JogKey(b-'A'), true
+		}
+		return 0, false
+	}
+	parser.escape = 0
+	return JogKey(b), true
+}
+
+// Distances in mm selected by the keys 1 to 5
+var JogStepSizes = []float64{0.1, 1, 5, 10, 50}
+
+// Moves the pen a step at a time as keys are pressed
+type KeyboardJog struct {
+	StepSize float64
+
+	polarSystem PolarSystem
+	spool       *SpoolModel
+	goal        PolarCoordinate // string lengths being moved towards
+	straight    bool            // the pen moves to goal in a straight line, otherwise each string changes length evenly
+	maxDistance float64         // furthest the pen or a string moves in one batch
+}
+
+// Create a jog that starts at position in the system
+func NewKeyboardJog(polarSystem PolarSystem, position PolarCoordinate) *KeyboardJog {
+	return &KeyboardJog{
+		StepSize:    JogStepSizes[1],
+		polarSystem: polarSystem,
+		spool:       SpoolModelFromSettings(),
+		goal:        position,
+		straight:    true,
+		maxDistance: 64 * (Settings.MaxSpeed_MM_S * TimeSlice_US / 1000000.0),
+	}
+}
+
+// Change what the pen is moving towards for a key, returns false if the key is not used for jogging
+func (jog *KeyboardJog) Press(key JogKey) bool {
+	var direction Coordinate
+	switch key {
+	case KeyUp:
+		direction.Y = -1
+	case KeyDown:
+		direction.Y = 1
+	case KeyLeft:
+		direction.X = -1
+	case KeyRight:
+		direction.X = 1
+
+	case 'a', 'z', 'k', 'm':
+		change := jog.StepSize
+		if key == 'a' || key == 'k' {
+			change = -change
+		}
+		if key == 'a' || key == 'z' {
+			jog.goal.LeftDist += change
+		} else {
+			jog.goal.RightDist += change
+		}
+		jog.straight = false
+		return true
+
+	case ' ':
+		jog.goal.PenUp = !jog.goal.PenUp
+		if jog.goal.PenUp {
+			fmt.Println("Pen up")
+		} else {
+			fmt.Println("Pen down")
+		}
+		return true
+
+	case '1', '2', '3', '4', '5':
+		jog.StepSize = JogStepSizes[key-'1']
+		fmt.Println("Step size", jog.StepSize, "mm")
+		return true
+
+	default:
+		return false
+	}
+
+	// move the goal within the drawing surface, so the pen is not clipped
+	goalPos := jog.goal.ToCoord(jog.polarSystem).Add(direction.Scaled(jog.StepSize))
+	goalPos.X = math.Min(jog.polarSystem.XMax, math.Max(goalPos.X+jog.polarSystem.XOffset, jog.polarSystem.XMin)) - jog.polarSystem.XOffset
+	goalPos.Y = math.Min(jog.polarSystem.YMax, math.Max(goalPos.Y+jog.polarSystem.YOffset, jog.polarSystem.YMin)) - jog.polarSystem.YOffset
+	goalPos.PenUp = jog.goal.PenUp
+	jog.goal = goalPos.ToPolar(jog.polarSystem)
+	jog.straight = true
+	return true
+}
+
+// String lengths to move to in each slice of the next batch starting from current, none once both strings are within a fixed point step of the goal
+func (jog *KeyboardJog) Targets(current PolarCoordinate, slices int) []PolarCoordinate {
+	remaining := jog.spool.StepsBetween(current, jog.goal)
+	if math.Abs(remaining.LeftDist) < 1 && math.Abs(remaining.RightDist) < 1 && jog.goal.PenUp == current.PenUp {
+		return nil
+	}
+	targets := make([]PolarCoordinate, slices)
+
+	if jog.straight {
+		currentPos := current.ToCoord(jog.polarSystem)
+		goalPos := jog.goal.ToCoord(jog.polarSystem)
+		move := goalPos.Minus(currentPos)
+		if distance := move.Len(); distance > jog.maxDistance {
+			move = move.Scaled(jog.maxDistance / distance)
+		}
+		for slice := range targets {
+			sliceTarget := currentPos.Add(move.Scaled(float64(slice+1) / float64(slices)))
+			sliceTarget.PenUp = jog.goal.PenUp
+			targets[slice] = sliceTarget.ToPolar(jog.polarSystem)
+		}
+		return targets
+	}
+
+	move := jog.goal.Minus(current)
+	if distance := math.Max(math.Abs(move.LeftDist), math.Abs(move.RightDist)); distance > jog.maxDistance {
+		move = move.Scaled(jog.maxDistance / distance)
+	}
+	for slice := range targets {
+		targets[slice] = current.Add(move.Scaled(float64(slice+1) / float64(slices)))
+		targets[slice].PenUp = jog.goal.PenUp
+	}
+	return targets
+}
+
+// Jog the pen with the keyboard, must open up serial port directly in order to send steps in realtime as requested
+func PerformKeyboardJog() {
+
+	keyboard, err := OpenKeyboard()
+	if err != nil {
+		panic(err)
+	}
+	defer keyboard.Close()
+
+	fmt.Println("Opening", Settings.Transport, "connection to", Settings.TransportAddress)
+	conn, err := OpenStepperConnection()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	fmt.Println("Arrow keys move the pen, 1 to 5 select a step size of", JogStepSizes, "mm, space raises or lowers the pen")
+	fmt.Println("a/z shorten/lengthen the left string, k/m shorten/lengthen the right string")
+	fmt.Println("Enter or ctrl-c to exit, e to exit and enter X Y location of pen")
+
+	realtime := NewRealtimeMover()
+	jog := NewKeyboardJog(realtime.PolarSystem, realtime.Position())
+
+	realtime.Run(conn, func(current PolarCoordinate, slices int) (targets []PolarCoordinate, done bool) {
+		for pressed := true; pressed; {
+			select {
+			case key, open := <-keyboard.Keys():
+				// the strings may have moved before the keyboard failed, so its position is saved the same as on enter
+				if !open {
+					key = '\r'
+				}
+				switch key {
+				case '\r', '\n', 0x03:
+					keyboard.Close()
+					updateSettingsPosition(current.ToCoord(realtime.PolarSystem), realtime.PolarSystem)
+					return nil, true
+				case 'e':
+					keyboard.Close()
+					promptForSettingsPosition(realtime.PolarSystem)
+					return nil, true
+				}
+				jog.Press(key)
+			default:
+				pressed = false
+			}
+		}
+		return jog.Targets(current, slices), false
+	})
+}
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package polargraph
+
+// Reads keys from the terminal on stdin in raw mode
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Terminal on stdin switched to raw mode
+type terminalKeyboard struct {
+	file     *os.File
+	original syscall.Termios
+	keys     chan JogKey
+	stop     chan struct{}
+	stopped  chan struct{}
+	close    sync.Once
+}
+
+// Switch the terminal on stdin to raw mode and start reading keys from it
+func OpenKeyboard() (KeyboardReader, error) {
+	keyboard := &terminalKeyboard{
+		file:    os.Stdin,
+		keys:    make(chan JogKey, 16),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := ioctl(keyboard.file, syscall.TCGETS, unsafe.Pointer(&keyboard.original)); err != nil {
+		return nil, err
+	}
+
+	// keys are read as they are typed without echoing them, and ctrl-c is read as a key so the terminal is always restored.
+	// Reads return after a tenth of a second without a key so the reader can notice it has been closed
+	raw := keyboard.original
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := ioctl(keyboard.file, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+
+	go keyboard.read()
+	return keyboard, nil
+}
+
+// Read keys until closed
+func (keyboard *terminalKeyboard) read() {
+	defer close(keyboard.stopped)
+	defer close(keyboard.keys)
+
+	var parser keyParser
+	buffer := make([]byte, 16)
+	for {
+		select {
+		case <-keyboard.stop:
+			return
+		default:
+		}
+
+		count, err := keyboard.file.Read(buffer)
+		// a read that times out without a key is reported as the end of the file
+		if err != nil && err != io.EOF {
+			return
+		}
+		for _, b := range buffer[:count] {
+			if key, ok := parser.feed(b); ok {
+				select {
+				case keyboard.keys <- key:
+				case <-keyboard.stop:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Keys as they are typed
+func (keyboard *terminalKeyboard) Keys() <-chan JogKey {
+	return keyboard.keys
+}
+
+// Stop reading and restore the terminal
+func (keyboard *terminalKeyboard) Close() {
+	keyboard.close.Do(func() {
+		close(keyboard.stop)
+		<-keyboard.stopped
+		ioctl(keyboard.file, syscall.TCSETS, unsafe.Pointer(&keyboard.original))
+	})
+}
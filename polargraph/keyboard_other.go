@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package polargraph
+
+import (
+	"errors"
+)
+
+// Raw terminal input is only supported on linux
+func OpenKeyboard() (KeyboardReader, error) {
+	return nil, errors.New("Keyboard jog mode is only supported on linux")
+}
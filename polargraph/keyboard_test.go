@@ -0,0 +1,72 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Arrow keys arrive as escape sequences, other bytes are keys on their own
+func TestKeyParser(t *testing.T) {
+	var parser keyParser
+	var keys []JogKey
+	for _, b := range []byte("\x1b[A\x1b[D\x1bOBz ") {
+		if key, ok := parser.feed(b); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	expected := []JogKey{KeyUp, KeyLeft, KeyDown, 'z', ' '}
+	if len(keys) != len(expected) {
+		t.Fatal("Expected", expected, "got", keys)
+	}
+	for index := range expected {
+		if keys[index] != expected[index] {
+			t.Error("Expected", expected, "got", keys)
+		}
+	}
+}
+
+// Jogging moves the pen by the selected step size, spool keys change a single string, and space lowers the pen first
+func TestKeyboardJog(t *testing.T) {
+	setupTestSettings()
+	realtime := NewRealtimeMover()
+	jog := NewKeyboardJog(realtime.PolarSystem, realtime.Position())
+
+	// run batches of 64 slices until the jog has nothing left to send
+	settle := func() {
+		for batch := 0; batch < 100; batch++ {
+			targets := jog.Targets(realtime.position, 64)
+			if targets == nil {
+				return
+			}
+			for _, target := range targets {
+				realtime.sliceData(target)
+			}
+		}
+		t.Fatal("Expected the jog to reach its goal")
+	}
+
+	jog.Press('4')
+	jog.Press(KeyRight)
+	jog.Press(KeyRight)
+	jog.Press(KeyDown)
+	settle()
+	if pos := realtime.position.ToCoord(realtime.PolarSystem); pos.Minus(Coordinate{X: 20, Y: 10}).Len() > Settings.StepSize_MM {
+		t.Error("Expected the pen at 20,10, got", pos)
+	}
+	if !realtime.position.PenUp {
+		t.Error("Expected the pen to stay up")
+	}
+
+	before := realtime.position
+	jog.Press('2')
+	jog.Press('z')
+	jog.Press(' ')
+	settle()
+	if math.Abs(realtime.position.LeftDist-before.LeftDist-1) > Settings.StepSize_MM || math.Abs(realtime.position.RightDist-before.RightDist) > Settings.StepSize_MM {
+		t.Error("Expected only the left string to lengthen by 1, went from", before, "to", realtime.position)
+	}
+	if realtime.position.PenUp {
+		t.Error("Expected the pen to be lowered")
+	}
+}
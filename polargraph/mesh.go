@@ -0,0 +1,355 @@
+package polargraph
+
+// Correction of the distortion that is left after calibration, found by drawing a grid of marks and measuring where they landed
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Apply the profile's CorrectionMesh, turned off for a job with -mesh=false
+var CorrectionMeshEnabled = true
+
+// Offsets at the points of a grid on the drawing surface, interpolated between the points. Adding the offset to a position
+// gives where the pen has to be sent for it to land at the position
+type CorrectionMesh struct {
+	// Absolute position of the top left point of the grid, measured from the left motor like the drawing surface settings
+	Origin Coordinate
+
+	// Distance between the columns and the rows of points
+	Spacing Coordinate
+
+	Columns, Rows int
+
+	// Offset at each point, row by row from the top left
+	Offsets []Coordinate
+}
+
+// Return the mesh in settings, nil if there is none or it has been turned off
+func CorrectionMeshFromSettings() *CorrectionMesh {
+	if !CorrectionMeshEnabled || strings.TrimSpace(Settings.CorrectionMesh) == "" {
+		return nil
+	}
+	mesh, err := ParseCorrectionMesh(Settings.CorrectionMesh)
+	if err != nil {
+		panic(err)
+	}
+	return mesh
+}
+
+// Parse a mesh as written by String, the origin, spacing, columns and rows followed by the X and Y offset of each point
+func ParseCorrectionMesh(text string) (*CorrectionMesh, error) {
+	numbers, err := parseNumbers(strings.Fields(strings.Replace(text, ",", " ", -1)))
+	if err != nil {
+		return nil, err
+	}
+	if len(numbers) < 6 {
+		return nil, errors.New("Expected the origin X Y, spacing X Y, columns and rows of the mesh")
+	}
+	mesh, err := newCorrectionMesh(numbers[:6])
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := numbers[6:]
+	if len(offsets) != 2*len(mesh.Offsets) {
+		return nil, fmt.Errorf("Expected %v offsets for %v x %v points but saw %v numbers", len(mesh.Offsets), mesh.Columns, mesh.Rows, len(offsets))
+	}
+	for index := range mesh.Offsets {
+		mesh.Offsets[index] = Coordinate{X: offsets[2*index], Y: offsets[2*index+1]}
+	}
+	return mesh, nil
+}
+
+// Create a mesh with no offsets from its origin X Y, spacing X Y, columns and rows
+func newCorrectionMesh(grid []float64) (*CorrectionMesh, error) {
+	mesh := &CorrectionMesh{
+		Origin:  Coordinate{X: grid[0], Y: grid[1]},
+		Spacing: Coordinate{X: grid[2], Y: grid[3]},
+		Columns: int(grid[4]),
+		Rows:    int(grid[5]),
+	}
+	if float64(mesh.Columns) != grid[4] || float64(mesh.Rows) != grid[5] || mesh.Columns < 2 || mesh.Rows < 2 {
+		return nil, fmt.Errorf("Expected at least 2 columns and rows but saw %v x %v", grid[4], grid[5])
+	}
+	if !(mesh.Spacing.X > 0 && mesh.Spacing.Y > 0) {
+		return nil, fmt.Errorf("Expected a spacing greater than 0 but saw %v x %v", mesh.Spacing.X, mesh.Spacing.Y)
+	}
+	mesh.Offsets = make([]Coordinate, mesh.Columns*mesh.Rows)
+	return mesh, nil
+}
+
+// Parse each field as a number
+func parseNumbers(fields []string) ([]float64, error) {
+	numbers := make([]float64, len(fields))
+	for index, field := range fields {
+		var err error
+		if numbers[index], err = strconv.ParseFloat(field, 64); err != nil {
+			return nil, fmt.Errorf("Expected a number but saw %q", field)
+		}
+	}
+	return numbers, nil
+}
+
+// The mesh as stored in settings, the origin, spacing, columns and rows then the offset of each point, separated by commas
+func (mesh *CorrectionMesh) String() string {
+	parts := []string{fmt.Sprintf("%g %g %g %g %d %d", mesh.Origin.X, mesh.Origin.Y, mesh.Spacing.X, mesh.Spacing.Y, mesh.Columns, mesh.Rows)}
+	for _, offset := range mesh.Offsets {
+		parts = append(parts, fmt.Sprintf("%.3f %.3f", offset.X, offset.Y))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Offset at an absolute position, interpolated between the four surrounding points. Outside the grid the offsets at its edge are used
+func (mesh *CorrectionMesh) Offset(pos Coordinate) Coordinate {
+	column, columnFraction := meshCell((pos.X-mesh.Origin.X)/mesh.Spacing.X, mesh.Columns)
+	row, rowFraction := meshCell((pos.Y-mesh.Origin.Y)/mesh.Spacing.Y, mesh.Rows)
+
+	topLeft := mesh.Offsets[row*mesh.Columns+column]
+	topRight := mesh.Offsets[row*mesh.Columns+column+1]
+	bottomLeft := mesh.Offsets[(row+1)*mesh.Columns+column]
+	bottomRight := mesh.Offsets[(row+1)*mesh.Columns+column+1]
+
+	top := topLeft.Scaled(1 - columnFraction).Add(topRight.Scaled(columnFraction))
+	bottom := bottomLeft.Scaled(1 - columnFraction).Add(bottomRight.Scaled(columnFraction))
+	offset := top.Scaled(1 - rowFraction).Add(bottom.Scaled(rowFraction))
+	return Coordinate{X: offset.X, Y: offset.Y}
+}
+
+// Index of the cell a position measured in grid spacings falls in, and how far across the cell it is, clamped to the grid
+func meshCell(position float64, points int) (cell int, fraction float64) {
+	cell = int(math.Min(math.Max(math.Floor(position), 0), float64(points-2)))
+	fraction = math.Min(math.Max(position-float64(cell), 0), 1)
+	return
+}
+
+// Where the pen has to be sent to land at an absolute position
+func (mesh *CorrectionMesh) Correct(pos Coordinate) Coordinate {
+	offset := mesh.Offset(pos)
+	return Coordinate{X: pos.X + offset.X, Y: pos.Y + offset.Y, PenUp: pos.PenUp}
+}
+
+// Where the pen lands when it is sent to an absolute position, the inverse of Correct.
+// The offsets are small and change slowly, so repeatedly removing the offset at the current guess converges
+func (mesh *CorrectionMesh) Uncorrect(sent Coordinate) Coordinate {
+	pos := sent
+	for iteration := 0; iteration < 50; iteration++ {
+		offset := mesh.Offset(pos)
+		next := Coordinate{X: sent.X - offset.X, Y: sent.Y - offset.Y, PenUp: sent.PenUp}
+		if next.Minus(pos).Len() < 1e-9 {
+			return next
+		}
+		pos = next
+	}
+	return pos
+}
+
+// Parameters for the grid of marks drawn to build a mesh, columns spread evenly across Width centered on the pen
+// and rows spread evenly down Height starting at the pen
+type MeshGrid struct {
+	Width, Height float64
+	Columns, Rows int
+}
+
+// Position of each mark relative to the pen's starting position, row by row from the top left
+func (grid MeshGrid) Marks() []Coordinate {
+	var marks []Coordinate
+	spacing := grid.Spacing()
+	for row := 0; row < grid.Rows; row++ {
+		for column := 0; column < grid.Columns; column++ {
+			marks = append(marks, Coordinate{X: float64(column)*spacing.X - grid.Width/2, Y: float64(row) * spacing.Y})
+		}
+	}
+	return marks
+}
+
+// Distance between the columns and the rows of marks
+func (grid MeshGrid) Spacing() Coordinate {
+	return Coordinate{X: grid.Width / float64(grid.Columns-1), Y: grid.Height / float64(grid.Rows-1)}
+}
+
+// Check the grid has enough marks to interpolate between
+func (grid MeshGrid) Validate() error {
+	if grid.Columns < 2 || grid.Rows < 2 {
+		return errors.New(fmt.Sprint("Need at least 2 columns and 2 rows of marks, have ", grid.Columns, " x ", grid.Rows))
+	}
+	if !(grid.Width > 0 && grid.Height > 0) {
+		return errors.New(fmt.Sprint("Width and height must be greater than 0, have ", grid.Width, " x ", grid.Height))
+	}
+	return nil
+}
+
+// Draw a cross at each mark, returning to the starting position
+func GenerateMeshGrid(grid MeshGrid, plotCoords chan<- Coordinate) {
+	generateCrosses(grid.Marks(), plotCoords)
+}
+
+// Write the file the user fills in with where the marks landed. start is the absolute position the pen starts at
+func WriteMeshMeasurements(writer io.Writer, grid MeshGrid, start Coordinate) error {
+	spacing := grid.Spacing()
+	origin := start.Add(Coordinate{X: -grid.Width / 2})
+	lines := []string{
+		"# Replace the X and Y of each mark with where it really is, measured in mm from the center of the top left mark,",
+		"# +x to the right along the top row and +y down. Then run meshfit with this file",
+		fmt.Sprintf("grid %g %g %g %g %d %d", origin.X, origin.Y, spacing.X, spacing.Y, grid.Columns, grid.Rows),
+		"# column row X Y",
+	}
+	for row := 0; row < grid.Rows; row++ {
+		for column := 0; column < grid.Columns; column++ {
+			lines = append(lines, fmt.Sprintf("%d %d %g %g", column, row, float64(column)*spacing.X, float64(row)*spacing.Y))
+		}
+	}
+	_, err := io.WriteString(writer, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Read a measurements file written by WriteMeshMeasurements, and build the mesh that moves each mark to where it should have been.
+// The top left mark is where the measurements are taken from, so positions are corrected relative to it
+func ReadMeshMeasurements(reader io.Reader) (*CorrectionMesh, error) {
+	var mesh *CorrectionMesh
+	var measured []bool
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		location := fmt.Sprint("line ", lineNumber)
+
+		if fields[0] == "grid" {
+			if mesh != nil {
+				return nil, parseErrorf(location, "Expected a single grid line")
+			}
+			grid, err := parseNumbers(fields[1:])
+			if err == nil && len(grid) != 6 {
+				err = errors.New("Expected grid followed by the origin X Y, spacing X Y, columns and rows")
+			}
+			// the offsets are filled in as the marks are read
+			if err == nil {
+				mesh, err = newCorrectionMesh(grid)
+			}
+			if err != nil {
+				return nil, wrapParseError(err, "", location)
+			}
+			measured = make([]bool, len(mesh.Offsets))
+			continue
+		}
+		if mesh == nil {
+			return nil, parseErrorf(location, "Expected the grid line before the marks")
+		}
+
+		if len(fields) != 4 {
+			return nil, parseErrorf(location, "Expected column row X Y")
+		}
+		column, columnErr := strconv.Atoi(fields[0])
+		row, rowErr := strconv.Atoi(fields[1])
+		if columnErr != nil || rowErr != nil || column < 0 || column >= mesh.Columns || row < 0 || row >= mesh.Rows {
+			return nil, parseErrorf(location, "Unknown mark %v %v, expected a column from 0 to %v and a row from 0 to %v", fields[0], fields[1], mesh.Columns-1, mesh.Rows-1)
+		}
+		var pos Coordinate
+		var err error
+		if pos.X, err = strconv.ParseFloat(fields[2], 64); err == nil {
+			pos.Y, err = strconv.ParseFloat(fields[3], 64)
+		}
+		if err != nil {
+			return nil, parseErrorf(location, "Unable to parse %v %v as a position", fields[2], fields[3])
+		}
+		index := row*mesh.Columns + column
+		if measured[index] {
+			return nil, parseErrorf(location, "Mark %v %v was already measured", column, row)
+		}
+		measured[index] = true
+
+		expected := Coordinate{X: float64(column) * mesh.Spacing.X, Y: float64(row) * mesh.Spacing.Y}
+		mesh.Offsets[index] = expected.Minus(pos)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if mesh == nil {
+		return nil, parseErrorf("", "Expected a grid line")
+	}
+	for index, done := range measured {
+		if !done {
+			return nil, parseErrorf("", "Mark %v %v was not measured", index%mesh.Columns, index/mesh.Columns)
+		}
+	}
+	return mesh, nil
+}
+
+// Draw the grid of marks without any existing mesh, and write the file for the user to fill in with where they landed
+func PerformMeshGrid(grid MeshGrid, fileName string) {
+	if err := grid.Validate(); err != nil {
+		panic(err)
+	}
+
+	// measure the distortion without the previous correction
+	CorrectionMeshEnabled = false
+
+	file, err := os.Create(fileName)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}.ToCoord(PolarSystemFromSettings())
+	if err := WriteMeshMeasurements(file, grid, start); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Opening", Settings.Transport, "connection to", Settings.TransportAddress)
+	conn, err := OpenStepperConnection()
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	plotCoords := make(chan Coordinate, 1024)
+	go GenerateMeshGrid(grid, plotCoords)
+	stepData := make(chan int8, 1024)
+	go GenerateSteps(plotCoords, stepData)
+	NewStepWriter(false).Write(conn, stepData)
+
+	fmt.Println("Measure where each mark landed from the top left mark and fill them in to", fileName+", then run meshfit", fileName)
+}
+
+// Build the mesh from a file of measurements, and save it to the profile if the user agrees
+func PerformMeshFit(fileName string) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		panic(err)
+	}
+	mesh, err := ReadMeshMeasurements(file)
+	file.Close()
+	if err != nil {
+		fmt.Println("ERROR: ", wrapParseError(err, fileName, ""))
+		return
+	}
+
+	largest := 0.0
+	for _, offset := range mesh.Offsets {
+		largest = math.Max(largest, offset.Len())
+	}
+	fmt.Println(mesh)
+	fmt.Printf("Largest correction %.3f mm", largest)
+	fmt.Println()
+
+	fmt.Print("Save this mesh? (y/n):")
+	var answer string
+	fmt.Scanln(&answer)
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		return
+	}
+
+	Settings.CorrectionMesh = mesh.String()
+	if err := saveElements(settingsElement{XMLName: xml.Name{Local: "CorrectionMesh"}, Value: Settings.CorrectionMesh}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,110 @@
+package polargraph
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Offsets are exact at the points, interpolated between them, and held at the edge values outside the grid
+func TestCorrectionMeshOffset(t *testing.T) {
+	mesh := &CorrectionMesh{
+		Origin:  Coordinate{X: 100, Y: 200},
+		Spacing: Coordinate{X: 50, Y: 100},
+		Columns: 2,
+		Rows:    2,
+		Offsets: []Coordinate{{X: 1, Y: 0}, {X: 3, Y: 0}, {X: 1, Y: 2}, {X: 3, Y: -2}},
+	}
+
+	for pos, expected := range map[Coordinate]Coordinate{
+		{X: 100, Y: 200}: {X: 1, Y: 0},
+		{X: 150, Y: 300}: {X: 3, Y: -2},
+		{X: 125, Y: 250}: {X: 2, Y: 0},
+		{X: 125, Y: 300}: {X: 2, Y: 0},
+		{X: 0, Y: 250}:   {X: 1, Y: 1},
+		{X: 500, Y: 900}: {X: 3, Y: -2},
+	} {
+		if offset := mesh.Offset(pos); offset.Minus(expected).Len() > 1e-9 {
+			t.Error("Expected offset", expected, "at", pos, "got", offset)
+		}
+	}
+
+	pos := Coordinate{X: 137, Y: 221}
+	if back := mesh.Uncorrect(mesh.Correct(pos)); back.Minus(pos).Len() > 1e-6 {
+		t.Error("Expected to get back", pos, "got", back)
+	}
+}
+
+// Measured marks become offsets that move each mark back to where it was meant to be, and the mesh survives being stored as text
+func TestReadMeshMeasurements(t *testing.T) {
+	grid := MeshGrid{Width: 200, Height: 100, Columns: 3, Rows: 2}
+	var file bytes.Buffer
+	if err := WriteMeshMeasurements(&file, grid, Coordinate{X: 500, Y: 300}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the middle mark of the bottom row landed 2 mm right and 1 mm up
+	measured := strings.Replace(file.String(), "1 1 100 100", "1 1 102 99", 1)
+	mesh, err := ReadMeshMeasurements(strings.NewReader(measured))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.Origin != (Coordinate{X: 400, Y: 300}) || mesh.Spacing != (Coordinate{X: 100, Y: 100}) || mesh.Columns != 3 || mesh.Rows != 2 {
+		t.Error("Expected a 3 x 2 grid from 400,300 spaced 100, got", mesh.Origin, mesh.Spacing, mesh.Columns, mesh.Rows)
+	}
+	for index, offset := range mesh.Offsets {
+		expected := Coordinate{}
+		if index == 4 {
+			expected = Coordinate{X: -2, Y: 1}
+		}
+		if offset != expected {
+			t.Error("Expected offset", expected, "for mark", index, "got", offset)
+		}
+	}
+
+	parsed, err := ParseCorrectionMesh(mesh.String())
+	if err != nil || fmt.Sprint(parsed) != fmt.Sprint(mesh) {
+		t.Error("Expected the mesh to parse back to", mesh, "got", parsed, err)
+	}
+}
+
+// Mistakes in the measurements file say which line they are on
+func TestReadMeshMeasurementsErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"0 0 1 1":                                      "line 1: Expected the grid line before the marks",
+		"grid 0 0 10 10 1 2":                           "line 1: Expected at least 2 columns and rows but saw 1 x 2",
+		"grid 0 0 10 10 2 2\n0 0 0 0\n0 0 0 0":         "line 3: Mark 0 0 was already measured",
+		"grid 0 0 10 10 2 2\n# comment\n2 0 0 0":       "line 3: Unknown mark 2 0, expected a column from 0 to 1 and a row from 0 to 1",
+		"grid 0 0 10 10 2 2\n0 0 0 0\n1 0 10 0\n0 1 0": "line 4: Expected column row X Y",
+		"grid 0 0 10 10 2 2\n0 0 0 0\n1 0 10 0":        "Mark 0 1 was not measured",
+	} {
+		if _, err := ReadMeshMeasurements(strings.NewReader(input)); err == nil || err.Error() != expected {
+			t.Error("Expected", expected, "got", err)
+		}
+	}
+}
+
+// A system with a mesh sends the pen to the corrected position, and converting back gives the position asked for
+func TestMeshToPolar(t *testing.T) {
+	setupTestSettings()
+	plain := PolarSystemFromSettings()
+	corrected := plain
+	corrected.Mesh = &CorrectionMesh{
+		Origin:  Coordinate{X: 300, Y: 400},
+		Spacing: Coordinate{X: 400, Y: 400},
+		Columns: 2,
+		Rows:    2,
+		Offsets: []Coordinate{{X: 2, Y: 1}, {X: -1, Y: 0}, {X: 0, Y: 3}, {X: 1, Y: -2}},
+	}
+
+	pos := Coordinate{X: 450, Y: 650}
+	polar := pos.ToPolar(corrected)
+	if expected := corrected.Mesh.Correct(pos).ToPolar(plain); math.Abs(polar.LeftDist-expected.LeftDist) > 1e-9 || math.Abs(polar.RightDist-expected.RightDist) > 1e-9 {
+		t.Error("Expected", expected, "got", polar)
+	}
+	if back := polar.ToCoord(corrected); back.Minus(pos).Len() > 1e-6 {
+		t.Error("Expected to convert back to", pos, "got", back)
+	}
+}
@@ -1,52 +1,141 @@
 package polargraph
 
-// Allows current mouse position to be read
+// Allows current mouse position to be read, from a linux input device or a recorded file
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 // Interface needed
 type MouseReader interface {
-	Start(string)
+	// Position of the pointer in mm, relative to where it started unless Absolute. ok is false until the position is known
+	GetPos() (pos Coordinate, ok bool)
+
+	// True if GetPos is a position on the drawing surface, measured from the left motor like the drawing surface settings
+	Absolute() bool
+
+	// Presses and releases of the buttons, in the order they happened
+	ButtonEvents() <-chan MouseButtonEvent
+
 	Close()
-	GetPos() (int32, int32)
-	GetLeftButton() bool
-	GetRightButton() bool
 }
 
-// Data needed by MouseReader
-type linuxMouseReader struct {
-	eventFile *os.File
+// A mouse button, or the button of a tablet stylus that does the same thing
+type MouseButton int
 
-	currentXPos        int32
-	currentYPos        int32
-	leftButtonPressed  bool
-	rightButtonPressed bool
+const (
+	MouseLeft MouseButton = iota
+	MouseRight
+	MouseMiddle
+)
+
+// Name of the button as used in replay files
+func (button MouseButton) String() string {
+	switch button {
+	case MouseLeft:
+		return "left"
+	case MouseRight:
+		return "right"
+	case MouseMiddle:
+		return "middle"
+	}
+	return fmt.Sprint("button ", int(button))
 }
 
-// Return an implementation of MouseReader specific to the current platform
-func CreateAndStartMouseReader() MouseReader {
-	// todo: implement windows supports?
-	var mouse = &linuxMouseReader{}
-	mouse.Start(Settings.MousePath)
-	return mouse
+// A button being pressed or released
+type MouseButtonEvent struct {
+	Button  MouseButton
+	Pressed bool
 }
 
-// Launches a gorountine which updates
-func (mouse *linuxMouseReader) Start(eventPath string) {
+// Number of relative mouse counts in a mm
+const mouseCountsPerMM = 20.0
+
+// Return the MouseReader selected by Settings.MouseType, reading from Settings.MousePath
+func CreateAndStartMouseReader() MouseReader {
+	var mouse MouseReader
 	var err error
-	mouse.eventFile, err = os.Open(eventPath)
+	switch strings.ToLower(Settings.MouseType) {
+	case "", "relative":
+		mouse, err = NewEventMouseReader(Settings.MousePath, false)
+	case "absolute":
+		mouse, err = NewEventMouseReader(Settings.MousePath, true)
+	case "replay":
+		var file *os.File
+		if file, err = os.Open(Settings.MousePath); err == nil {
+			defer file.Close()
+			mouse, err = NewReplayMouseReader(file)
+			err = wrapParseError(err, Settings.MousePath, "")
+		}
+	default:
+		err = fmt.Errorf("Unknown MouseType %v, expected relative, absolute or replay", Settings.MouseType)
+	}
 	if err != nil {
 		panic(err)
 	}
+	return mouse
+}
+
+// Range of values reported for an absolute axis
+type absRange struct {
+	Min, Max int32
+}
+
+// Reads a linux input device such as a mouse, or with absolute a graphics tablet or touchscreen whose area is mapped onto the drawing surface
+type eventMouseReader struct {
+	eventFile *os.File
+	absolute  bool
+
+	xRange, yRange absRange
+	hasX, hasY     int32 // set once an absolute axis has been reported
+
+	currentXPos int32
+	currentYPos int32
+	events      chan MouseButtonEvent
+
+	// buttons whose press was dropped because events was full, so their release is dropped too
+	droppedPress [MouseMiddle + 1]bool
+}
+
+// Open an input device and launch a goroutine which reads it, absolute devices must report the range of their axes
+func NewEventMouseReader(eventPath string, absolute bool) (MouseReader, error) {
+	eventFile, err := os.Open(eventPath)
+	if err != nil {
+		return nil, err
+	}
+	mouse := &eventMouseReader{
+		eventFile: eventFile,
+		absolute:  absolute,
+		events:    make(chan MouseButtonEvent, 64),
+	}
+	if absolute {
+		if mouse.xRange, err = readAbsRange(eventFile, absX); err == nil {
+			mouse.yRange, err = readAbsRange(eventFile, absY)
+		}
+		if err == nil && (mouse.xRange.Max <= mouse.xRange.Min || mouse.yRange.Max <= mouse.yRange.Min) {
+			err = fmt.Errorf("%v does not report the range of its X and Y axes", eventPath)
+		}
+		if err != nil {
+			eventFile.Close()
+			return nil, err
+		}
+	}
 
 	go mouse.readDriver()
+	return mouse, nil
 }
 
 // Event data returned from event file
@@ -57,62 +146,266 @@ type inputEvent struct {
 	Value int32           // event value related to the event type
 }
 
+// Absolute axis codes
+const (
+	absX = 0
+	absY = 1
+)
+
 // Runs an infinite loop reading from the event file
-func (mouse *linuxMouseReader) readDriver() {
+func (mouse *eventMouseReader) readDriver() {
+	defer close(mouse.events)
+
 	event := inputEvent{}
 	buffer := make([]byte, int(unsafe.Sizeof(event)))
 
 	for {
-		_, err := mouse.eventFile.Read(buffer)
+		_, err := io.ReadFull(mouse.eventFile, buffer)
 		if err != nil {
 			return
 		}
 
 		b := bytes.NewBuffer(buffer)
 		binary.Read(b, binary.LittleEndian, &event)
+		mouse.handleEvent(event)
+	}
+}
 
-		switch event.Type {
-		case 1: // EV_KEY button press
-
-			switch event.Code {
-			case 272: // BTN_LEFT left click
-				mouse.leftButtonPressed = true
+// Update the position or send a button event
+func (mouse *eventMouseReader) handleEvent(event inputEvent) {
+	switch event.Type {
+	case 1: // EV_KEY button press, value is 1 when pressed, 0 when released and 2 when repeated
+		var button MouseButton
+		switch event.Code {
+		case 272, 331: // BTN_LEFT left click, BTN_STYLUS lower stylus button
+			button = MouseLeft
+		case 273, 332: // BTN_RIGHT right click, BTN_STYLUS2 upper stylus button
+			button = MouseRight
+		case 274: // BTN_MIDDLE middle click
+			button = MouseMiddle
+		default:
+			return
+		}
+		if event.Value > 1 {
+			return
+		}
 
-			case 273: // BTN_RIGHT right click
-				mouse.rightButtonPressed = true
+		// presses are dropped when events is full, but a release of a press that was sent waits for room so the button is never left down
+		buttonEvent := MouseButtonEvent{Button: button, Pressed: event.Value == 1}
+		switch {
+		case buttonEvent.Pressed:
+			select {
+			case mouse.events <- buttonEvent:
+				mouse.droppedPress[button] = false
+			default:
+				mouse.droppedPress[button] = true
 			}
+		case mouse.droppedPress[button]:
+			mouse.droppedPress[button] = false
+		default:
+			mouse.events <- buttonEvent
+		}
 
-		case 2: // EV_REL movement event
-			switch event.Code {
-			case 0: // REL_X
-				x := atomic.LoadInt32(&mouse.currentXPos)
-				x += event.Value
-				atomic.StoreInt32(&mouse.currentXPos, x)
-			case 1: // REL_Y
-				y := atomic.LoadInt32(&mouse.currentYPos)
-				y += event.Value
-				atomic.StoreInt32(&mouse.currentYPos, y)
-			}
+	case 2: // EV_REL movement event
+		switch event.Code {
+		case 0: // REL_X
+			atomic.AddInt32(&mouse.currentXPos, event.Value)
+		case 1: // REL_Y
+			atomic.AddInt32(&mouse.currentYPos, event.Value)
+		}
+
+	case 3: // EV_ABS absolute position event
+		switch event.Code {
+		case absX:
+			atomic.StoreInt32(&mouse.currentXPos, event.Value)
+			atomic.StoreInt32(&mouse.hasX, 1)
+		case absY:
+			atomic.StoreInt32(&mouse.currentYPos, event.Value)
+			atomic.StoreInt32(&mouse.hasY, 1)
 		}
 	}
 }
 
 // Stop the mouse
-func (mouse *linuxMouseReader) Close() {
+func (mouse *eventMouseReader) Close() {
 	mouse.eventFile.Close()
 }
 
 // Return the current mouse position
-func (mouse *linuxMouseReader) GetPos() (x, y int32) {
-	return atomic.LoadInt32(&mouse.currentXPos), atomic.LoadInt32(&mouse.currentYPos)
+func (mouse *eventMouseReader) GetPos() (Coordinate, bool) {
+	x, y := atomic.LoadInt32(&mouse.currentXPos), atomic.LoadInt32(&mouse.currentYPos)
+	if !mouse.absolute {
+		return Coordinate{X: float64(x) / mouseCountsPerMM, Y: float64(y) / mouseCountsPerMM}, true
+	}
+	if atomic.LoadInt32(&mouse.hasX) == 0 || atomic.LoadInt32(&mouse.hasY) == 0 {
+		return Coordinate{}, false
+	}
+	return mapToSurface(x, y, mouse.xRange, mouse.yRange), true
+}
+
+// Map a position on an absolute device onto the drawing surface, as large as it fits without changing its aspect ratio and centered
+func mapToSurface(x, y int32, xRange, yRange absRange) Coordinate {
+	width := Settings.DrawingSurfaceMaxX_MM - Settings.DrawingSurfaceMinX_MM
+	height := Settings.DrawingSurfaceMaxY_MM - Settings.DrawingSurfaceMinY_MM
+	deviceWidth := float64(xRange.Max - xRange.Min)
+	deviceHeight := float64(yRange.Max - yRange.Min)
+	scale := math.Min(width/deviceWidth, height/deviceHeight)
+
+	return Coordinate{
+		X: Settings.DrawingSurfaceMinX_MM + (width-deviceWidth*scale)/2 + float64(x-xRange.Min)*scale,
+		Y: Settings.DrawingSurfaceMinY_MM + (height-deviceHeight*scale)/2 + float64(y-yRange.Min)*scale,
+	}
+}
+
+// True for a graphics tablet or touchscreen
+func (mouse *eventMouseReader) Absolute() bool {
+	return mouse.absolute
+}
+
+// Button presses and releases
+func (mouse *eventMouseReader) ButtonEvents() <-chan MouseButtonEvent {
+	return mouse.events
+}
+
+// A line of a replay file
+type replayStep struct {
+	at     time.Duration
+	pos    *Coordinate
+	button *MouseButtonEvent
+}
+
+// Plays back recorded input, so mouse mode can be run without a mouse
+type replayMouseReader struct {
+	steps []replayStep
+
+	lock   sync.Mutex
+	pos    Coordinate
+	hasPos bool
+
+	events chan MouseButtonEvent
+	stop   chan struct{}
+	close  sync.Once
+}
+
+// Read a replay file and start playing it back. Each line is the time in ms since the start followed by
+// move X Y, to move the pointer to X,Y in mm relative to where the pen started, or press or release and a button name
+// of left, right or middle. Blank lines and lines starting with # are skipped
+func NewReplayMouseReader(reader io.Reader) (MouseReader, error) {
+	mouse := &replayMouseReader{
+		events: make(chan MouseButtonEvent, 64),
+		stop:   make(chan struct{}),
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		step, err := parseReplayStep(fields)
+		if err != nil {
+			return nil, wrapParseError(err, "", fmt.Sprint("line ", lineNumber))
+		}
+		mouse.steps = append(mouse.steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	go mouse.play()
+	return mouse, nil
+}
+
+// Parse the fields of a line of a replay file
+func parseReplayStep(fields []string) (step replayStep, err error) {
+	if len(fields) < 2 {
+		return step, parseErrorf("", "Expected a time and move, press or release")
+	}
+	ms, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return step, parseErrorf("", "Unable to parse %v as a time in ms", fields[0])
+	}
+	step.at = time.Duration(ms * float64(time.Millisecond))
+
+	switch fields[1] {
+	case "move":
+		if len(fields) != 4 {
+			return step, parseErrorf("", "Expected move X Y")
+		}
+		var pos Coordinate
+		if pos.X, err = strconv.ParseFloat(fields[2], 64); err == nil {
+			pos.Y, err = strconv.ParseFloat(fields[3], 64)
+		}
+		if err != nil {
+			return step, parseErrorf("", "Unable to parse %v %v as a position", fields[2], fields[3])
+		}
+		step.pos = &pos
+
+	case "press", "release":
+		if len(fields) != 3 {
+			return step, parseErrorf("", "Expected %v and a button", fields[1])
+		}
+		event := MouseButtonEvent{Pressed: fields[1] == "press"}
+		for event.Button = MouseLeft; event.Button.String() != fields[2]; event.Button++ {
+			if event.Button > MouseMiddle {
+				return step, parseErrorf("", "Unknown button %v, expected left, right or middle", fields[2])
+			}
+		}
+		step.button = &event
+
+	default:
+		return step, parseErrorf("", "Unknown input %v, expected move, press or release", fields[1])
+	}
+	return step, nil
+}
+
+// Apply each step when its time comes
+func (mouse *replayMouseReader) play() {
+	defer close(mouse.events)
+
+	start := time.Now()
+	for _, step := range mouse.steps {
+		select {
+		case <-time.After(step.at - time.Since(start)):
+		case <-mouse.stop:
+			return
+		}
+
+		if step.pos != nil {
+			mouse.lock.Lock()
+			mouse.pos, mouse.hasPos = *step.pos, true
+			mouse.lock.Unlock()
+		}
+		if step.button != nil {
+			select {
+			case mouse.events <- *step.button:
+			case <-mouse.stop:
+				return
+			}
+		}
+	}
+}
+
+// Position of the last move played
+func (mouse *replayMouseReader) GetPos() (Coordinate, bool) {
+	mouse.lock.Lock()
+	defer mouse.lock.Unlock()
+	return mouse.pos, mouse.hasPos
+}
+
+// Replayed positions are relative to where the pen started
+func (mouse *replayMouseReader) Absolute() bool {
+	return false
 }
 
-// Return true if left button has ever been pressed
-func (mouse *linuxMouseReader) GetLeftButton() bool {
-	return mouse.leftButtonPressed
+// Button presses and releases played so far
+func (mouse *replayMouseReader) ButtonEvents() <-chan MouseButtonEvent {
+	return mouse.events
 }
 
-// Return true if right button has ever been pressed
-func (mouse *linuxMouseReader) GetRightButton() bool {
-	return mouse.rightButtonPressed
+// Stop playing
+func (mouse *replayMouseReader) Close() {
+	mouse.close.Do(func() {
+		close(mouse.stop)
+	})
 }
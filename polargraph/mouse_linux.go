@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package polargraph
+
+import (
+	"os"
+	"unsafe"
+)
+
+// Ask an input device for the range of one of its absolute axes with EVIOCGABS
+func readAbsRange(eventFile *os.File, axis uintptr) (absRange, error) {
+	var info struct {
+		Value, Minimum, Maximum, Fuzz, Flat, Resolution int32
+	}
+	request := uintptr(2<<30|unsafe.Sizeof(info)<<16|'E'<<8) | (0x40 + axis)
+	if err := ioctl(eventFile, request, unsafe.Pointer(&info)); err != nil {
+		return absRange{}, err
+	}
+	return absRange{Min: info.Minimum, Max: info.Maximum}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package polargraph
+
+import (
+	"errors"
+	"os"
+)
+
+// Absolute input devices are only supported on linux
+func readAbsRange(eventFile *os.File, axis uintptr) (absRange, error) {
+	return absRange{}, errors.New("Absolute input devices are only supported on linux")
+}
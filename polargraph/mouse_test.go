@@ -0,0 +1,126 @@
+package polargraph
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Following a replayed mouse moves the pen to the pointer, a middle click lowers the pen and a left click exits
+func TestMouseTrackingReplay(t *testing.T) {
+	setupTestSettings()
+	mouse, err := NewReplayMouseReader(strings.NewReader(`
+# moves, then lowers the pen and exits
+0 move 10 5
+0 press middle
+0 release middle
+300 press left
+300 release left
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mouse.Close()
+
+	realtime := NewRealtimeMover()
+	tracker := newMouseTracker(mouse, realtime.PolarSystem)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		targets, clicked, exit := tracker.Targets(realtime.position, 64)
+		if exit {
+			if clicked != MouseLeft {
+				t.Error("Expected a left click, got", clicked)
+			}
+			break
+		}
+		for _, target := range targets {
+			realtime.sliceData(target)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if pos := realtime.position.ToCoord(realtime.PolarSystem); pos.Minus(Coordinate{X: 10, Y: 5}).Len() > 0.1 {
+		t.Error("Expected the pen at 10,5, got", pos)
+	}
+	if realtime.position.PenUp {
+		t.Error("Expected the middle click to lower the pen")
+	}
+}
+
+// Replay files report the line of a mistake
+func TestReplayMouseReaderErrors(t *testing.T) {
+	for input, expected := range map[string]string{
+		"0 move 1 2\n10 press thumb": "line 2: Unknown button thumb, expected left, right or middle",
+		"# comment\n\nsoon move 1 2": "line 3: Unable to parse soon as a time in ms",
+		"0 move 1":                   "line 1: Expected move X Y",
+		"0 wiggle":                   "line 1: Unknown input wiggle, expected move, press or release",
+	} {
+		if _, err := NewReplayMouseReader(strings.NewReader(input)); err == nil || err.Error() != expected {
+			t.Error("Expected", expected, "got", err)
+		}
+	}
+}
+
+// Input device events become positions and button presses and releases, stylus buttons act as mouse buttons
+func TestEventMouseReader(t *testing.T) {
+	mouse := &eventMouseReader{events: make(chan MouseButtonEvent, 8)}
+	mouse.handleEvent(inputEvent{Type: 2, Code: 0, Value: 40})
+	mouse.handleEvent(inputEvent{Type: 2, Code: 1, Value: -20})
+	mouse.handleEvent(inputEvent{Type: 1, Code: 331, Value: 1})
+	mouse.handleEvent(inputEvent{Type: 1, Code: 331, Value: 2})
+	mouse.handleEvent(inputEvent{Type: 1, Code: 331, Value: 0})
+
+	if pos, ok := mouse.GetPos(); !ok || pos != (Coordinate{X: 2, Y: -1}) {
+		t.Error("Expected 2,-1 got", pos, ok)
+	}
+	if event := <-mouse.events; event != (MouseButtonEvent{Button: MouseLeft, Pressed: true}) {
+		t.Error("Expected left press, got", event)
+	}
+	if event := <-mouse.events; event != (MouseButtonEvent{Button: MouseLeft, Pressed: false}) {
+		t.Error("Expected left release without the repeat, got", event)
+	}
+}
+
+// An absolute device is mapped onto the drawing surface without stretching it, once both axes are known
+func TestAbsoluteMouseMapping(t *testing.T) {
+	setupTestSettings()
+	mouse := &eventMouseReader{absolute: true, xRange: absRange{Min: 0, Max: 1000}, yRange: absRange{Min: 0, Max: 500}, events: make(chan MouseButtonEvent, 8)}
+
+	mouse.handleEvent(inputEvent{Type: 3, Code: absX, Value: 1000})
+	if _, ok := mouse.GetPos(); ok {
+		t.Error("Expected no position until both axes are reported")
+	}
+	mouse.handleEvent(inputEvent{Type: 3, Code: absY, Value: 250})
+
+	// the 950 wide surface is the narrower fit, 950 x 475 centered in the 1950 high surface
+	expected := Coordinate{X: 975, Y: 50 + (1950-475)/2.0 + 237.5}
+	if pos, ok := mouse.GetPos(); !ok || pos.Minus(expected).Len() > 1e-9 {
+		t.Error("Expected", expected, "got", pos, ok)
+	}
+}
+
+// A full event buffer may drop a press, but never the release of a press that was sent
+func TestEventMouseReaderKeepsReleases(t *testing.T) {
+	mouse := &eventMouseReader{events: make(chan MouseButtonEvent, 1)}
+	mouse.handleEvent(inputEvent{Type: 1, Code: 272, Value: 1})
+	mouse.handleEvent(inputEvent{Type: 1, Code: 273, Value: 1})
+	mouse.handleEvent(inputEvent{Type: 1, Code: 273, Value: 0})
+
+	released := make(chan bool)
+	go func() {
+		mouse.handleEvent(inputEvent{Type: 1, Code: 272, Value: 0})
+		close(released)
+	}()
+
+	expected := []MouseButtonEvent{{Button: MouseLeft, Pressed: true}, {Button: MouseLeft, Pressed: false}}
+	for _, event := range expected {
+		if received := <-mouse.events; received != event {
+			t.Error("Expected", event, "got", received)
+		}
+	}
+	<-released
+	if len(mouse.events) != 0 {
+		t.Error("Expected the dropped right click to not be sent, got", <-mouse.events)
+	}
+}
@@ -0,0 +1,231 @@
+package polargraph
+
+// Places a drawing on the drawing surface, scaling and moving it as requested and refusing drawings that would run off the surface
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rectangle given by its top left and bottom right corners
+type Bounds struct {
+	Min, Max Coordinate
+}
+
+// Width of the rectangle
+func (bounds Bounds) Width() float64 {
+	return bounds.Max.X - bounds.Min.X
+}
+
+// Height of the rectangle
+func (bounds Bounds) Height() float64 {
+	return bounds.Max.Y - bounds.Min.Y
+}
+
+// Bounds ToString
+func (bounds Bounds) String() string {
+	return fmt.Sprintf("%.1f x %.1f mm from %v to %v", bounds.Width(), bounds.Height(), bounds.Min, bounds.Max)
+}
+
+// Smallest rectangle containing every coordinate and curve, including pen up travel
+func DrawingBounds(coords []Coordinate) Bounds {
+	bounds := Bounds{Min: Coordinate{X: math.Inf(1), Y: math.Inf(1)}, Max: Coordinate{X: math.Inf(-1), Y: math.Inf(-1)}}
+	for _, coord := range coords {
+		for _, point := range pathPoints(coord) {
+			bounds.Min.X = math.Min(bounds.Min.X, point.X)
+			bounds.Min.Y = math.Min(bounds.Min.Y, point.Y)
+			bounds.Max.X = math.Max(bounds.Max.X, point.X)
+			bounds.Max.Y = math.Max(bounds.Max.Y, point.Y)
+		}
+	}
+	return bounds
+}
+
+// The drawing surface from settings, relative to a job whose 0,0 is at the given string lengths
+func SurfaceBounds(origin PolarCoordinate) Bounds {
+	start := origin.ToCoord(PolarSystemFromSettings())
+	return Bounds{
+		Min: Coordinate{X: Settings.DrawingSurfaceMinX_MM - start.X, Y: Settings.DrawingSurfaceMinY_MM - start.Y},
+		Max: Coordinate{X: Settings.DrawingSurfaceMaxX_MM - start.X, Y: Settings.DrawingSurfaceMaxY_MM - start.Y},
+	}
+}
+
+// Position of the drawing within the available area for each anchor, as a fraction of the space left over on each axis
+var placementAnchors = map[string]Coordinate{
+	"topleft":     {X: 0, Y: 0},
+	"top":         {X: 0.5, Y: 0},
+	"topright":    {X: 1, Y: 0},
+	"left":        {X: 0, Y: 0.5},
+	"center":      {X: 0.5, Y: 0.5},
+	"right":       {X: 1, Y: 0.5},
+	"bottomleft":  {X: 0, Y: 1},
+	"bottom":      {X: 0.5, Y: 1},
+	"bottomright": {X: 1, Y: 1},
+}
+
+// How a drawing is placed on the drawing surface
+type Placement struct {
+	// Scale the drawing to fill the available area, keeping its aspect ratio
+	Fit bool
+
+	// Where to put the drawing in the available area, one of the placementAnchors, or empty to leave it relative to the pen
+	Anchor string
+
+	// Distance to keep from each edge of the drawing surface: top, right, bottom, left
+	Margins [4]float64
+
+	// Send the drawing even if it runs off the drawing surface, a drawing that is not moved or scaled is streamed instead of buffered
+	Unchecked bool
+}
+
+// Parse margins given as a single value for all sides, top/bottom,left/right, or top,right,bottom,left
+func ParseMargins(value string) (margins [4]float64, err error) {
+	parts := strings.Split(value, ",")
+	numbers := make([]float64, len(parts))
+	for index, part := range parts {
+		if numbers[index], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return margins, errors.New(fmt.Sprint("Unable to parse margin ", part, " as a float"))
+		}
+	}
+
+	switch len(numbers) {
+	case 1:
+		return [4]float64{numbers[0], numbers[0], numbers[0], numbers[0]}, nil
+	case 2:
+		return [4]float64{numbers[0], numbers[1], numbers[0], numbers[1]}, nil
+	case 4:
+		return [4]float64{numbers[0], numbers[1], numbers[2], numbers[3]}, nil
+	}
+	return margins, errors.New(fmt.Sprint("Expected 1, 2 or 4 margins and saw ", len(numbers)))
+}
+
+// Check the anchor is one that is understood
+func (placement Placement) Validate() error {
+	if _, ok := placementAnchors[placement.Anchor]; placement.Anchor != "" && !ok {
+		return errors.New(fmt.Sprint("Unknown anchor ", placement.Anchor, ", expected topleft, top, topright, left, center, right, bottomleft, bottom or bottomright"))
+	}
+	return nil
+}
+
+// Scale and move the coordinates within surface, returns an error describing how far the result runs past the surface if it does not fit
+func (placement Placement) Place(coords []Coordinate, surface Bounds) ([]Coordinate, error) {
+	if err := placement.Validate(); err != nil {
+		return nil, err
+	}
+	if len(coords) == 0 {
+		return coords, nil
+	}
+
+	area := Bounds{
+		Min: Coordinate{X: surface.Min.X + placement.Margins[3], Y: surface.Min.Y + placement.Margins[0]},
+		Max: Coordinate{X: surface.Max.X - placement.Margins[1], Y: surface.Max.Y - placement.Margins[2]},
+	}
+	if area.Width() <= 0 || area.Height() <= 0 {
+		return nil, errors.New(fmt.Sprint("Margins leave no room on the drawing surface ", surface))
+	}
+
+	drawing := DrawingBounds(coords)
+	anchor := placement.Anchor
+	scale := 1.0
+
+	if placement.Fit {
+		if anchor == "" {
+			anchor = "center"
+		}
+		scale = math.Inf(1)
+		if drawing.Width() > 0 {
+			scale = area.Width() / drawing.Width()
+		}
+		if drawing.Height() > 0 {
+			scale = math.Min(scale, area.Height()/drawing.Height())
+		}
+		if math.IsInf(scale, 1) {
+			scale = 1
+		}
+	}
+
+	offset := Coordinate{}
+	if anchor != "" {
+		fraction := placementAnchors[anchor]
+		target := Coordinate{
+			X: area.Min.X + fraction.X*(area.Width()-drawing.Width()*scale),
+			Y: area.Min.Y + fraction.Y*(area.Height()-drawing.Height()*scale),
+		}
+		offset = target.Minus(drawing.Min.Scaled(scale))
+		offset.PenUp = false
+	}
+
+	place := func(coord Coordinate) Coordinate {
+		return coord.Scaled(scale).Add(offset)
+	}
+
+	placed := make([]Coordinate, 0, len(coords)+1)
+	if anchor != "" {
+		// the pen starts at 0,0, which is no longer where the drawing starts, so travel to the start with the pen up
+		start := place(coords[0])
+		start.PenUp = true
+		placed = append(placed, start)
+	}
+	for _, coord := range coords {
+		placed = append(placed, coord.Transformed(place))
+	}
+
+	// allow for floating point error when the drawing was fit exactly to the area
+	const tolerance = 0.001
+	result := DrawingBounds(placed)
+	var overflows []string
+	if over := area.Min.X - result.Min.X; over > tolerance {
+		overflows = append(overflows, fmt.Sprintf("%.1f mm past the left", over))
+	}
+	if over := result.Max.X - area.Max.X; over > tolerance {
+		overflows = append(overflows, fmt.Sprintf("%.1f mm past the right", over))
+	}
+	if over := area.Min.Y - result.Min.Y; over > tolerance {
+		overflows = append(overflows, fmt.Sprintf("%.1f mm past the top", over))
+	}
+	if over := result.Max.Y - area.Max.Y; over > tolerance {
+		overflows = append(overflows, fmt.Sprintf("%.1f mm past the bottom", over))
+	}
+	if len(overflows) > 0 {
+		return placed, errors.New(fmt.Sprint("Drawing ", result, " does not fit on the drawing surface ", area, ", it extends ", strings.Join(overflows, ", ")))
+	}
+
+	return placed, nil
+}
+
+// True if the placement moves or scales the drawing
+func (placement Placement) Moves() bool {
+	return placement.Fit || placement.Anchor != "" || placement.Margins != [4]float64{}
+}
+
+// Buffer the whole drawing and place it relative to the pen position in settings.
+// If it does not fit the error is returned along with the placed coordinates, so they can still be previewed.
+// An unchecked drawing that is not moved is streamed as is, and one that is moved is sent even if it does not fit
+func PlaceDrawing(placement Placement, plotCoords <-chan Coordinate) (<-chan Coordinate, error) {
+	if placement.Unchecked && !placement.Moves() {
+		return plotCoords, nil
+	}
+
+	var coords []Coordinate
+	for coord := range plotCoords {
+		coords = append(coords, coord)
+	}
+
+	start := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
+	placed, err := placement.Place(coords, SurfaceBounds(start))
+	if placed == nil {
+		return nil, err
+	} else if placement.Unchecked {
+		err = nil
+	}
+
+	placedCoords := make(chan Coordinate, len(placed))
+	for _, coord := range placed {
+		placedCoords <- coord
+	}
+	close(placedCoords)
+	return placedCoords, err
+}
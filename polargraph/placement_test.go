@@ -0,0 +1,99 @@
+package polargraph
+
+// Tests for placing drawings on the drawing surface
+
+import (
+	"testing"
+)
+
+// Fitting should scale the drawing to the limiting axis and anchor it within the margins
+func TestPlacementFit(t *testing.T) {
+	surface := Bounds{Min: Coordinate{X: -100, Y: -50}, Max: Coordinate{X: 300, Y: 350}}
+	coords := []Coordinate{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 5}}
+
+	placement := Placement{Fit: true, Anchor: "topleft", Margins: [4]float64{10, 20, 30, 40}}
+	placed, err := placement.Place(coords, surface)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// area is 340 wide and 360 tall, so width limits the scale to 34
+	expected := []Coordinate{{X: -60, Y: -40, PenUp: true}, {X: -60, Y: -40}, {X: 280, Y: -40}, {X: 280, Y: 130}}
+	if len(placed) != len(expected) {
+		t.Fatal("Expected", expected, "and saw", placed)
+	}
+	for index := range expected {
+		if !placed[index].Equals(expected[index]) {
+			t.Error("Expected", expected, "and saw", placed)
+			break
+		}
+	}
+}
+
+// Centering should not scale the drawing
+func TestPlacementCenter(t *testing.T) {
+	surface := Bounds{Min: Coordinate{X: 0, Y: 0}, Max: Coordinate{X: 100, Y: 100}}
+	coords := []Coordinate{{X: -5, Y: -5}, {X: 5, Y: 5}}
+
+	placed, err := Placement{Anchor: "center"}.Place(coords, surface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := DrawingBounds(placed)
+	if !bounds.Min.Equals(Coordinate{X: 45, Y: 45}) || !bounds.Max.Equals(Coordinate{X: 55, Y: 55}) {
+		t.Error("Expected drawing to be centered and saw", bounds)
+	}
+}
+
+// Drawings that run off the surface should be refused
+func TestPlacementRefusesOverflow(t *testing.T) {
+	surface := Bounds{Min: Coordinate{X: -10, Y: -10}, Max: Coordinate{X: 100, Y: 100}}
+	coords := []Coordinate{{X: 0, Y: 0}, {X: 150, Y: 0}}
+
+	if _, err := (Placement{}).Place(coords, surface); err == nil {
+		t.Error("Expected drawing past the right edge to be refused")
+	}
+	if _, err := (Placement{Anchor: "middle"}).Place(coords, surface); err == nil {
+		t.Error("Expected unknown anchor to be refused")
+	}
+	if _, err := (Placement{Fit: true}).Place(coords, surface); err != nil {
+		t.Error("Expected fit drawing to be placed", err)
+	}
+}
+
+// Margins can be given for all sides, vertical and horizontal, or each side
+func TestParseMargins(t *testing.T) {
+	for value, expected := range map[string][4]float64{
+		"5":       {5, 5, 5, 5},
+		"5,10":    {5, 10, 5, 10},
+		"1,2,3,4": {1, 2, 3, 4},
+	} {
+		if margins, err := ParseMargins(value); err != nil || margins != expected {
+			t.Error("Expected", expected, "for", value, "and saw", margins, err)
+		}
+	}
+	if _, err := ParseMargins("1,2,3"); err == nil {
+		t.Error("Expected 3 margins to be refused")
+	}
+}
+
+// Without placement flags the drawing should still be refused if it runs off the surface, unless it is unchecked
+func TestPlaceDrawingChecksBounds(t *testing.T) {
+	setupTestSettings()
+	oversized := func() <-chan Coordinate {
+		plotCoords := make(chan Coordinate, 2)
+		plotCoords <- Coordinate{X: 0, Y: 0}
+		plotCoords <- Coordinate{X: 2 * Settings.SpoolHorizontalDistance_MM, Y: 0}
+		close(plotCoords)
+		return plotCoords
+	}
+
+	if _, err := PlaceDrawing(Placement{}, oversized()); err == nil {
+		t.Error("Expected a drawing past the right edge to be refused")
+	}
+
+	plotCoords := oversized()
+	if placed, err := PlaceDrawing(Placement{Unchecked: true}, plotCoords); err != nil || placed != plotCoords {
+		t.Error("Expected an unchecked drawing to be passed through", err)
+	}
+}
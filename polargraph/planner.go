@@ -0,0 +1,326 @@
+package polargraph
+
+// Plans the speed at each coordinate by looking several coordinates ahead, so short segments can reach cruise speed and slow down in time for corners.
+// Speeds are also limited so neither string moves or accelerates faster than its motor can step
+
+import (
+	"math"
+)
+
+// Default number of coordinates the planner looks ahead
+const DefaultLookaheadSegments = 32
+
+// A straight move with the speeds to enter and leave it at
+type PlannedSegment struct {
+	Origin Coordinate // position the move starts from
+	Dest   Coordinate // coordinate being moved to, including its PenUp
+
+	EntrySpeed float64 // speed at Origin
+	ExitSpeed  float64 // speed at Dest, always reachable from EntrySpeed over the distance at the planner's acceleration
+	MaxSpeed   float64 // fastest the move may go
+
+	Acceleration float64 // acceleration and deceleration to use
+
+	// Curve followed to Dest, nil for a straight line
+	Path *CurvePath
+}
+
+// Length of the move
+func (segment PlannedSegment) Distance() float64 {
+	if segment.Path != nil {
+		return segment.Path.Length()
+	}
+	return segment.Dest.Minus(segment.Origin).Len()
+}
+
+// Point the given distance along the move
+func (segment PlannedSegment) Position(distance float64) Coordinate {
+	if segment.Path != nil {
+		return segment.Path.At(distance)
+	}
+	movement := segment.Dest.Minus(segment.Origin)
+	if length := movement.Len(); length > 0 {
+		return segment.Origin.Add(movement.Scaled(distance / length))
+	}
+	return segment.Origin
+}
+
+// Unit direction of travel the given distance along the move, zero if the move does not go anywhere
+func (segment PlannedSegment) Direction(distance float64) Coordinate {
+	if segment.Path != nil {
+		return segment.Path.DirectionAt(distance)
+	}
+	movement := segment.Dest.Minus(segment.Origin)
+	if movement.Len() == 0 {
+		return Coordinate{}
+	}
+	direction := movement.Normalized()
+	direction.PenUp = false
+	return direction
+}
+
+// Buffers upcoming coordinates and assigns each move feasible entry and exit speeds.
+// Speeds are planned with a backward pass from a stop at the end of the window, limited by the speed allowed through each corner,
+// followed by a forward pass from the current speed limited by how fast the pen can accelerate
+type MotionPlanner struct {
+	MaxSpeed     float64 // fastest a move with the pen down may go
+	Acceleration float64 // acceleration and deceleration used for moves with the pen down
+
+	TravelMaxSpeed     float64 // fastest a move with the pen up may go
+	TravelAcceleration float64 // acceleration and deceleration used for moves with the pen up
+
+	// Polar system the coordinates are drawn in, used to limit how fast each string changes length, nil to not limit the strings
+	PolarSystem *PolarSystem
+
+	SpoolMaxSpeed     float64 // fastest either string may change length
+	SpoolAcceleration float64 // fastest either string's speed may change
+
+	// Plan with the distance the S-curve interpolater needs to change speed at a limited jerk, instead of at a constant acceleration
+	JerkLimited bool
+
+	buffer    []bufferedSegment // moves that have not been planned yet, at most lookahead of them
+	lookahead int
+	position  Coordinate // end of the last move returned by Next
+	speed     float64    // speed at position
+
+	last          Coordinate // coordinate most recently added, where the next move starts
+	lastDirection Coordinate // direction at the end of the most recently added move that went somewhere
+
+	// reused between calls to Next
+	segments []PlannedSegment
+}
+
+// A move waiting to be planned, with its speed limits and directions worked out when it was added
+type bufferedSegment struct {
+	segment        PlannedSegment
+	startDirection Coordinate // direction leaving Origin
+	endDirection   Coordinate // direction arriving at Dest
+}
+
+// Create a planner starting stopped at position, looking ahead up to lookahead coordinates
+func NewMotionPlanner(position Coordinate, lookahead int) *MotionPlanner {
+	if lookahead < 2 {
+		lookahead = 2
+	}
+	spoolAcceleration := Settings.SpoolAcceleration_MM_S2
+	if spoolAcceleration == 0 {
+		spoolAcceleration = Settings.Acceleration_MM_S2
+	}
+	planner := &MotionPlanner{
+		SpoolMaxSpeed:     Settings.SpoolMaxSpeed_MM_S,
+		SpoolAcceleration: spoolAcceleration,
+		JerkLimited:       Settings.Interpolater == SCurveInterpolation,
+		buffer:            make([]bufferedSegment, 0, lookahead),
+		lookahead:         lookahead,
+		position:          position,
+		last:              position,
+		segments:          make([]PlannedSegment, 0, lookahead),
+	}
+	planner.MaxSpeed, planner.Acceleration = Settings.MoveProfile(false)
+	planner.TravelMaxSpeed, planner.TravelAcceleration = Settings.MoveProfile(true)
+	return planner
+}
+
+// No more coordinates can be added until Next is called
+func (planner *MotionPlanner) Full() bool {
+	return len(planner.buffer) == planner.lookahead
+}
+
+// Number of coordinates waiting to be planned
+func (planner *MotionPlanner) Len() int {
+	return len(planner.buffer)
+}
+
+// Add a coordinate to the end of the window, limiting the move to it by its curvature and the spools
+func (planner *MotionPlanner) Add(target Coordinate) {
+	if planner.Full() {
+		panic("Attempted to overfill planner")
+	}
+
+	segment := PlannedSegment{
+		Origin:       planner.last,
+		Dest:         target,
+		MaxSpeed:     planner.MaxSpeed,
+		Acceleration: planner.Acceleration,
+		Path:         planner.path(planner.last, target),
+	}
+	if target.PenUp {
+		segment.MaxSpeed, segment.Acceleration = planner.TravelMaxSpeed, planner.TravelAcceleration
+	}
+	planner.limitCurvature(&segment)
+	planner.limitSpools(&segment)
+
+	// a repeated coordinate keeps the previous direction, so the corner around it is still limited
+	buffered := bufferedSegment{segment: segment, startDirection: planner.lastDirection}
+	if segment.Distance() > 0 {
+		buffered.startDirection = segment.Direction(0)
+		planner.lastDirection = segment.Direction(segment.Distance())
+	}
+	buffered.endDirection = planner.lastDirection
+
+	planner.buffer = append(planner.buffer, buffered)
+	planner.last = target
+}
+
+// Plan every buffered coordinate, then remove the first one and return the move to it.
+// The window always ends stopped, since nothing is known about the coordinates after it
+func (planner *MotionPlanner) Next() PlannedSegment {
+	if len(planner.buffer) == 0 {
+		panic("Attempted to plan with no coordinates")
+	}
+
+	segments := planner.segments[:0]
+	for _, buffered := range planner.buffer {
+		segments = append(segments, buffered.segment)
+	}
+
+	// backward pass, the fastest each move can be entered at and still slow down in time for everything after it
+	exitSpeed := 0.0
+	for index := len(segments) - 1; index >= 0; index-- {
+		segment := &segments[index]
+		segment.ExitSpeed = exitSpeed
+
+		entrySpeed := math.Min(segment.MaxSpeed, planner.reachableSpeed(*segment, exitSpeed))
+		if index > 0 {
+			entrySpeed = math.Min(entrySpeed, junctionSpeed(segments[index-1], *segment, planner.buffer[index-1].endDirection, planner.buffer[index].startDirection))
+		}
+		segment.EntrySpeed = entrySpeed
+		exitSpeed = entrySpeed
+	}
+
+	// forward pass, limit each move to what can be reached by accelerating from the current speed
+	entrySpeed := planner.speed
+	for index := range segments {
+		segment := &segments[index]
+		segment.EntrySpeed = entrySpeed
+		segment.ExitSpeed = math.Min(segment.ExitSpeed, planner.reachableSpeed(*segment, entrySpeed))
+		entrySpeed = segment.ExitSpeed
+	}
+
+	planned := segments[0]
+	planner.position = planned.Dest
+	planner.speed = planned.ExitSpeed
+	planner.buffer = planner.buffer[:copy(planner.buffer, planner.buffer[1:])]
+	planner.segments = segments
+	return planned
+}
+
+// Distance a curve can start from the previous coordinate and still be followed
+const curveStartTolerance_MM = 0.01
+
+// Sampled curve of the move from origin to dest, nil if it is a straight line or its curve does not start at origin
+func (planner *MotionPlanner) path(origin, dest Coordinate) *CurvePath {
+	if dest.Curve == nil {
+		return nil
+	}
+	path := NewCurvePath(dest.Curve)
+	if path.At(0).Minus(origin).Len() > curveStartTolerance_MM || path.At(path.Length()).Minus(dest).Len() > curveStartTolerance_MM {
+		return nil
+	}
+	return path
+}
+
+// Slow a curved segment so the sideways acceleration through its tightest part is no more than the planner's acceleration
+func (planner *MotionPlanner) limitCurvature(segment *PlannedSegment) {
+	if segment.Path == nil {
+		return
+	}
+	if curvature := segment.Path.MaxCurvature(); curvature > 0 {
+		segment.MaxSpeed = math.Min(segment.MaxSpeed, math.Sqrt(segment.Acceleration/curvature))
+	}
+}
+
+// Distance between the points along a segment where the string speeds are checked
+const spoolLimitSampleDist_MM = 10
+
+// Slow the segment wherever either string would change length faster than its motor can step.
+// When the pen moves at speed v and acceleration a along the segment, a string of length L changes length with
+// a speed of v dL/ds and an acceleration of a dL/ds + v^2 d2L/ds2, these are limited at points sampled along the segment.
+// Half the spool acceleration is reserved for the v^2 term, which only matters close to the motors
+func (planner *MotionPlanner) limitSpools(segment *PlannedSegment) {
+	if planner.PolarSystem == nil {
+		return
+	}
+
+	distance := segment.Distance()
+	if distance == 0 {
+		return
+	}
+
+	samples := int(math.Min(math.Ceil(distance/spoolLimitSampleDist_MM), 16)) + 1
+	var rates, curvatures []float64
+	for sample := 0; sample < samples; sample++ {
+		sampleDistance := distance * float64(sample) / float64(samples-1)
+		point := segment.Position(sampleDistance)
+		leftRate, rightRate, leftCurvature, rightCurvature := stringDerivatives(point, segment.Direction(sampleDistance), *planner.PolarSystem)
+		rates = append(rates, leftRate, rightRate)
+		curvatures = append(curvatures, leftCurvature, rightCurvature)
+	}
+
+	for index := range rates {
+		if rates[index] > 0 {
+			segment.MaxSpeed = math.Min(segment.MaxSpeed, planner.SpoolMaxSpeed/rates[index])
+		}
+		if curvatures[index] > 0 {
+			segment.MaxSpeed = math.Min(segment.MaxSpeed, math.Sqrt(planner.SpoolAcceleration/(2*curvatures[index])))
+		}
+	}
+	for index := range rates {
+		if rates[index] > 0 {
+			remaining := planner.SpoolAcceleration - segment.MaxSpeed*segment.MaxSpeed*curvatures[index]
+			segment.Acceleration = math.Min(segment.Acceleration, remaining/rates[index])
+		}
+	}
+}
+
+// Rate each string's length changes per mm moved in direction from point, and how quickly that rate changes, found numerically so any polar geometry works
+func stringDerivatives(point, direction Coordinate, system PolarSystem) (leftRate, rightRate, leftCurvature, rightCurvature float64) {
+	const step = 0.5
+	before, _ := point.Add(direction.Scaled(-step)).ToPolarClipped(system)
+	center, _ := point.ToPolarClipped(system)
+	after, _ := point.Add(direction.Scaled(step)).ToPolarClipped(system)
+
+	leftRate = math.Abs(after.LeftDist-before.LeftDist) / (2 * step)
+	rightRate = math.Abs(after.RightDist-before.RightDist) / (2 * step)
+	leftCurvature = math.Abs(after.LeftDist-2*center.LeftDist+before.LeftDist) / (step * step)
+	rightCurvature = math.Abs(after.RightDist-2*center.RightDist+before.RightDist) / (step * step)
+	return
+}
+
+// Fastest speed that can be reached from speed over the segment, changing speed at a limited jerk when the planner is JerkLimited.
+// The ramp takes the same distance speeding up or slowing down between two speeds, so this is also the fastest speed that can slow down to speed
+func (planner *MotionPlanner) reachableSpeed(segment PlannedSegment, speed float64) float64 {
+	distance := segment.Distance()
+	reachable := reachableSpeed(speed, segment.Acceleration, distance)
+	if !planner.JerkLimited || reachable == speed {
+		return reachable
+	}
+
+	// limiting the jerk always needs more distance than a constant acceleration, so the speed is somewhere below reachable
+	jerk := settingsJerk(segment.Acceleration)
+	low, high := speed, reachable
+	for iteration := 0; iteration < 40; iteration++ {
+		if middle := (low + high) / 2; newSpeedRamp(speed, middle, segment.Acceleration, jerk).totalDist <= distance {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return low
+}
+
+// Speed reached by accelerating from speed over distance
+func reachableSpeed(speed, acceleration, distance float64) float64 {
+	return math.Sqrt(speed*speed + 2*acceleration*distance)
+}
+
+// Fastest the pen can go through the corner between two moves with the given unit directions, zero if the pen is raised or lowered between them
+func junctionSpeed(first, second PlannedSegment, firstDirection, secondDirection Coordinate) float64 {
+	if first.Dest.PenUp != second.Dest.PenUp {
+		return 0
+	}
+
+	cosAngle := firstDirection.DotProduct(secondDirection)
+	cosAngle = math.Pow(cosAngle, 3) // use cube in order to make it smaller for non straight lines
+	return math.Min(first.MaxSpeed, second.MaxSpeed) * math.Max(cosAngle, 0.0)
+}
@@ -0,0 +1,158 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Plan every coordinate, filling the window the same way the generator does
+func planAll(coords []Coordinate, lookahead int) []PlannedSegment {
+	planner := NewMotionPlanner(Coordinate{}, lookahead)
+	var segments []PlannedSegment
+	for len(coords) > 0 || planner.Len() > 0 {
+		for len(coords) > 0 && !planner.Full() {
+			planner.Add(coords[0])
+			coords = coords[1:]
+		}
+		segments = append(segments, planner.Next())
+	}
+	return segments
+}
+
+// Many short collinear segments should reach full speed, which a single segment of the same length can not
+func TestPlannerReachesCruiseOnDensePath(t *testing.T) {
+	setupTestSettings()
+
+	var coords []Coordinate
+	for x := 1.0; x <= 400; x++ {
+		coords = append(coords, Coordinate{X: x, Y: 0})
+	}
+	segments := planAll(coords, 64)
+
+	maxSpeed := 0.0
+	for _, segment := range segments {
+		maxSpeed = math.Max(maxSpeed, segment.ExitSpeed)
+	}
+	if math.Abs(maxSpeed-Settings.MaxSpeed_MM_S) > 0.001 {
+		t.Error("Expected to reach", Settings.MaxSpeed_MM_S, "but only reached", maxSpeed)
+	}
+	if last := segments[len(segments)-1]; last.ExitSpeed != 0 {
+		t.Error("Expected to stop at the end, exit speed was", last.ExitSpeed)
+	}
+}
+
+// Each segment's exit speed must be reachable from its entry speed, and segments must join at the same speed
+func TestPlannerSpeedsAreFeasible(t *testing.T) {
+	setupTestSettings()
+
+	coords := []Coordinate{
+		{X: 10, Y: 0}, {X: 20, Y: 1}, {X: 20, Y: 1}, {X: 30, Y: 0}, {X: 30, Y: 10},
+		{X: 31, Y: 20}, {X: 0, Y: 0, PenUp: true}, {X: 5, Y: 5}, {X: 6, Y: 6}, {X: 100, Y: 6},
+	}
+	segments := planAll(coords, 4)
+	if len(segments) != len(coords) {
+		t.Fatal("Expected", len(coords), "segments, got", len(segments))
+	}
+
+	previousExit := 0.0
+	for index, segment := range segments {
+		if segment.Dest != coords[index] {
+			t.Error("Segment", index, "expected dest", coords[index], "got", segment.Dest)
+		}
+		if segment.EntrySpeed != previousExit {
+			t.Error("Segment", index, "entry", segment.EntrySpeed, "does not match previous exit", previousExit)
+		}
+		change := math.Abs(segment.ExitSpeed*segment.ExitSpeed - segment.EntrySpeed*segment.EntrySpeed)
+		if change > 2*segment.Acceleration*segment.Distance()+0.001 {
+			t.Error("Segment", index, "can not change speed from", segment.EntrySpeed, "to", segment.ExitSpeed, "over", segment.Distance())
+		}
+		if segment.ExitSpeed > Settings.MaxSpeed_MM_S {
+			t.Error("Segment", index, "exceeds max speed", segment.ExitSpeed)
+		}
+		previousExit = segment.ExitSpeed
+	}
+
+	// a right angle corner and the pen being raised both require stopping
+	if segments[3].ExitSpeed != 0 {
+		t.Error("Expected to stop at the right angle corner, exit speed was", segments[3].ExitSpeed)
+	}
+	if segments[5].ExitSpeed != 0 || segments[6].ExitSpeed != 0 {
+		t.Error("Expected to stop around the pen up move, exit speeds were", segments[5].ExitSpeed, segments[6].ExitSpeed)
+	}
+}
+
+// A planned segment should end exactly at its destination with its exit speed
+func TestTrapezoidSetupSegment(t *testing.T) {
+	setupTestSettings()
+
+	segment := PlannedSegment{
+		Origin:       Coordinate{X: 0, Y: 0},
+		Dest:         Coordinate{X: 30, Y: 40},
+		EntrySpeed:   10,
+		ExitSpeed:    20,
+		MaxSpeed:     Settings.MaxSpeed_MM_S,
+		Acceleration: Settings.Acceleration_MM_S2,
+	}
+	interp := new(TrapezoidInterpolater)
+	interp.SetupSegment(segment)
+
+	if end := interp.Position(interp.Slices()); end.Minus(segment.Dest).Len() > 0.0001 {
+		t.Error("Expected to end at", segment.Dest, "but ended at", end)
+	}
+	sliceTime := TimeSlice_US / 1000000
+	last := interp.Position(interp.Slices())
+	beforeLast := interp.Position(interp.Slices() - 1)
+	if speed := last.Minus(beforeLast).Len() / sliceTime; math.Abs(speed-segment.ExitSpeed) > 0.5 {
+		t.Error("Expected exit speed near", segment.ExitSpeed, "got", speed)
+	}
+}
+
+// Moving straight towards a motor changes its string length as fast as the pen moves, so a pen speed above what the motor can step has to be slowed
+func TestPlannerLimitsStringSpeed(t *testing.T) {
+	setupTestSettings()
+	Settings.MaxSpeed_MM_S = 1.5 * Settings.SpoolMaxSpeed_MM_S
+
+	start := NewStepGenerator().Origin.ToCoord(PolarSystemFromSettings())
+	towardsRightMotor := Coordinate{X: Settings.SpoolHorizontalDistance_MM - start.X, Y: -start.Y}.Normalized().Scaled(300)
+
+	plotCoords := make(chan Coordinate, 10)
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	plotCoords <- towardsRightMotor
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Stats = new(StepStats)
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+	for range stepData {
+	}
+
+	if generator.Stats.ClampedSlices != 0 {
+		t.Error("Expected no clamped slices and saw", generator.Stats.ClampedSlices)
+	}
+	if generator.Stats.FinalCoordinate.Len() > 1 {
+		t.Error("Expected to finish at 0,0 and saw", generator.Stats.FinalCoordinate)
+	}
+}
+
+// Pen up moves should use the travel speed and acceleration, drawing moves the draw settings
+func TestPlannerTravelAndDrawProfiles(t *testing.T) {
+	setupTestSettings()
+	Settings.DrawSpeed_MM_S = 20
+	Settings.DrawAcceleration_MM_S2 = 100
+	Settings.TravelAcceleration_MM_S2 = 2 * Settings.Acceleration_MM_S2
+
+	segments := planAll([]Coordinate{{X: 300, Y: 0, PenUp: true}, {X: 300, Y: 300}}, 4)
+	if segments[0].MaxSpeed != Settings.MaxSpeed_MM_S || segments[0].Acceleration != Settings.TravelAcceleration_MM_S2 {
+		t.Error("Expected the travel move to use speed", Settings.MaxSpeed_MM_S, "and acceleration", Settings.TravelAcceleration_MM_S2, "got", segments[0].MaxSpeed, segments[0].Acceleration)
+	}
+	if segments[1].MaxSpeed != 20 || segments[1].Acceleration != 100 {
+		t.Error("Expected the drawing move to use speed 20 and acceleration 100, got", segments[1].MaxSpeed, segments[1].Acceleration)
+	}
+
+	Settings.DrawSpeed_MM_S = 2 * Settings.MaxSpeed_MM_S
+	if speed, _ := Settings.MoveProfile(false); speed != Settings.MaxSpeed_MM_S {
+		t.Error("Expected the draw speed to be limited to", Settings.MaxSpeed_MM_S, "got", speed)
+	}
+}
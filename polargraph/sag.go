@@ -0,0 +1,110 @@
+package polargraph
+
+// Physical model of strings that sag under their own weight, used so the string lengths sent to the motors put the gondola where it was asked to go
+
+import (
+	"math"
+)
+
+// Each string hangs as a catenary between its motor and the gondola. Both strings pull on the gondola with the same horizontal tension,
+// and the upward pull of the two strings at the gondola holds up its weight. Only the ratio of the weights matters, so any unit can be used
+type SagModel struct {
+	StringWeight  float64 // weight of a mm of string
+	GondolaWeight float64 // weight of the gondola
+}
+
+// Create the model described by settings, nil if the strings should be treated as straight
+func SagModelFromSettings() *SagModel {
+	if Settings.StringWeight_G_M <= 0 || Settings.GondolaMass_G <= 0 {
+		return nil
+	}
+	return &SagModel{
+		StringWeight:  Settings.StringWeight_G_M / 1000,
+		GondolaWeight: Settings.GondolaMass_G,
+	}
+}
+
+// Smallest span and drop a string is given, a catenary can not be found for a string that hangs straight down or is level with its motor
+const minStringSpan_MM = 0.001
+
+// Length of the strings from motors at 0,0 and rightMotorDist,0 to where they attach to the gondola at the absolute positions leftEnd and rightEnd
+func (model *SagModel) StringLengths(leftEnd, rightEnd Coordinate, rightMotorDist float64) (left, right float64) {
+	leftSpan := math.Max(leftEnd.X, minStringSpan_MM)
+	rightSpan := math.Max(rightMotorDist-rightEnd.X, minStringSpan_MM)
+	leftDrop := math.Max(leftEnd.Y, minStringSpan_MM)
+	rightDrop := math.Max(rightEnd.Y, minStringSpan_MM)
+
+	tension := model.horizontalTension(leftSpan, leftDrop, rightSpan, rightDrop)
+	return model.catenaryLength(leftSpan, leftDrop, tension), model.catenaryLength(rightSpan, rightDrop, tension)
+}
+
+// Horizontal tension that lets the strings hold up the gondola, found by bisection since the upward pull grows with the tension
+func (model *SagModel) horizontalTension(leftSpan, leftDrop, rightSpan, rightDrop float64) float64 {
+	support := func(tension float64) float64 {
+		return model.upwardPull(leftSpan, leftDrop, tension) + model.upwardPull(rightSpan, rightDrop, tension)
+	}
+
+	// straight massless strings need the least tension, sagging strings leave the gondola at a shallower angle and need more
+	low := model.GondolaWeight / (leftDrop/leftSpan + rightDrop/rightSpan)
+	high := 2 * low
+	for support(high) < model.GondolaWeight {
+		low = high
+		high *= 2
+	}
+	for iteration := 0; iteration < 60; iteration++ {
+		middle := (low + high) / 2
+		if support(middle) < model.GondolaWeight {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2
+}
+
+// Angle parameter of a catenary with the given span and drop at its lower end, where its slope is the sinh of it
+func (model *SagModel) lowerEndAngle(span, drop, tension float64) float64 {
+	catenary := tension / model.StringWeight
+	halfSpan := span / (2 * catenary)
+	return math.Asinh(drop/(2*catenary*math.Sinh(halfSpan))) - halfSpan
+}
+
+// Upward pull of a string on the gondola, its horizontal tension times its slope where it meets the gondola
+func (model *SagModel) upwardPull(span, drop, tension float64) float64 {
+	return tension * math.Sinh(model.lowerEndAngle(span, drop, tension))
+}
+
+// Length of a catenary with the given span and drop
+func (model *SagModel) catenaryLength(span, drop, tension float64) float64 {
+	catenary := tension / model.StringWeight
+	chord := 2 * catenary * math.Sinh(span/(2*catenary))
+	return math.Sqrt(drop*drop + chord*chord)
+}
+
+// Absolute position of the pen that gives the string lengths in a system with a SagModel,
+// found with Newton's method starting from the straight string position
+func (system PolarSystem) sagPosition(left, right float64) (x, y float64) {
+	x, y = system.straightPosition(left, right)
+
+	const step = 0.01
+	for iteration := 0; iteration < 20; iteration++ {
+		leftLength, rightLength := system.stringLengths(x, y)
+		leftError, rightError := leftLength-left, rightLength-right
+		if math.Abs(leftError) < 1e-6 && math.Abs(rightError) < 1e-6 {
+			break
+		}
+
+		// jacobian of the string lengths found numerically
+		leftX, rightX := system.stringLengths(x+step, y)
+		leftY, rightY := system.stringLengths(x, y+step)
+		a, b := (leftX-leftLength)/step, (leftY-leftLength)/step
+		c, d := (rightX-rightLength)/step, (rightY-rightLength)/step
+		determinant := a*d - b*c
+		if determinant == 0 {
+			break
+		}
+		x -= (d*leftError - b*rightError) / determinant
+		y -= (a*rightError - c*leftError) / determinant
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Sagging strings are longer than the straight line to the gondola, and converting back should give the same position
+func TestSagModelRoundTrip(t *testing.T) {
+	system := PolarSystem{XMin: 25, XMax: 975, YMin: 50, YMax: 2000, RightMotorDist: 1000, Sag: &SagModel{StringWeight: 0.0005, GondolaWeight: 200}}
+	straight := system
+	straight.Sag = nil
+
+	for _, coord := range []Coordinate{{X: 500, Y: 800}, {X: 60, Y: 80}, {X: 900, Y: 1900}, {X: 300, Y: 150}} {
+		polar := coord.ToPolar(system)
+		straightPolar := coord.ToPolar(straight)
+		if polar.LeftDist <= straightPolar.LeftDist || polar.RightDist <= straightPolar.RightDist {
+			t.Error("Expected sagging strings to be longer at", coord, "got", polar, "straight", straightPolar)
+		}
+		if back := polar.ToCoord(system); back.Minus(coord).Len() > 0.001 {
+			t.Error("Expected", coord, "back from", polar, "got", back)
+		}
+	}
+}
+
+// With the gondola centered each string holds up half of its weight
+func TestSagModelSupportsGondola(t *testing.T) {
+	model := &SagModel{StringWeight: 0.0005, GondolaWeight: 200}
+	tension := model.horizontalTension(500, 300, 500, 300)
+	if pull := model.upwardPull(500, 300, tension); math.Abs(pull-100) > 0.001 {
+		t.Error("Expected each string to pull up with 100, got", pull)
+	}
+}
@@ -0,0 +1,277 @@
+package polargraph
+
+// Manages S-curve interpolation, where the acceleration ramps up and down at a limited jerk instead of starting and stopping instantly
+
+import (
+	"fmt"
+	"math"
+)
+
+// Change from one speed to another, either jerk limited or at a constant acceleration
+type speedRamp struct {
+	startSpeed, endSpeed float64
+	direction            float64 // 1 when speeding up, -1 when slowing down
+
+	jerk        float64 // rate acceleration changes while ramping it up or down
+	peakAccel   float64 // largest acceleration reached
+	jerkTime    float64 // time spent ramping the acceleration up, the same time is spent ramping it down
+	accelTime   float64 // time spent at peakAccel
+	totalTime   float64
+	totalDist   float64
+	middleDist  float64 // distance covered by the end of the constant acceleration
+	middleSpeed float64 // speed at the end of the constant acceleration
+}
+
+// Jerk limited change from startSpeed to endSpeed, acceleration stays below maxAccel
+func newSpeedRamp(startSpeed, endSpeed, maxAccel, jerk float64) speedRamp {
+	ramp := speedRamp{startSpeed: startSpeed, endSpeed: endSpeed, direction: 1, jerk: jerk}
+	if endSpeed < startSpeed {
+		ramp.direction = -1
+	}
+
+	speedChange := math.Abs(endSpeed - startSpeed)
+	if speedChange == 0 {
+		return ramp
+	}
+
+	// a small speed change never reaches maxAccel, the acceleration ramps straight up and back down
+	ramp.peakAccel = math.Min(maxAccel, math.Sqrt(speedChange*jerk))
+	ramp.jerkTime = ramp.peakAccel / jerk
+	ramp.accelTime = math.Max(speedChange/ramp.peakAccel-ramp.jerkTime, 0)
+	ramp.calculateTotals()
+	return ramp
+}
+
+// Change from startSpeed to endSpeed at a constant acceleration over the given distance, used when there is not enough room to limit the jerk
+func newConstantSpeedRamp(startSpeed, endSpeed, distance float64) speedRamp {
+	ramp := speedRamp{startSpeed: startSpeed, endSpeed: endSpeed, direction: 1}
+	if endSpeed < startSpeed {
+		ramp.direction = -1
+	}
+	if distance == 0 || startSpeed+endSpeed == 0 {
+		return ramp
+	}
+
+	ramp.accelTime = 2 * distance / (startSpeed + endSpeed)
+	ramp.peakAccel = math.Abs(endSpeed-startSpeed) / ramp.accelTime
+	ramp.calculateTotals()
+	return ramp
+}
+
+// Fill in the total time and distance, and the speed and distance at the end of the constant acceleration
+func (ramp *speedRamp) calculateTotals() {
+	ramp.totalTime = 2*ramp.jerkTime + ramp.accelTime
+
+	// the acceleration ramps are symmetric, so the average speed is halfway between the start and end
+	ramp.totalDist = (ramp.startSpeed + ramp.endSpeed) / 2 * ramp.totalTime
+
+	jerkDist, jerkSpeed := ramp.jerkRampUp(ramp.jerkTime)
+	ramp.middleSpeed = jerkSpeed + ramp.direction*ramp.peakAccel*ramp.accelTime
+	ramp.middleDist = jerkDist + jerkSpeed*ramp.accelTime + ramp.direction*0.5*ramp.peakAccel*ramp.accelTime*ramp.accelTime
+}
+
+// Distance and speed time into ramping the acceleration up
+func (ramp *speedRamp) jerkRampUp(time float64) (distance, speed float64) {
+	if ramp.jerkTime == 0 {
+		return 0, ramp.startSpeed
+	}
+	distance = ramp.startSpeed*time + ramp.direction*ramp.jerk*time*time*time/6
+	speed = ramp.startSpeed + ramp.direction*ramp.jerk*time*time/2
+	return
+}
+
+// Distance covered time into the ramp
+func (ramp *speedRamp) Distance(time float64) float64 {
+	if time >= ramp.totalTime {
+		return ramp.totalDist
+	}
+
+	if time < ramp.jerkTime {
+		distance, _ := ramp.jerkRampUp(time)
+		return distance
+	}
+
+	if time < ramp.jerkTime+ramp.accelTime {
+		jerkDist, jerkSpeed := ramp.jerkRampUp(ramp.jerkTime)
+		time -= ramp.jerkTime
+		return jerkDist + jerkSpeed*time + ramp.direction*0.5*ramp.peakAccel*time*time
+	}
+
+	// ramping the acceleration back down
+	time -= ramp.jerkTime + ramp.accelTime
+	return ramp.middleDist + ramp.middleSpeed*time + ramp.direction*(0.5*ramp.peakAccel*time*time-ramp.jerk*time*time*time/6)
+}
+
+// Data needed by the S-curve interpolater
+type SCurveInterpolater struct {
+	origin      Coordinate // positions currently interpolating from
+	destination Coordinate // position currently interpolating towards
+	direction   Coordinate // unit direction vector from origin to destination
+	path        *CurvePath // curve followed instead of a straight line, nil for a straight line
+
+	entrySpeed  float64 // speed at origin
+	cruiseSpeed float64 // maximum speed reached
+	exitSpeed   float64 // speed when we reach destination
+
+	distance float64 // total distance travelled
+	time     float64 // total time to go from origin to destination
+	slices   float64 // number of TimeSlice_US slices
+
+	accel      speedRamp // change from entry speed to cruise speed
+	cruiseTime float64   // time cruising
+	cruiseDist float64   // distance covered while cruising
+	decel      speedRamp // change from cruise speed to exit speed
+}
+
+// Jerk to use, Settings.Jerk_MM_S3 or enough to reach full acceleration in a tenth of a second if it is not set
+func settingsJerk(acceleration float64) float64 {
+	if Settings.Jerk_MM_S3 > 0 {
+		return Settings.Jerk_MM_S3
+	}
+	return acceleration * 10
+}
+
+// Calculate all fields needed, looking one move ahead the same way as the TrapezoidInterpolater
+func (data *SCurveInterpolater) Setup(origin, dest, nextDest Coordinate) {
+
+	segment := PlannedSegment{
+		Origin:       origin,
+		Dest:         dest,
+		EntrySpeed:   data.exitSpeed,
+		MaxSpeed:     Settings.MaxSpeed_MM_S,
+		Acceleration: Settings.Acceleration_MM_S2,
+	}
+
+	direction := dest.Minus(origin)
+	nextDirection := nextDest.Minus(dest)
+	if direction.Len() > 0 && nextDirection.Len() > 0 && origin.PenUp == dest.PenUp {
+		cosAngle := math.Pow(direction.Normalized().DotProduct(nextDirection.Normalized()), 3)
+		segment.ExitSpeed = Settings.MaxSpeed_MM_S * math.Max(cosAngle, 0.0)
+	}
+
+	data.SetupSegment(segment)
+}
+
+// Calculate all fields needed for a segment whose entry and exit speeds were chosen by the MotionPlanner.
+// A JerkLimited planner leaves room for the jerk limited ramps, for speeds planned assuming the acceleration changes instantly
+// the exit speed is lowered if speeding up does not fit, or when slowing down the deceleration is allowed to change instantly
+func (data *SCurveInterpolater) SetupSegment(segment PlannedSegment) {
+
+	data.origin = segment.Origin
+	data.destination = segment.Dest
+	data.direction = data.destination.Minus(data.origin)
+	data.distance = segment.Distance()
+	data.path = segment.Path
+
+	// can not enter faster than the previous segment actually left
+	data.entrySpeed = math.Min(segment.EntrySpeed, data.exitSpeed)
+	data.exitSpeed = segment.ExitSpeed
+	data.cruiseDist = 0
+	data.cruiseTime = 0
+
+	if data.distance == 0 {
+		data.direction = Coordinate{X: 0, Y: 1}
+		data.exitSpeed = data.entrySpeed
+		data.cruiseSpeed = data.entrySpeed
+		data.accel = speedRamp{}
+		data.decel = speedRamp{}
+		data.time = 0
+		data.slices = 0
+		return
+	}
+	if data.direction.Len() > 0 {
+		// a closed curve ends where it started, its direction is only used for straight lines
+		data.direction = data.direction.Normalized()
+	}
+
+	accel := segment.Acceleration
+	jerk := settingsJerk(accel)
+	rampDist := func(from, to float64) float64 {
+		ramp := newSpeedRamp(from, to, accel, jerk)
+		return ramp.totalDist
+	}
+
+	if data.exitSpeed > data.entrySpeed && rampDist(data.entrySpeed, data.exitSpeed) > data.distance {
+		// not enough room to speed up to the exit speed, find the fastest exit speed that fits
+		low, high := data.entrySpeed, data.exitSpeed
+		for iteration := 0; iteration < 50; iteration++ {
+			if middle := (low + high) / 2; rampDist(data.entrySpeed, middle) <= data.distance {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		data.exitSpeed = low
+	}
+
+	if data.exitSpeed < data.entrySpeed && rampDist(data.entrySpeed, data.exitSpeed) > data.distance {
+		// not enough room to slow down while limiting jerk, slow down at a constant rate over the whole distance
+		data.cruiseSpeed = data.entrySpeed
+		data.accel = speedRamp{startSpeed: data.entrySpeed, endSpeed: data.entrySpeed}
+		data.decel = newConstantSpeedRamp(data.entrySpeed, data.exitSpeed, data.distance)
+	} else {
+		// find the fastest cruise speed that leaves room to ramp up to it and back down to the exit speed
+		low, high := math.Max(data.entrySpeed, data.exitSpeed), math.Max(segment.MaxSpeed, math.Max(data.entrySpeed, data.exitSpeed))
+		if rampDist(data.entrySpeed, high)+rampDist(high, data.exitSpeed) <= data.distance {
+			low = high
+		}
+		for iteration := 0; iteration < 50 && low != high; iteration++ {
+			if middle := (low + high) / 2; rampDist(data.entrySpeed, middle)+rampDist(middle, data.exitSpeed) <= data.distance {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		data.cruiseSpeed = low
+		data.accel = newSpeedRamp(data.entrySpeed, data.cruiseSpeed, accel, jerk)
+		data.decel = newSpeedRamp(data.cruiseSpeed, data.exitSpeed, accel, jerk)
+		data.cruiseDist = math.Max(data.distance-data.accel.totalDist-data.decel.totalDist, 0)
+		if data.cruiseSpeed > 0 {
+			data.cruiseTime = data.cruiseDist / data.cruiseSpeed
+		}
+	}
+
+	data.time = data.accel.totalTime + data.cruiseTime + data.decel.totalTime
+	data.slices = data.time / (TimeSlice_US / 1000000)
+}
+
+// Calculate current position at the given slice
+func (data *SCurveInterpolater) Position(slice float64) Coordinate {
+
+	time := (slice / data.slices) * data.time
+	var distanceAlongMovement float64
+
+	if time < data.accel.totalTime {
+		distanceAlongMovement = data.accel.Distance(time)
+	} else if time < data.accel.totalTime+data.cruiseTime {
+		distanceAlongMovement = data.accel.totalDist + (time-data.accel.totalTime)*data.cruiseSpeed
+	} else {
+		time -= data.accel.totalTime + data.cruiseTime
+		distanceAlongMovement = data.accel.totalDist + data.cruiseDist + data.decel.Distance(time)
+	}
+
+	distanceAlongMovement = math.Min(distanceAlongMovement, data.distance)
+	if data.path != nil {
+		return data.path.At(distanceAlongMovement)
+	}
+	return data.origin.Add(data.direction.Scaled(distanceAlongMovement))
+}
+
+// Get total number of slices it takes to move
+func (data *SCurveInterpolater) Slices() float64 {
+	return data.slices
+}
+
+// output data
+func (data *SCurveInterpolater) WriteData() {
+	fmt.Println("Origin:", data.origin, "Dest:", data.destination)
+	fmt.Println("Dir:", data.direction, "Slices:", data.slices)
+	fmt.Println()
+
+	fmt.Println("Entry", data.entrySpeed, "Cruise", data.cruiseSpeed, "Exit", data.exitSpeed)
+
+	fmt.Println("Taccel", data.accel.totalTime, "Tcruise", data.cruiseTime, "Tdecel", data.decel.totalTime)
+	fmt.Println("Daccel", data.accel.totalDist, "Dcruise", data.cruiseDist, "Ddecel", data.decel.totalDist)
+
+	fmt.Println("Total distance", data.distance)
+}
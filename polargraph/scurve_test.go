@@ -0,0 +1,126 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// A jerk limited ramp should cover the distance implied by its average speed, and be continuous where its phases meet
+func TestSpeedRamp(t *testing.T) {
+	ramp := newSpeedRamp(10, 70, 100, 1000)
+	if ramp.accelTime <= 0 {
+		t.Error("Expected to reach the max acceleration, peak was", ramp.peakAccel)
+	}
+	if expected := 40 * ramp.totalTime; math.Abs(ramp.Distance(ramp.totalTime-1e-9)-expected) > 0.001 {
+		t.Error("Expected ramp distance", expected, "got", ramp.Distance(ramp.totalTime-1e-9))
+	}
+	for _, time := range []float64{ramp.jerkTime, ramp.jerkTime + ramp.accelTime} {
+		if before, after := ramp.Distance(time-1e-9), ramp.Distance(time+1e-9); math.Abs(after-before) > 0.0001 {
+			t.Error("Expected ramp to be continuous at", time, "but jumped from", before, "to", after)
+		}
+	}
+
+	small := newSpeedRamp(50, 45, 100, 1000)
+	if small.accelTime != 0 || small.peakAccel >= 100 {
+		t.Error("Expected a small change to never reach max acceleration, peak was", small.peakAccel)
+	}
+}
+
+// The S-curve should end at the destination, and its acceleration should change gradually
+func TestSCurveInterpolater(t *testing.T) {
+	setupTestSettings()
+	Settings.Jerk_MM_S3 = 2000
+
+	segment := PlannedSegment{
+		Origin:       Coordinate{X: 0, Y: 0},
+		Dest:         Coordinate{X: 120, Y: 160},
+		MaxSpeed:     Settings.MaxSpeed_MM_S,
+		Acceleration: Settings.Acceleration_MM_S2,
+	}
+	interp := new(SCurveInterpolater)
+	interp.SetupSegment(segment)
+
+	if end := interp.Position(interp.Slices()); end.Minus(segment.Dest).Len() > 0.0001 {
+		t.Error("Expected to end at", segment.Dest, "but ended at", end)
+	}
+
+	sliceTime := TimeSlice_US / 1000000
+	maxJerk := Settings.Jerk_MM_S3 * sliceTime * sliceTime * sliceTime
+	var previous, previousSpeed, previousAccel float64
+	for slice := 1.0; slice <= interp.Slices(); slice++ {
+		distance := interp.Position(slice).Len()
+		speed := distance - previous
+		accel := speed - previousSpeed
+		if slice > 2 && math.Abs(accel-previousAccel) > maxJerk*1.01+1e-9 {
+			t.Fatal("Jerk at slice", slice, "was", (accel-previousAccel)/maxJerk, "times the limit")
+		}
+		previous, previousSpeed, previousAccel = distance, speed, accel
+	}
+}
+
+// Interpolaters are chosen by name
+func TestNewPositionInterpolater(t *testing.T) {
+	if interp, err := NewPositionInterpolater(SCurveInterpolation); err != nil {
+		t.Error("Expected no error, got", err)
+	} else if _, ok := interp.(*SCurveInterpolater); !ok {
+		t.Error("Expected an SCurveInterpolater, got", interp)
+	}
+	if _, err := NewPositionInterpolater("bezier"); err == nil {
+		t.Error("Expected an error for an unknown interpolater")
+	}
+}
+
+// A job generated with the S-curve interpolater should finish where it was sent
+func TestSCurveGeneratesJob(t *testing.T) {
+	setupTestSettings()
+	Settings.Interpolater = SCurveInterpolation
+
+	plotCoords := make(chan Coordinate, 10)
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	plotCoords <- Coordinate{X: 100, Y: 0}
+	plotCoords <- Coordinate{X: 101, Y: 2}
+	plotCoords <- Coordinate{X: 100, Y: 100}
+	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Stats = new(StepStats)
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+	for range stepData {
+	}
+
+	if generator.Stats.FinalCoordinate.Len() > 1 {
+		t.Error("Expected to finish at 0,0 and saw", generator.Stats.FinalCoordinate)
+	}
+	if generator.Stats.ClampedSlices != 0 {
+		t.Error("Expected no clamped slices and saw", generator.Stats.ClampedSlices)
+	}
+}
+
+// Planned with the S-curve, every segment slowing down for a corner should have room to limit its jerk
+func TestSCurvePlannedDeceleration(t *testing.T) {
+	setupTestSettings()
+	Settings.Interpolater = SCurveInterpolation
+
+	var coords []Coordinate
+	for x := 1.0; x <= 400; x++ {
+		coords = append(coords, Coordinate{X: x, Y: 0})
+	}
+	coords = append(coords, Coordinate{X: 400, Y: 100})
+
+	interp := new(SCurveInterpolater)
+	decelerating := 0
+	for _, segment := range planAll(coords, DefaultLookaheadSegments) {
+		interp.SetupSegment(segment)
+		if segment.ExitSpeed < segment.EntrySpeed {
+			decelerating++
+			if interp.decel.jerkTime <= 0 {
+				t.Error("Expected a jerk limited deceleration from", segment.EntrySpeed, "to", segment.ExitSpeed, "over", segment.Distance())
+			}
+		}
+	}
+	if decelerating == 0 {
+		t.Error("Expected segments slowing down for the corner")
+	}
+}
@@ -0,0 +1,463 @@
+package polargraph
+
+// HTTP server that accepts uploaded files and drawing jobs, and sends queued jobs to the stepper driver one at a time
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job states that are not reported by the job's PlotControl
+const (
+	JobQueued    = "queued"    // waiting for the jobs before it to finish
+	JobCancelled = "cancelled" // removed from the queue before it started
+	JobFailed    = "failed"    // the job could not be sent to the stepper driver
+)
+
+// Starts the generator for command line style arguments, such as svg 300 drawing.svg, provided by main where the commands are parsed.
+// Generators are run with start instead of their own go statement, so a panic fails the job instead of the server
+type JobBuilder func(args []string, start func(generate func())) (<-chan Coordinate, error)
+
+// The first panic of the goroutines generating a job
+type generatorFailure struct {
+	mutex sync.Mutex
+	err   error
+}
+
+// Run generate in its own goroutine, recording a panic instead of letting it end the server
+func (failure *generatorFailure) start(generate func()) {
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				failure.mutex.Lock()
+				if failure.err == nil {
+					failure.err = fmt.Errorf("%v", recovered)
+				}
+				failure.mutex.Unlock()
+			}
+		}()
+		generate()
+	}()
+}
+
+// The recorded panic, nil if there was none
+func (failure *generatorFailure) Err() error {
+	failure.mutex.Lock()
+	defer failure.mutex.Unlock()
+	return failure.err
+}
+
+// A drawing sent to the server
+type PlotJob struct {
+	Id         int
+	Args       []string
+	Status     string
+	Error      string `json:",omitempty"`
+	SlicesSent int64
+	Created    time.Time
+	Started    time.Time
+	Finished   time.Time
+
+	plotCoords <-chan Coordinate
+	failure    *generatorFailure
+	control    *PlotControl
+}
+
+// Serves the REST API and runs the job queue
+type PlotServer struct {
+	// Creates the coordinates for a job
+	Builder JobBuilder
+
+	// Help text for each command that can be used in a job's Args
+	Commands map[string]string
+
+	// Directory uploaded files are saved to
+	UploadDir string
+
+	// Save where each job leaves the pen to the settings file, see StepWriter.SavePosition
+	SavePosition bool
+
+	mutex    sync.Mutex
+	jobs     []*PlotJob
+	nextId   int
+	queue    chan *PlotJob
+	position PolarCoordinate // where the pen was left by the last job
+}
+
+// Request body used to preview or queue a job
+type jobRequest struct {
+	Args []string
+}
+
+// Create a server, jobs start at the pen position in settings
+func NewPlotServer(builder JobBuilder, commands map[string]string, uploadDir string) *PlotServer {
+	return &PlotServer{
+		Builder:   builder,
+		Commands:  commands,
+		UploadDir: uploadDir,
+		nextId:    1,
+		queue:     make(chan *PlotJob, 1024),
+		position:  PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM},
+	}
+}
+
+// Listen on address, such as localhost:8080, and run queued jobs until the listener fails
+func (server *PlotServer) ListenAndServe(address string) error {
+	if err := os.MkdirAll(server.UploadDir, 0755); err != nil {
+		return err
+	}
+
+	go server.runJobs()
+
+	return http.ListenAndServe(address, server.Handler())
+}
+
+// Handler for the REST API
+func (server *PlotServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/commands", server.handleCommands)
+	mux.HandleFunc("/files", server.handleFiles)
+	mux.HandleFunc("/preview", server.handlePreview)
+	mux.HandleFunc("/jobs", server.handleJobs)
+	mux.HandleFunc("/jobs/", server.handleJob)
+	return mux
+}
+
+// GET /commands, help text for each command
+func (server *PlotServer) handleCommands(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(response, "Expected GET", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(response, http.StatusOK, server.Commands)
+}
+
+// POST /files?name=drawing.svg with the file as the body or as the file field of a multipart form, returns the path to use in a job's Args
+func (server *PlotServer) handleFiles(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(response, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := request.URL.Query().Get("name")
+	var body io.Reader = request.Body
+	if strings.HasPrefix(request.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := request.FormFile("file")
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		body = file
+		if name == "" {
+			name = header.Filename
+		}
+	}
+
+	// only keep the base name so an upload can not be written outside of UploadDir
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		http.Error(response, "Expected a file name", http.StatusBadRequest)
+		return
+	}
+
+	path := filepath.Join(server.UploadDir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJson(response, http.StatusCreated, map[string]string{"Path": path})
+}
+
+// POST /preview with {"Args": [...]}, returns a png of the drawing
+func (server *PlotServer) handlePreview(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(response, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, plotCoords, failure, err := server.build(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response.Header().Set("Content-Type", "image/png")
+	if err := WriteImage(response, plotCoords); err != nil {
+		fmt.Println("Failed to write preview:", err)
+	} else if err := failure.Err(); err != nil {
+		fmt.Println("Preview generator failed:", err)
+	}
+}
+
+// GET /jobs lists every job, POST /jobs with {"Args": [...]} queues a new job
+func (server *PlotServer) handleJobs(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		server.mutex.Lock()
+		jobs := make([]PlotJob, len(server.jobs))
+		for index, job := range server.jobs {
+			jobs[index] = job.snapshot()
+		}
+		server.mutex.Unlock()
+
+		writeJson(response, http.StatusOK, jobs)
+
+	case "POST":
+		args, plotCoords, failure, err := server.build(request)
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		job := server.enqueue(args, plotCoords, failure)
+		if job == nil {
+			// nothing will read the generator now, let it finish
+			go func() {
+				for range plotCoords {
+				}
+			}()
+			http.Error(response, "The job queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		server.mutex.Lock()
+		snapshot := job.snapshot()
+		server.mutex.Unlock()
+
+		writeJson(response, http.StatusCreated, snapshot)
+
+	default:
+		http.Error(response, "Expected GET or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /jobs/ID returns the job, POST /jobs/ID/pause, park, resume or abort controls it, DELETE /jobs/ID cancels or aborts it
+func (server *PlotServer) handleJob(response http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/jobs/"), "/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		http.NotFound(response, request)
+		return
+	}
+	job := server.findJob(id)
+	if job == nil {
+		http.NotFound(response, request)
+		return
+	}
+
+	switch {
+	case request.Method == "GET" && len(parts) == 1:
+
+	case request.Method == "POST" && len(parts) == 2:
+		if parts[1] == "abort" {
+			server.abort(job)
+		} else if _, err := job.control.Command(parts[1]); err != nil {
+			http.Error(response, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	case request.Method == "DELETE" && len(parts) == 1:
+		server.abort(job)
+
+	default:
+		http.Error(response, "Expected GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	server.mutex.Lock()
+	snapshot := job.snapshot()
+	server.mutex.Unlock()
+
+	writeJson(response, http.StatusOK, snapshot)
+}
+
+// Decode the job request and start its generator, failure records a panic of the generator once it is running
+func (server *PlotServer) build(request *http.Request) (args []string, plotCoords <-chan Coordinate, failure *generatorFailure, err error) {
+	var jobRequest jobRequest
+	if err := json.NewDecoder(request.Body).Decode(&jobRequest); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(jobRequest.Args) == 0 {
+		return nil, nil, nil, errors.New("Expected Args to contain a command and its parameters")
+	}
+
+	// some generators panic on bad parameters, which should fail the request instead of the server
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			plotCoords = nil
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	failure = new(generatorFailure)
+	plotCoords, err = server.Builder(jobRequest.Args, failure.start)
+	return jobRequest.Args, plotCoords, failure, err
+}
+
+// Add a job to the queue, returns nil if the queue is full
+func (server *PlotServer) enqueue(args []string, plotCoords <-chan Coordinate, failure *generatorFailure) *PlotJob {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	job := &PlotJob{
+		Id:         server.nextId,
+		Args:       args,
+		Status:     JobQueued,
+		Created:    time.Now(),
+		plotCoords: plotCoords,
+		failure:    failure,
+		control:    NewPlotControl(),
+	}
+
+	// runJobs takes the mutex, so waiting here for room in the queue would never end
+	select {
+	case server.queue <- job:
+	default:
+		return nil
+	}
+	server.nextId++
+	server.jobs = append(server.jobs, job)
+	return job
+}
+
+// Find the job with the given id, nil if there is none
+func (server *PlotServer) findJob(id int) *PlotJob {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, job := range server.jobs {
+		if job.Id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Cancel a queued job or abort a running one
+func (server *PlotServer) abort(job *PlotJob) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if job.Status == JobQueued {
+		job.Status = JobCancelled
+		job.Finished = time.Now()
+		return
+	}
+	job.control.Abort()
+}
+
+// Send each queued job to the stepper driver
+func (server *PlotServer) runJobs() {
+	for job := range server.queue {
+		server.mutex.Lock()
+		cancelled := job.Status == JobCancelled
+		if !cancelled {
+			job.Status = ""
+			job.Started = time.Now()
+		}
+		server.mutex.Unlock()
+
+		if cancelled {
+			go drainCoordinates(job.plotCoords)
+			continue
+		}
+
+		err := server.runJob(job)
+
+		server.mutex.Lock()
+		job.Finished = time.Now()
+		if err != nil {
+			fmt.Println("Job", job.Id, "failed:", err)
+			job.Status = JobFailed
+			job.Error = err.Error()
+		}
+		server.position = job.control.Position()
+		server.mutex.Unlock()
+	}
+}
+
+// Send a single job, starting from where the previous job left the pen
+func (server *PlotServer) runJob(job *PlotJob) (err error) {
+	stepData := make(chan int8, 1024)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%v", recovered)
+			go drainSteps(stepData)
+		}
+	}()
+
+	server.mutex.Lock()
+	start := server.position
+	server.mutex.Unlock()
+
+	// connect first, so the steps are generated with the constants the stepper driver reports
+	conn, err := OpenStepperConnection()
+	if err != nil {
+		go drainCoordinates(job.plotCoords)
+		return err
+	}
+	defer conn.Close()
+
+	generator := NewStepGenerator()
+	generator.Start = start
+	writer := NewStepWriter(false)
+	writer.Start = start
+	writer.Control = job.control
+	writer.SavePosition = server.SavePosition
+
+	journal := NewJobJournal(JournalFile, job.Args, generator.Origin)
+	generator.Journal = journal
+	writer.Journal = journal
+
+	job.failure.start(func() { generator.Generate(job.plotCoords, stepData) })
+	writer.Write(conn, stepData)
+	return job.failure.Err()
+}
+
+// Let a generator finish instead of blocking on a full channel
+func drainCoordinates(plotCoords <-chan Coordinate) {
+	for range plotCoords {
+	}
+}
+
+// Let a step generator finish instead of blocking on a full channel
+func drainSteps(stepData <-chan int8) {
+	for range stepData {
+	}
+}
+
+// Copy of the job with its current status, caller must hold the server mutex
+func (job *PlotJob) snapshot() PlotJob {
+	snapshot := *job
+	if snapshot.Status == "" {
+		snapshot.Status = job.control.Status()
+	}
+	snapshot.SlicesSent = job.control.SlicesSent()
+	return snapshot
+}
+
+// Write value as a json response
+func writeJson(response http.ResponseWriter, status int, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	if err := json.NewEncoder(response).Encode(value); err != nil {
+		fmt.Println("Failed to write response:", err)
+	}
+}
@@ -0,0 +1,194 @@
+package polargraph
+
+// Tests for the HTTP server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Builds a small square for any args, except panic which starts a generator that panics partway through
+func testJobBuilder(args []string, start func(generate func())) (<-chan Coordinate, error) {
+	plotCoords := make(chan Coordinate, 10)
+	if args[0] == "panic" {
+		start(func() {
+			defer close(plotCoords)
+			plotCoords <- Coordinate{X: 10, Y: 0}
+			panic("SVG coordinates extend past drawable surface")
+		})
+		return plotCoords, nil
+	}
+
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	plotCoords <- Coordinate{X: 20, Y: 0}
+	plotCoords <- Coordinate{X: 20, Y: 20}
+	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+	close(plotCoords)
+	return plotCoords, nil
+}
+
+// Start a server sending jobs to a fast emulator
+func startTestServer(t *testing.T) (*httptest.Server, string) {
+	setupTestSettings()
+	Settings.Transport = EmulatorTransport
+	Settings.TransportAddress = "fast"
+
+	dir, err := ioutil.TempDir("", "gocupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	JournalFile = filepath.Join(dir, "journal.xml")
+
+	server := NewPlotServer(testJobBuilder, map[string]string{"square": "Draw a square"}, filepath.Join(dir, "uploads"))
+	os.MkdirAll(server.UploadDir, 0755)
+	go server.runJobs()
+
+	return httptest.NewServer(server.Handler()), dir
+}
+
+// Queued jobs should run to completion and report their progress
+func TestServerRunsJob(t *testing.T) {
+	httpServer, dir := startTestServer(t)
+	defer httpServer.Close()
+	defer os.RemoveAll(dir)
+
+	response, err := http.Post(httpServer.URL+"/jobs", "application/json", bytes.NewBufferString(`{"Args": ["square"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job PlotJob
+	json.NewDecoder(response.Body).Decode(&job)
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated || job.Id != 1 || len(job.Args) != 1 {
+		t.Fatal("Expected job 1 to be created and saw", response.Status, job)
+	}
+
+	if job = waitForJob(t, httpServer.URL, job.Id); job.Status != PlotDone {
+		t.Fatal("Expected job to finish and saw", job)
+	}
+	if job.SlicesSent == 0 {
+		t.Error("Expected job to report the slices sent")
+	}
+}
+
+// Poll a job until it is done or failed
+func waitForJob(t *testing.T, url string, id int) PlotJob {
+	var job PlotJob
+	for start := time.Now(); job.Status != PlotDone && job.Status != JobFailed; {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("Expected job to finish and saw", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		response, err := http.Get(fmt.Sprint(url, "/jobs/", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(response.Body).Decode(&job)
+		response.Body.Close()
+	}
+	return job
+}
+
+// A generator that panics should fail its job and leave the server running the jobs after it
+func TestServerGeneratorPanic(t *testing.T) {
+	httpServer, dir := startTestServer(t)
+	defer httpServer.Close()
+	defer os.RemoveAll(dir)
+
+	for _, args := range []string{`{"Args": ["panic"]}`, `{"Args": ["square"]}`} {
+		response, err := http.Post(httpServer.URL+"/jobs", "application/json", bytes.NewBufferString(args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+	}
+
+	if job := waitForJob(t, httpServer.URL, 1); job.Status != JobFailed || !strings.Contains(job.Error, "drawable surface") {
+		t.Error("Expected the panic to fail job 1 and saw", job)
+	}
+	if job := waitForJob(t, httpServer.URL, 2); job.Status != PlotDone {
+		t.Error("Expected job 2 to finish and saw", job)
+	}
+}
+
+// Previews are returned as png images
+func TestServerPreview(t *testing.T) {
+	httpServer, dir := startTestServer(t)
+	defer httpServer.Close()
+	defer os.RemoveAll(dir)
+
+	response, err := http.Post(httpServer.URL+"/preview", "application/json", bytes.NewBufferString(`{"Args": ["square"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if _, err := png.Decode(response.Body); err != nil {
+		t.Error("Expected a png preview", err)
+	}
+}
+
+// Uploads can not escape the upload directory
+func TestServerUpload(t *testing.T) {
+	httpServer, dir := startTestServer(t)
+	defer httpServer.Close()
+	defer os.RemoveAll(dir)
+
+	response, err := http.Post(httpServer.URL+"/files?name=../../drawing.svg", "image/svg+xml", bytes.NewBufferString("<svg></svg>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]string
+	json.NewDecoder(response.Body).Decode(&result)
+	response.Body.Close()
+
+	expected := filepath.Join(dir, "uploads", "drawing.svg")
+	if result["Path"] != expected {
+		t.Error("Expected upload to be saved to", expected, "and saw", result["Path"])
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Error("Expected uploaded file to exist", err)
+	}
+}
+
+// A full queue should refuse new jobs instead of blocking the server
+func TestServerQueueFull(t *testing.T) {
+	setupTestSettings()
+	server := NewPlotServer(testJobBuilder, map[string]string{"square": "Draw a square"}, os.TempDir())
+	server.queue = make(chan *PlotJob, 1)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	for _, expected := range []int{http.StatusCreated, http.StatusServiceUnavailable} {
+		response, err := http.Post(httpServer.URL+"/jobs", "application/json", bytes.NewBufferString(`{"Args": ["square"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Error("Expected", expected, "and saw", response.Status)
+		}
+	}
+
+	response, err := http.Get(httpServer.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jobs []PlotJob
+	json.NewDecoder(response.Body).Decode(&jobs)
+	response.Body.Close()
+	if len(jobs) != 1 {
+		t.Error("Expected only the queued job to be listed and saw", jobs)
+	}
+}
@@ -1,15 +1,22 @@
 package polargraph
 
 import (
+	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
-// These constants are also set in StepperDriver.ino, must be changed in both places
-const (
+// These values are also set in StepperDriver.ino, they are replaced by the values the stepper driver reports when a connection is opened.
+// Firmware that does not report its values is assumed to use these
+var (
 	// Time step used to control motion, ie the amount of time that the stepper motors will be going a constant speed
 	// decreasing this increases CPU usage and serial communication
 	// increasing it decreases rendering quality
@@ -22,6 +29,12 @@ const (
 	// Determined because 1 byte is sent per value, so have range -128 to 127, and -128, -127, 127 are reserved values with special meanings
 	StepsMaxValue float64 = 126.0
 
+	// Time the arduino waits after raising or lowering the pen before moving again
+	PenTransitionCooldown_US float64 = 1250000
+)
+
+// Command values, these can not change without changing the protocol version
+const (
 	// Special Steps value that when received causes the arduino to flush its buffers and reset its internal state
 	ResetCommand byte = 0x80 // -128
 
@@ -64,10 +77,82 @@ type SettingsData struct {
 	// Initial distance from head to right motor
 	StartingRightDist_MM float64
 
-	// path to mouse event file, use evtest to find
+	// path to mouse event file, use evtest to find, or the file to play back when MouseType is replay
 	MousePath string
 
-	// MM traveled by a single step
+	// How MousePath is read, relative for a mouse, absolute for a graphics tablet or touchscreen mapped onto the drawing surface, or replay for recorded input
+	MouseType string
+
+	// Type of connection to the stepper driver, one of serial, tcp, unix or pipe
+	Transport string
+
+	// Serial device, host:port, unix socket path or named pipe base path depending on Transport
+	TransportAddress string
+
+	// Baud rate used when Transport is serial
+	SerialBaud int
+
+	// Path of the unix socket used to pause, park, resume or abort a running job
+	ControlSocket string
+
+	// Number of coordinates looked ahead when planning speeds, more lets dense paths reach full speed
+	LookaheadSegments int
+
+	// Max acceleration of each string, 0 to use Acceleration_MM_S2
+	SpoolAcceleration_MM_S2 float64
+
+	// Velocity profile used for each move, trapezoid or scurve
+	Interpolater string
+
+	// Rate the acceleration changes at with the scurve interpolater, 0 to reach full acceleration in a tenth of a second
+	Jerk_MM_S3 float64
+
+	// Fastest the pen moves while drawing, 0 to use MaxSpeed_MM_S
+	DrawSpeed_MM_S float64
+
+	// Acceleration while drawing, 0 to use Acceleration_MM_S2
+	DrawAcceleration_MM_S2 float64
+
+	// Fastest the pen moves while raised, 0 to use MaxSpeed_MM_S
+	TravelSpeed_MM_S float64
+
+	// Acceleration while the pen is raised, 0 to use Acceleration_MM_S2
+	TravelAcceleration_MM_S2 float64
+
+	// Weight of the string in grams per metre, used with GondolaMass_G to compensate for the strings sagging, 0 to treat the strings as straight
+	StringWeight_G_M float64
+
+	// Mass of the gondola including the pen in grams, 0 to treat the strings as straight
+	GondolaMass_G float64
+
+	// Where the left string attaches to the gondola, measured from the same point on the gondola as PenOffsetX_MM, +y is down
+	GondolaLeftAnchorX_MM float64
+	GondolaLeftAnchorY_MM float64
+
+	// Where the right string attaches to the gondola
+	GondolaRightAnchorX_MM float64
+	GondolaRightAnchorY_MM float64
+
+	// Where the pen tip is on the gondola, all the gondola settings are 0 when both strings attach at the pen
+	PenOffsetX_MM float64
+	PenOffsetY_MM float64
+
+	// Thickness of the string, used with SpoolCoreDiameter_MM, SpoolLayerWidth_MM and StringLength_MM to model the spools filling up, 0 for a fixed SpoolCircumference_MM
+	StringThickness_MM float64
+
+	// Diameter of the empty spool that the string winds onto
+	SpoolCoreDiameter_MM float64
+
+	// Width of each layer of string wound onto the spool
+	SpoolLayerWidth_MM float64
+
+	// Length of each string from its spool to the pen when none of it is wound onto the spool
+	StringLength_MM float64
+
+	// Offsets that correct the distortion left after calibration, built by meshfit from a grid of measured marks, empty for no correction
+	CorrectionMesh string
+
+	// MM traveled by a single step, the smallest step when the spools are modelled filling up
 	StepSize_MM float64 `xml:"-"`
 
 	// Max speed of the plot head
@@ -75,33 +160,124 @@ type SettingsData struct {
 
 	// Acceleration in mm / s^2, derived from Acceleration_Seconds and MaxSpeed_MM_S
 	Acceleration_MM_S2 float64 `xml:"-"`
+
+	// Fastest a string can change length without a time slice needing more than StepsMaxValue, leaves a step of room for rounding
+	SpoolMaxSpeed_MM_S float64 `xml:"-"`
+
+	// Every speed and acceleration is divided by this, set by SlowDown, 0 is the same as 1
+	SlowFactor float64 `xml:"-"`
 }
 
 // Global settings variable
 var Settings SettingsData
 
 // name of the settings / config file
-var settingsFile string = "gocupi_config.xml"
+const settingsFileName = "gocupi_config.xml"
+
+// Environment variable holding the path of the settings file, used when SettingsPath is not set
+const SettingsEnvironment = "GOCUPI_CONFIG"
+
+// Path of the settings file given with -config, empty to search for it
+var SettingsPath string
+
+// Path of the settings file that was read, Write saves to the same file
+var settingsFile string = settingsFileName
+
+// Find the settings file, the first of SettingsPath, the GOCUPI_CONFIG environment variable, the working directory, then the user's config directory.
+// An explicitly given file must exist, if none is found the defaults are written to the user's config directory
+func findSettingsFile() (string, error) {
+	for _, explicit := range []string{SettingsPath, os.Getenv(SettingsEnvironment)} {
+		if explicit == "" {
+			continue
+		}
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("Unable to read %s: %v", explicit, err)
+		}
+		return explicit, nil
+	}
+
+	searchPaths := []string{settingsFileName}
+	configDir, configDirErr := os.UserConfigDir()
+	if configDirErr == nil {
+		searchPaths = append(searchPaths, filepath.Join(configDir, "gocupi", settingsFileName))
+	}
+	for _, path := range searchPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	// start a new file with the defaults for the user to edit
+	path := searchPaths[len(searchPaths)-1]
+	if configDirErr == nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return "", fmt.Errorf("Unable to create %s: %v", filepath.Dir(path), err)
+		}
+	}
+	file := &settingsFileData{DefaultProfile: defaultProfileName, Profiles: []settingsProfile{defaultSettings().marshalProfile(defaultProfileName)}}
+	if err := file.write(path); err != nil {
+		return "", fmt.Errorf("Unable to create %s: %v", path, err)
+	}
+	fmt.Println("No", settingsFileName, "found, created", path, "with default settings, edit it to match your polargraph")
+	return path, nil
+}
+
+// Settings written to a new settings file, the same as the gocupi_config.xml in the repository
+func defaultSettings() *SettingsData {
+	return &SettingsData{
+		SpoolHorizontalDistance_MM: 1000,
+		DrawingSurfaceMinY_MM:      50,
+		DrawingSurfaceMaxY_MM:      2000,
+		DrawingSurfaceMinX_MM:      25,
+		StartingLeftDist_MM:        84.9665109615478,
+		StartingRightDist_MM:       940.1724555578828,
+		SpoolCircumference_MM:      60.47565816,
+		SpoolSingleStep_Degrees:    0.225,
+		Acceleration_Seconds:       0.5,
+		MousePath:                  "/dev/input/event2",
+		MouseType:                  "relative",
+		Transport:                  SerialTransport,
+		TransportAddress:           "/dev/ttyAMA0",
+		SerialBaud:                 57600,
+		ControlSocket:              "/tmp/gocupi_control.sock",
+		LookaheadSegments:          DefaultLookaheadSegments,
+		Interpolater:               TrapezoidInterpolation,
+	}
+}
 
 // Read settings from file, setting the global variable
-func (settings *SettingsData) Read() {
+func (settings *SettingsData) Read() error {
 
-	fileData, err := ioutil.ReadFile(settingsFile)
+	path, err := findSettingsFile()
 	if err != nil {
-		// attempt to copy the default settings file from the gopath directory to the current working directory
-		repoSettingsFile := filepath.Join(os.Getenv("GOPATH"), "src/github.com/brandonagr/gocupi", settingsFile)
-		if copyErr := copyFile(repoSettingsFile, settingsFile); copyErr != nil {
-			panic(copyErr)
-		}
+		return err
+	}
+	settingsFile = path
 
-		fileData, err = ioutil.ReadFile(settingsFile)
-		if err != nil {
-			panic(err)
+	fileData, err := ioutil.ReadFile(settingsFile)
+	if err != nil {
+		return err
+	}
+	file, migrated, err := parseSettingsFile(fileData)
+	if err != nil {
+		return wrapParseError(err, settingsFile, "")
+	}
+	if migrated {
+		if err := migrateSettingsFile(fileData); err != nil {
+			return err
 		}
 	}
-	if err := xml.Unmarshal(fileData, settings); err != nil {
-		panic(err)
+	profile, err := file.profile(SettingsProfile)
+	if err != nil {
+		return wrapParseError(err, settingsFile, "")
+	}
+	if err := settings.unmarshalProfile(profile); err != nil {
+		if len(file.Profiles) > 1 {
+			return wrapParseError(err, settingsFile, "profile "+profile.Name)
+		}
+		return wrapParseError(err, settingsFile, "")
 	}
+	loadedProfile = profile.Name
 
 	// setup default values
 	if settings.SpoolCircumference_MM == 0 {
@@ -110,19 +286,444 @@ func (settings *SettingsData) Read() {
 	if settings.Acceleration_Seconds == 0 {
 		settings.Acceleration_Seconds = 1
 	}
+	if settings.Transport == "" {
+		settings.Transport = SerialTransport
+	}
+	if settings.TransportAddress == "" {
+		settings.TransportAddress = "/dev/ttyAMA0"
+	}
+	if settings.SerialBaud == 0 {
+		settings.SerialBaud = 57600
+	}
+	if settings.ControlSocket == "" {
+		settings.ControlSocket = "/tmp/gocupi_control.sock"
+	}
+	if settings.LookaheadSegments == 0 {
+		settings.LookaheadSegments = DefaultLookaheadSegments
+	}
+	if settings.Interpolater == "" {
+		settings.Interpolater = TrapezoidInterpolation
+	}
+	if _, err := NewPositionInterpolater(settings.Interpolater); err != nil {
+		return &ParseError{File: settingsFile, Location: "field Interpolater", Err: err}
+	}
 
 	settings.CalculateDerivedFields()
+	return nil
+}
+
+// A single element of the settings file, such as <SerialBaud>57600</SerialBaud>
+type settingsElement struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// Parse the selected profile of a settings file
+func (settings *SettingsData) unmarshal(fileData []byte) error {
+	file, _, err := parseSettingsFile(fileData)
+	if err != nil {
+		return err
+	}
+	profile, err := file.profile(SettingsProfile)
+	if err != nil {
+		return err
+	}
+	return settings.unmarshalProfile(profile)
+}
+
+// Set the profile's settings one field at a time, so an error can say which field could not be parsed
+func (settings *SettingsData) unmarshalProfile(profile *settingsProfile) error {
+	for _, element := range profile.Elements {
+		if err := settings.SetField(element.XMLName.Local, element.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The settings as the elements of a profile
+func (settings *SettingsData) marshalProfile(name string) settingsProfile {
+	fileData, err := xml.Marshal(settings)
+	if err != nil {
+		panic(err)
+	}
+	profile := settingsProfile{Name: name}
+	if err := xml.Unmarshal(fileData, &profile); err != nil {
+		panic(err)
+	}
+	profile.Name = name
+	return profile
+}
+
+// Version of the settings file layout, a GocupiConfig holding a Profile for each plotter.
+// Files without a version are version 1, a single SettingsData
+const SettingsSchemaVersion = 2
+
+// Name of the profile to read from the settings file, empty for the file's default profile
+var SettingsProfile string
+
+// Name of the profile that was read, which Write replaces
+var loadedProfile string
+
+// Name given to the profile made from a version 1 settings file
+const defaultProfileName = "default"
+
+// The settings file
+type settingsFileData struct {
+	XMLName        xml.Name          `xml:"GocupiConfig"`
+	SchemaVersion  int               `xml:"SchemaVersion,attr"`
+	DefaultProfile string            `xml:"DefaultProfile,attr,omitempty"`
+	Profiles       []settingsProfile `xml:"Profile"`
+}
+
+// The settings of one plotter
+type settingsProfile struct {
+	Name     string            `xml:"Name,attr"`
+	Elements []settingsElement `xml:",any"`
+}
+
+// Parse a settings file of any version, migrated is true if it was converted from an older version
+func parseSettingsFile(fileData []byte) (file *settingsFileData, migrated bool, err error) {
+	var root struct {
+		XMLName       xml.Name
+		SchemaVersion int `xml:"SchemaVersion,attr"`
+	}
+	if err := xml.Unmarshal(fileData, &root); err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case root.XMLName.Local == "SettingsData":
+		// version 1 holds the settings of a single plotter
+		profile := settingsProfile{Name: defaultProfileName}
+		if err := xml.Unmarshal(fileData, &profile); err != nil {
+			return nil, false, err
+		}
+		profile.Name = defaultProfileName
+		return &settingsFileData{SchemaVersion: SettingsSchemaVersion, DefaultProfile: defaultProfileName, Profiles: []settingsProfile{profile}}, true, nil
+
+	case root.XMLName.Local != "GocupiConfig":
+		return nil, false, parseErrorf("", "Expected GocupiConfig or SettingsData but saw %v", root.XMLName.Local)
+
+	case root.SchemaVersion > SettingsSchemaVersion:
+		return nil, false, parseErrorf("", "Schema version %v is newer than this gocupi supports, which is up to %v", root.SchemaVersion, SettingsSchemaVersion)
+	}
+
+	file = new(settingsFileData)
+	if err := xml.Unmarshal(fileData, file); err != nil {
+		return nil, false, err
+	}
+	if len(file.Profiles) == 0 {
+		return nil, false, parseErrorf("", "No profiles")
+	}
+	return file, false, nil
+}
+
+// The profile with the given name, or the default profile when name is empty
+func (file *settingsFileData) profile(name string) (*settingsProfile, error) {
+	if name == "" {
+		name = file.DefaultProfile
+	}
+	var names []string
+	for index := range file.Profiles {
+		if file.Profiles[index].Name == name || name == "" {
+			return &file.Profiles[index], nil
+		}
+		names = append(names, file.Profiles[index].Name)
+	}
+	return nil, parseErrorf("", "Unknown profile %v, expected one of %v", name, strings.Join(names, ", "))
+}
+
+// Replace the profile with the same name, or add it
+func (file *settingsFileData) setProfile(profile settingsProfile) {
+	for index := range file.Profiles {
+		if file.Profiles[index].Name == profile.Name {
+			file.Profiles[index] = profile
+			return
+		}
+	}
+	file.Profiles = append(file.Profiles, profile)
+}
+
+// Write the file to path, replacing it in one step so an interruption never leaves half a file
+func (file *settingsFileData) write(path string) error {
+	file.SchemaVersion = SettingsSchemaVersion
+	fileData, err := xml.MarshalIndent(file, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, fileData)
+}
+
+// Rewrite a version 1 settings file with its settings in a default profile, keeping a copy of the original
+func migrateSettingsFile(fileData []byte) error {
+	backupFile := settingsFile + ".v1"
+	if err := copyFile(settingsFile, backupFile); err != nil {
+		return fmt.Errorf("Unable to back up %s before migrating it: %v", settingsFile, err)
+	}
+	migrated, err := migrateSettingsData(fileData)
+	if err == nil {
+		err = writeFileAtomic(settingsFile, migrated)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to migrate %s: %v", settingsFile, err)
+	}
+	fmt.Println("Migrated", settingsFile, "to schema version", SettingsSchemaVersion, "with a profile named", defaultProfileName+", the original is saved as", backupFile)
+	return nil
+}
+
+// Replace the SettingsData root of a version 1 file with a GocupiConfig holding a default profile.
+// Its contents are indented and otherwise copied byte for byte, so comments and formatting are kept
+func migrateSettingsData(fileData []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(fileData))
+	depth := 0
+	rootStart, contentStart := -1, 0
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				rootStart, contentStart = offset, int(decoder.InputOffset())
+			}
+
+		case xml.EndElement:
+			depth--
+			if depth > 0 {
+				continue
+			}
+
+			var result bytes.Buffer
+			result.Write(fileData[:rootStart])
+			fmt.Fprintf(&result, "<GocupiConfig SchemaVersion=\"%d\" DefaultProfile=\"%s\">\n\t<Profile Name=\"%s\">", SettingsSchemaVersion, defaultProfileName, defaultProfileName)
+			if offset > contentStart {
+				result.Write(bytes.Replace(fileData[contentStart:offset], []byte("\n"), []byte("\n\t"), -1))
+			}
+			result.WriteString("</Profile>\n</GocupiConfig>")
+			result.Write(fileData[decoder.InputOffset():])
+			return result.Bytes(), nil
+		}
+	}
+}
+
+// Set the field with the given name from its text value, returns an error located at the field if there is no such setting or the value can not be parsed
+func (settings *SettingsData) SetField(name, value string) error {
+	location := "field " + name
+
+	field, ok := reflect.TypeOf(*settings).FieldByName(name)
+	if !ok || field.Tag.Get("xml") == "-" {
+		return parseErrorf(location, "Unknown setting")
+	}
+
+	fieldValue := reflect.ValueOf(settings).Elem().FieldByIndex(field.Index)
+	value = strings.TrimSpace(value)
+	switch fieldValue.Kind() {
+	case reflect.String:
+		fieldValue.SetString(value)
+
+	case reflect.Float64:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return parseErrorf(location, "Expected a number but saw %q", value)
+		}
+		fieldValue.SetFloat(number)
+
+	case reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return parseErrorf(location, "Expected a whole number but saw %q", value)
+		}
+		fieldValue.SetInt(int64(number))
+
+	case reflect.Bool:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return parseErrorf(location, "Expected true or false but saw %q", value)
+		}
+		fieldValue.SetBool(enabled)
+
+	default:
+		return parseErrorf(location, "Unsupported setting type %v", fieldValue.Kind())
+	}
+	return nil
+}
+
+// True if the spools are modeled filling up with string, instead of having a fixed SpoolCircumference_MM
+func (settings *SettingsData) LayeredSpools() bool {
+	return settings.StringThickness_MM > 0 && settings.SpoolCoreDiameter_MM > 0 && settings.SpoolLayerWidth_MM > 0 && settings.StringLength_MM > 0
 }
 
 // setup derived fields
 func (settings *SettingsData) CalculateDerivedFields() {
 	settings.DrawingSurfaceMaxX_MM = settings.SpoolHorizontalDistance_MM - settings.DrawingSurfaceMinX_MM
-	settings.StepSize_MM = (settings.SpoolSingleStep_Degrees / 360.0) * settings.SpoolCircumference_MM
+	circumference := settings.SpoolCircumference_MM
+	if settings.LayeredSpools() {
+		// the string moves least per step when it winds directly onto the core
+		circumference = math.Pi * (settings.SpoolCoreDiameter_MM + settings.StringThickness_MM)
+	}
+	settings.StepSize_MM = (settings.SpoolSingleStep_Degrees / 360.0) * circumference
 
 	stepsPerRevolution := 360.0 / settings.SpoolSingleStep_Degrees
 	stepsPerValue := StepsMaxValue / StepsFixedPointFactor
-	settings.MaxSpeed_MM_S = ((stepsPerValue / (TimeSlice_US / 1000000.0)) / stepsPerRevolution) * settings.SpoolCircumference_MM
+	settings.MaxSpeed_MM_S = ((stepsPerValue / (TimeSlice_US / 1000000.0)) / stepsPerRevolution) * circumference
+	settings.MaxSpeed_MM_S /= math.Max(settings.SlowFactor, 1)
 	settings.Acceleration_MM_S2 = settings.MaxSpeed_MM_S / settings.Acceleration_Seconds
+	settings.SpoolMaxSpeed_MM_S = ((StepsMaxValue - 1) / StepsFixedPointFactor) / (TimeSlice_US / 1000000.0) * settings.StepSize_MM
+}
+
+// Max speed and acceleration of a move, the travel settings when the pen is up and the draw settings when it is down.
+// Values that are not set use MaxSpeed_MM_S and Acceleration_MM_S2, and the speed is never more than MaxSpeed_MM_S
+func (settings *SettingsData) MoveProfile(penUp bool) (maxSpeed, acceleration float64) {
+	maxSpeed, acceleration = settings.DrawSpeed_MM_S, settings.DrawAcceleration_MM_S2
+	if penUp {
+		maxSpeed, acceleration = settings.TravelSpeed_MM_S, settings.TravelAcceleration_MM_S2
+	}
+	if maxSpeed <= 0 || maxSpeed > settings.MaxSpeed_MM_S {
+		maxSpeed = settings.MaxSpeed_MM_S
+	}
+	if acceleration <= 0 {
+		acceleration = settings.Acceleration_MM_S2
+	}
+	return
+}
+
+// Divide every speed and acceleration by factor, so the whole job runs slower
+func (settings *SettingsData) SlowDown(factor float64) {
+	settings.SlowFactor = math.Max(settings.SlowFactor, 1) * factor
+	settings.DrawSpeed_MM_S /= factor
+	settings.DrawAcceleration_MM_S2 /= factor
+	settings.TravelSpeed_MM_S /= factor
+	settings.TravelAcceleration_MM_S2 /= factor
+	settings.CalculateDerivedFields()
+}
+
+// Settings given on the command line as Field=value, applied over the settings file
+type SettingOverrides []string
+
+// Comma separated overrides
+func (overrides *SettingOverrides) String() string {
+	return strings.Join(*overrides, ",")
+}
+
+// Add an override, checking it has the form Field=value
+func (overrides *SettingOverrides) Set(value string) error {
+	if name := strings.SplitN(value, "=", 2)[0]; name == value || name == "" {
+		return fmt.Errorf("Expected Field=value but saw %q", value)
+	}
+	*overrides = append(*overrides, value)
+	return nil
+}
+
+// Remove every override
+func (overrides *SettingOverrides) Reset() {
+	*overrides = nil
+}
+
+// Set each overridden field in settings, then recalculate the derived fields
+func (overrides SettingOverrides) Apply(settings *SettingsData) error {
+	for _, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("-set %v: Expected Field=value", override)
+		}
+		if err := settings.SetField(strings.TrimSpace(parts[0]), parts[1]); err != nil {
+			return fmt.Errorf("-set %v: %v", override, err)
+		}
+	}
+	settings.CalculateDerivedFields()
+	return nil
+}
+
+// Check the settings describe a polargraph that can exist, so a mistake is reported before anything moves
+func (settings *SettingsData) Validate() error {
+	positive := []struct {
+		name  string
+		value float64
+	}{
+		{"SpoolHorizontalDistance_MM", settings.SpoolHorizontalDistance_MM},
+		{"SpoolCircumference_MM", settings.SpoolCircumference_MM},
+		{"SpoolSingleStep_Degrees", settings.SpoolSingleStep_Degrees},
+		{"Acceleration_Seconds", settings.Acceleration_Seconds},
+		{"StartingLeftDist_MM", settings.StartingLeftDist_MM},
+		{"StartingRightDist_MM", settings.StartingRightDist_MM},
+	}
+	for _, field := range positive {
+		if !(field.value > 0) || math.IsInf(field.value, 0) {
+			return parseErrorf("field "+field.name, "Must be greater than 0 but is %v", field.value)
+		}
+	}
+
+	notNegative := []struct {
+		name  string
+		value float64
+	}{
+		{"DrawingSurfaceMinX_MM", settings.DrawingSurfaceMinX_MM},
+		{"DrawingSurfaceMinY_MM", settings.DrawingSurfaceMinY_MM},
+		{"SpoolAcceleration_MM_S2", settings.SpoolAcceleration_MM_S2},
+		{"Jerk_MM_S3", settings.Jerk_MM_S3},
+		{"DrawSpeed_MM_S", settings.DrawSpeed_MM_S},
+		{"DrawAcceleration_MM_S2", settings.DrawAcceleration_MM_S2},
+		{"TravelSpeed_MM_S", settings.TravelSpeed_MM_S},
+		{"TravelAcceleration_MM_S2", settings.TravelAcceleration_MM_S2},
+		{"StringWeight_G_M", settings.StringWeight_G_M},
+		{"GondolaMass_G", settings.GondolaMass_G},
+		{"StringThickness_MM", settings.StringThickness_MM},
+		{"SpoolCoreDiameter_MM", settings.SpoolCoreDiameter_MM},
+		{"SpoolLayerWidth_MM", settings.SpoolLayerWidth_MM},
+		{"StringLength_MM", settings.StringLength_MM},
+	}
+	for _, field := range notNegative {
+		if !(field.value >= 0) {
+			return parseErrorf("field "+field.name, "Must not be negative but is %v", field.value)
+		}
+	}
+
+	if 2*settings.DrawingSurfaceMinX_MM >= settings.SpoolHorizontalDistance_MM {
+		return parseErrorf("field DrawingSurfaceMinX_MM", "Leaves no drawing surface, %v from each side of spools %v apart", settings.DrawingSurfaceMinX_MM, settings.SpoolHorizontalDistance_MM)
+	}
+	if settings.DrawingSurfaceMaxY_MM <= settings.DrawingSurfaceMinY_MM {
+		return parseErrorf("field DrawingSurfaceMaxY_MM", "Must be greater than DrawingSurfaceMinY_MM of %v but is %v", settings.DrawingSurfaceMinY_MM, settings.DrawingSurfaceMaxY_MM)
+	}
+
+	spools := settings.SpoolHorizontalDistance_MM
+	leftAnchor := Coordinate{X: settings.GondolaLeftAnchorX_MM, Y: settings.GondolaLeftAnchorY_MM}
+	rightAnchor := Coordinate{X: settings.GondolaRightAnchorX_MM, Y: settings.GondolaRightAnchorY_MM}
+	anchors := rightAnchor.Minus(leftAnchor).Len()
+	if anchors >= spools {
+		return parseErrorf("field GondolaRightAnchorX_MM", "The string anchors are %v apart, which does not fit between spools %v apart", anchors, spools)
+	}
+
+	// the strings, the spools and the string anchors on the gondola have to join up
+	left, right := settings.StartingLeftDist_MM, settings.StartingRightDist_MM
+	if left+right+anchors <= spools || math.Abs(left-right) >= spools+anchors {
+		return parseErrorf("field StartingLeftDist_MM", "Strings of %v and %v can not reach the gondola from spools %v apart", left, right, spools)
+	}
+
+	spoolFields := 0
+	for _, value := range []float64{settings.StringThickness_MM, settings.SpoolCoreDiameter_MM, settings.SpoolLayerWidth_MM, settings.StringLength_MM} {
+		if value > 0 {
+			spoolFields++
+		}
+	}
+	if spoolFields != 0 && spoolFields != 4 {
+		return parseErrorf("field StringThickness_MM", "StringThickness_MM, SpoolCoreDiameter_MM, SpoolLayerWidth_MM and StringLength_MM must all be set to model the string winding onto the spools, or all be 0")
+	}
+
+	if strings.TrimSpace(settings.CorrectionMesh) != "" {
+		if _, err := ParseCorrectionMesh(settings.CorrectionMesh); err != nil {
+			return &ParseError{Location: "field CorrectionMesh", Err: err}
+		}
+	}
+
+	if _, err := NewPositionInterpolater(settings.Interpolater); err != nil {
+		return &ParseError{Location: "field Interpolater", Err: err}
+	}
+	return nil
 }
 
 // from https://gist.github.com/elazarl/5507969
@@ -145,13 +746,158 @@ func copyFile(src, dst string) error {
 	return d.Close()
 }
 
-// Write settings to file
-func (settings *SettingsData) Write() {
-	fileData, err := xml.MarshalIndent(settings, "", "\t")
+// Write the string lengths at the pen's position to the profile that was read as its starting position, changing nothing else in the file
+// so settings overridden for a job are not saved and comments are kept
+func SavePosition(position PolarCoordinate) error {
+	return saveElements(
+		settingsElement{XMLName: xml.Name{Local: "StartingLeftDist_MM"}, Value: strconv.FormatFloat(position.LeftDist, 'g', -1, 64)},
+		settingsElement{XMLName: xml.Name{Local: "StartingRightDist_MM"}, Value: strconv.FormatFloat(position.RightDist, 'g', -1, 64)},
+	)
+}
+
+// Replace the given elements of the profile that was read, leaving the rest of the file as it is
+func saveElements(elements ...settingsElement) error {
+	fileData, err := ioutil.ReadFile(settingsFile)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	file, _, err := parseSettingsFile(fileData)
+	if err != nil {
+		return wrapParseError(err, settingsFile, "")
+	}
+	name := loadedProfile
+	if name == "" {
+		name = SettingsProfile
+	}
+	profile, err := file.profile(name)
+	if err != nil {
+		return wrapParseError(err, settingsFile, "")
+	}
+	profileIndex := 0
+	for &file.Profiles[profileIndex] != profile {
+		profileIndex++
+	}
+
+	if fileData, err = replaceProfileElements(fileData, profileIndex, elements); err != nil {
+		return wrapParseError(err, settingsFile, "")
+	}
+	return writeFileAtomic(settingsFile, fileData)
+}
+
+// Replace the text of the given elements in the profile at profileIndex, adding any it does not have before its end tag.
+// Everything else is copied byte for byte, so comments and formatting are kept. The root of a version 1 file is its only profile
+func replaceProfileElements(fileData []byte, profileIndex int, elements []settingsElement) ([]byte, error) {
+	values := make(map[string]string)
+	for _, element := range elements {
+		values[element.XMLName.Local] = element.Value
+	}
+	found := make(map[string]bool)
+	encode := func(name string) string {
+		var text bytes.Buffer
+		xml.EscapeText(&text, []byte(values[name]))
+		return text.String()
+	}
+
+	var result bytes.Buffer
+	copied := 0
+	replace := func(start, end int, text string) {
+		result.Write(fileData[copied:start])
+		result.WriteString(text)
+		copied = end
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(fileData))
+	depth, profiles := 0, 0
+	childDepth := 0 // depth of the selected profile's settings while inside it
+	name, tagStart, valueStart := "", 0, 0
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && token.Name.Local == "SettingsData":
+				childDepth = 2
+			case depth == 2 && token.Name.Local == "Profile":
+				if profiles == profileIndex {
+					childDepth = 3
+				}
+				profiles++
+			case depth == childDepth:
+				if _, ok := values[token.Name.Local]; ok {
+					name, tagStart, valueStart = token.Name.Local, offset, int(decoder.InputOffset())
+				}
+			}
+
+		case xml.EndElement:
+			switch {
+			case depth == childDepth && name != "":
+				if valueStart == offset && bytes.HasSuffix(fileData[:valueStart], []byte("/>")) {
+					replace(tagStart, valueStart, "<"+name+">"+encode(name)+"</"+name+">")
+				} else {
+					replace(valueStart, offset, encode(name))
+				}
+				found[name] = true
+				name = ""
+
+			case depth == childDepth-1:
+				// indent added elements like the end tag when it is on its own line
+				lineStart := bytes.LastIndexByte(fileData[:offset], '\n') + 1
+				indent := fileData[lineStart:offset]
+				var added bytes.Buffer
+				for _, element := range elements {
+					if missing := element.XMLName.Local; !found[missing] {
+						found[missing] = true
+						if len(bytes.TrimSpace(indent)) == 0 {
+							fmt.Fprintf(&added, "%s\t<%s>%s</%s>\n", indent, missing, encode(missing), missing)
+						} else {
+							fmt.Fprintf(&added, "<%s>%s</%s>", missing, encode(missing), missing)
+						}
+					}
+				}
+				if len(bytes.TrimSpace(indent)) == 0 {
+					replace(lineStart, lineStart, added.String())
+				} else {
+					replace(offset, offset, added.String())
+				}
+				childDepth = -1
+			}
+			depth--
+		}
+	}
+	if childDepth != -1 {
+		return nil, parseErrorf("", "Profile %v not found", profileIndex)
+	}
+
+	result.Write(fileData[copied:])
+	return result.Bytes(), nil
+}
+
+// Write settings to the profile they were read from, keeping the other profiles in the file
+func (settings *SettingsData) Write() {
+	file := &settingsFileData{DefaultProfile: defaultProfileName}
+	if fileData, err := ioutil.ReadFile(settingsFile); err == nil {
+		if existing, _, err := parseSettingsFile(fileData); err == nil {
+			file = existing
+		}
+	}
+
+	name := loadedProfile
+	if name == "" {
+		name = SettingsProfile
+	}
+	if name == "" {
+		name = defaultProfileName
 	}
-	if err := ioutil.WriteFile(settingsFile, fileData, 0777); err != nil {
+	file.setProfile(settings.marshalProfile(name))
+	if err := file.write(settingsFile); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,338 @@
+package polargraph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Point SettingsPath at a file in a temporary directory holding contents, call restore to put the globals back
+func useSettingsFile(t *testing.T, contents string) (path string, restore func()) {
+	dir, err := ioutil.TempDir("", "gocupi_settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "gocupi_config.xml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	previousPath, previousFile, previousProfile, previousLoaded, previousSettings := SettingsPath, settingsFile, SettingsProfile, loadedProfile, Settings
+	SettingsPath = path
+	return path, func() {
+		SettingsPath, settingsFile, SettingsProfile, loadedProfile, Settings = previousPath, previousFile, previousProfile, previousLoaded, previousSettings
+		os.RemoveAll(dir)
+	}
+}
+
+// A flat version 1 file is read as the default profile, rewritten with profiles, and the original kept
+func TestSettingsMigration(t *testing.T) {
+	path, restore := useSettingsFile(t, "<SettingsData><SpoolHorizontalDistance_MM>900</SpoolHorizontalDistance_MM><SerialBaud>9600</SerialBaud></SettingsData>")
+	defer restore()
+
+	var settings SettingsData
+	if err := settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if settings.SpoolHorizontalDistance_MM != 900 || settings.SerialBaud != 9600 {
+		t.Error("Expected the flat settings, got", settings.SpoolHorizontalDistance_MM, settings.SerialBaud)
+	}
+
+	migrated, _ := ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(migrated), `<GocupiConfig SchemaVersion="2" DefaultProfile="default">`) || !strings.Contains(string(migrated), `<Profile Name="default">`) {
+		t.Error("Expected the file to be migrated to profiles, got", string(migrated))
+	}
+	if original, err := ioutil.ReadFile(path + ".v1"); err != nil || !strings.HasPrefix(string(original), "<SettingsData>") {
+		t.Error("Expected the original to be kept, got", string(original), err)
+	}
+
+	var again SettingsData
+	if err := again.Read(); err != nil || again.SpoolHorizontalDistance_MM != 900 {
+		t.Error("Expected the migrated file to read the same, got", again.SpoolHorizontalDistance_MM, err)
+	}
+}
+
+// Profiles are selected by name, and writing one leaves the others alone
+func TestSettingsProfiles(t *testing.T) {
+	path, restore := useSettingsFile(t, `<GocupiConfig SchemaVersion="2" DefaultProfile="big">
+	<Profile Name="big"><SpoolHorizontalDistance_MM>2000</SpoolHorizontalDistance_MM></Profile>
+	<Profile Name="small"><SpoolHorizontalDistance_MM>600</SpoolHorizontalDistance_MM><Transport>tcp</Transport></Profile>
+</GocupiConfig>`)
+	defer restore()
+
+	if err := Settings.Read(); err != nil || Settings.SpoolHorizontalDistance_MM != 2000 {
+		t.Error("Expected the default profile, got", Settings.SpoolHorizontalDistance_MM, err)
+	}
+
+	SettingsProfile = "small"
+	Settings = SettingsData{}
+	if err := Settings.Read(); err != nil || Settings.SpoolHorizontalDistance_MM != 600 || Settings.Transport != "tcp" {
+		t.Error("Expected the small profile, got", Settings.SpoolHorizontalDistance_MM, Settings.Transport, err)
+	}
+
+	Settings.StartingLeftDist_MM = 321
+	Settings.Write()
+
+	SettingsProfile = "big"
+	Settings = SettingsData{}
+	if err := Settings.Read(); err != nil || Settings.SpoolHorizontalDistance_MM != 2000 || Settings.StartingLeftDist_MM != 0 {
+		t.Error("Expected the big profile to be unchanged, got", Settings.SpoolHorizontalDistance_MM, Settings.StartingLeftDist_MM, err)
+	}
+	SettingsProfile = "small"
+	Settings = SettingsData{}
+	if err := Settings.Read(); err != nil || Settings.StartingLeftDist_MM != 321 {
+		t.Error("Expected the written position, got", Settings.StartingLeftDist_MM, err)
+	}
+
+	written, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(written), `DefaultProfile="big"`) {
+		t.Error("Expected the default profile to be kept, got", string(written))
+	}
+}
+
+// Unknown profiles, newer schemas and bad values in a profile are errors that say where
+func TestSettingsProfileErrors(t *testing.T) {
+	_, restore := useSettingsFile(t, `<GocupiConfig SchemaVersion="2">
+	<Profile Name="a"></Profile>
+	<Profile Name="b"><SerialBaud>fast</SerialBaud></Profile>
+</GocupiConfig>`)
+	defer restore()
+
+	SettingsProfile = "c"
+	if err := Settings.Read(); err == nil || !strings.HasSuffix(err.Error(), "Unknown profile c, expected one of a, b") {
+		t.Error("Expected an unknown profile error, got", err)
+	}
+	SettingsProfile = "b"
+	if err := Settings.Read(); err == nil || !strings.Contains(err.Error(), "profile b field SerialBaud:") {
+		t.Error("Expected an error in profile b, got", err)
+	}
+
+	if _, _, err := parseSettingsFile([]byte(`<GocupiConfig SchemaVersion="3"><Profile Name="a"></Profile></GocupiConfig>`)); err == nil {
+		t.Error("Expected an error for a newer schema version")
+	}
+}
+
+// Set an environment variable, call restore to put it back
+func setEnv(name, value string) (restore func()) {
+	previous, had := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if had {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// The settings file is looked for with -config, then the environment, the working directory and the user config directory, where a default one is created
+func TestSettingsSearchOrder(t *testing.T) {
+	_, restore := useSettingsFile(t, "")
+	defer restore()
+	SettingsPath = ""
+
+	dir, err := ioutil.TempDir("", "gocupi_search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workingDir, _ := os.Getwd()
+	defer os.Chdir(workingDir)
+	os.Chdir(dir)
+	defer setEnv("HOME", dir)()
+	defer setEnv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))()
+	defer setEnv(SettingsEnvironment, "")()
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(configDir, "gocupi", "gocupi_config.xml")
+	var settings SettingsData
+	if err := settings.Read(); err != nil || settingsFile != created || settings.SpoolHorizontalDistance_MM != 1000 {
+		t.Error("Expected default settings created at", created, "got", settingsFile, settings.SpoolHorizontalDistance_MM, err)
+	}
+	if err := settings.Validate(); err != nil {
+		t.Error("Expected the default settings to be valid, got", err)
+	}
+
+	ioutil.WriteFile("gocupi_config.xml", []byte("<SettingsData><SpoolHorizontalDistance_MM>900</SpoolHorizontalDistance_MM></SettingsData>"), 0666)
+	if err := settings.Read(); err != nil || settings.SpoolHorizontalDistance_MM != 900 {
+		t.Error("Expected the working directory file, got", settingsFile, settings.SpoolHorizontalDistance_MM, err)
+	}
+
+	environmentFile := filepath.Join(dir, "environment.xml")
+	ioutil.WriteFile(environmentFile, []byte("<SettingsData><SpoolHorizontalDistance_MM>800</SpoolHorizontalDistance_MM></SettingsData>"), 0666)
+	os.Setenv(SettingsEnvironment, environmentFile)
+	if err := settings.Read(); err != nil || settings.SpoolHorizontalDistance_MM != 800 {
+		t.Error("Expected the file from the environment, got", settingsFile, settings.SpoolHorizontalDistance_MM, err)
+	}
+
+	SettingsPath = filepath.Join(dir, "missing.xml")
+	if err := settings.Read(); err == nil || !strings.Contains(err.Error(), "missing.xml") {
+		t.Error("Expected an error for a missing -config file, got", err)
+	}
+}
+
+// Overrides set any field by name and recalculate the derived fields
+func TestSettingOverrides(t *testing.T) {
+	setupTestSettings()
+	settings := Settings
+
+	var overrides SettingOverrides
+	if err := overrides.Set("SpoolHorizontalDistance_MM"); err == nil {
+		t.Error("Expected an error without a value")
+	}
+	overrides.Set("SpoolHorizontalDistance_MM=1200")
+	overrides.Set("Transport=tcp")
+	if err := overrides.Apply(&settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.SpoolHorizontalDistance_MM != 1200 || settings.Transport != "tcp" || settings.DrawingSurfaceMaxX_MM != 1175 {
+		t.Error("Expected the overridden settings, got", settings.SpoolHorizontalDistance_MM, settings.Transport, settings.DrawingSurfaceMaxX_MM)
+	}
+
+	overrides = SettingOverrides{"SerialBaud=fast"}
+	if err := overrides.Apply(&settings); err == nil || err.Error() != `-set SerialBaud=fast: field SerialBaud: Expected a whole number but saw "fast"` {
+		t.Error("Expected an error naming the override, got", err)
+	}
+}
+
+// Geometry that can not exist is rejected and names the field
+func TestSettingsValidate(t *testing.T) {
+	setupTestSettings()
+	if err := Settings.Validate(); err != nil {
+		t.Error("Expected the test settings to be valid, got", err)
+	}
+
+	for field, value := range map[string]string{
+		"SpoolHorizontalDistance_MM": "0",
+		"DrawingSurfaceMinX_MM":      "500",
+		"DrawingSurfaceMaxY_MM":      "40",
+		"StartingLeftDist_MM":        "300",
+		"SpoolSingleStep_Degrees":    "-1",
+		"GondolaMass_G":              "-5",
+		"StringThickness_MM":         "1",
+		"GondolaRightAnchorX_MM":     "1000",
+		"Interpolater":               "bumpy",
+		"CorrectionMesh":             "0 0 10 10 2 2, 1 1",
+	} {
+		settings := Settings
+		settings.SetField(field, value)
+		if err := settings.Validate(); err == nil {
+			t.Error("Expected", field, value, "to be invalid")
+		} else if parseErr, ok := err.(*ParseError); !ok || !strings.HasPrefix(parseErr.Location, "field ") {
+			t.Error("Expected an error located at a field, got", err)
+		}
+	}
+}
+
+// Saving the position changes only the starting lengths of the profile that was read
+func TestSavePosition(t *testing.T) {
+	path, restore := useSettingsFile(t, `<GocupiConfig SchemaVersion="2" DefaultProfile="a">
+	<Profile Name="a"><StartingLeftDist_MM>600</StartingLeftDist_MM></Profile>
+	<Profile Name="b"><StartingLeftDist_MM>700</StartingLeftDist_MM></Profile>
+</GocupiConfig>`)
+	defer restore()
+
+	SettingsProfile = "b"
+	if err := Settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePosition(PolarCoordinate{LeftDist: 512.25, RightDist: 640.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved SettingsData
+	if err := saved.Read(); err != nil || saved.StartingLeftDist_MM != 512.25 || saved.StartingRightDist_MM != 640.5 {
+		t.Error("Expected the position to be saved to profile b, got", saved.StartingLeftDist_MM, saved.StartingRightDist_MM, err)
+	}
+	SettingsProfile = "a"
+	saved = SettingsData{}
+	if err := saved.Read(); err != nil || saved.StartingLeftDist_MM != 600 {
+		t.Error("Expected profile a to be unchanged, got", saved.StartingLeftDist_MM, err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected no temporary file to be left, got", err)
+	}
+}
+
+// Saving the position should only change the text of the two elements, keeping the comments and formatting of the file
+func TestSavePositionKeepsComments(t *testing.T) {
+	contents := `<!-- Settings for each plotter -->
+<GocupiConfig SchemaVersion="2" DefaultProfile="a">
+	<Profile Name="a">
+		<!-- Distance from center of left spool to pen -->
+		<StartingLeftDist_MM>600</StartingLeftDist_MM>
+
+		<!-- Spool, one rotation moves the string this amount -->
+		<SpoolCircumference_MM>60.50</SpoolCircumference_MM>
+	</Profile>
+	<Profile Name="b"><StartingLeftDist_MM>700</StartingLeftDist_MM></Profile>
+</GocupiConfig>
+`
+	path, restore := useSettingsFile(t, contents)
+	defer restore()
+
+	if err := Settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePosition(PolarCoordinate{LeftDist: 512.25, RightDist: 640.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Replace(contents, "<StartingLeftDist_MM>600</StartingLeftDist_MM>", "<StartingLeftDist_MM>512.25</StartingLeftDist_MM>", 1)
+	expected = strings.Replace(expected, "\t</Profile>\n\t<Profile", "\t\t<StartingRightDist_MM>640.5</StartingRightDist_MM>\n\t</Profile>\n\t<Profile", 1)
+	if saved, err := ioutil.ReadFile(path); err != nil || string(saved) != expected {
+		t.Error("Expected\n", expected, "\nbut saved\n", string(saved), err)
+	}
+
+	SettingsProfile = "b"
+	if err := Settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePosition(PolarCoordinate{LeftDist: 1, RightDist: 2}); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(saved), `<Profile Name="b"><StartingLeftDist_MM>1</StartingLeftDist_MM><StartingRightDist_MM>2</StartingRightDist_MM></Profile>`) {
+		t.Error("Expected the position to be added to a profile on one line, saved\n", string(saved))
+	}
+}
+
+// Migrating a version 1 file should keep its comments
+func TestSettingsMigrationKeepsComments(t *testing.T) {
+	path, restore := useSettingsFile(t, `<?xml version="1.0"?>
+<!-- Geometry of the plotter -->
+<SettingsData>
+	<!-- Distance between the spools -->
+	<SpoolHorizontalDistance_MM>900</SpoolHorizontalDistance_MM>
+</SettingsData>
+`)
+	defer restore()
+
+	var settings SettingsData
+	if err := settings.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0"?>
+<!-- Geometry of the plotter -->
+<GocupiConfig SchemaVersion="2" DefaultProfile="default">
+	<Profile Name="default">
+		<!-- Distance between the spools -->
+		<SpoolHorizontalDistance_MM>900</SpoolHorizontalDistance_MM>
+	</Profile>
+</GocupiConfig>
+`
+	if migrated, err := ioutil.ReadFile(path); err != nil || string(migrated) != expected {
+		t.Error("Expected\n", expected, "\nbut migrated to\n", string(migrated), err)
+	}
+
+	var again SettingsData
+	if err := again.Read(); err != nil || again.SpoolHorizontalDistance_MM != 900 {
+		t.Error("Expected the migrated file to read the same, got", again.SpoolHorizontalDistance_MM, err)
+	}
+}
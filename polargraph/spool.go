@@ -0,0 +1,80 @@
+package polargraph
+
+// Model of string winding onto the spools in layers, so a step moves more string as the spool fills up instead of a fixed StepSize_MM
+
+import (
+	"math"
+)
+
+// Converts between the length of a string and the position of its motor. As string winds on, the radius it winds at grows
+// by a string thickness every layer, which is spread evenly over the turns of the layer
+type SpoolModel struct {
+	StepAngle    float64 // radians the spool turns in a single step
+	CoreRadius   float64 // radius to the middle of the string when the first turn is wound onto the core
+	Growth       float64 // increase in radius for each radian wound, 0 when the radius never changes
+	StringLength float64 // length of the string when none of it is wound onto the spool
+}
+
+// Create the model described by settings, a spool with a fixed StepSize_MM unless the string thickness, core diameter,
+// layer width and string length are all set
+func SpoolModelFromSettings() *SpoolModel {
+	stepAngle := Settings.SpoolSingleStep_Degrees * math.Pi / 180
+	if !Settings.LayeredSpools() {
+		return &SpoolModel{
+			StepAngle:  stepAngle,
+			CoreRadius: Settings.StepSize_MM / stepAngle,
+		}
+	}
+
+	turnsPerLayer := math.Max(Settings.SpoolLayerWidth_MM/Settings.StringThickness_MM, 1)
+	return &SpoolModel{
+		StepAngle:    stepAngle,
+		CoreRadius:   (Settings.SpoolCoreDiameter_MM + Settings.StringThickness_MM) / 2,
+		Growth:       Settings.StringThickness_MM / (2 * math.Pi * turnsPerLayer),
+		StringLength: Settings.StringLength_MM,
+	}
+}
+
+// Angle the spool has turned winding on the given length of string, from the integral of the radius over the angle
+func (spool *SpoolModel) woundAngle(wound float64) float64 {
+	if spool.Growth == 0 || wound <= 0 {
+		return wound / spool.CoreRadius
+	}
+	return (math.Sqrt(spool.CoreRadius*spool.CoreRadius+2*spool.Growth*wound) - spool.CoreRadius) / spool.Growth
+}
+
+// Length of string wound on after the spool has turned the given angle
+func (spool *SpoolModel) woundLength(angle float64) float64 {
+	if angle <= 0 {
+		return angle * spool.CoreRadius
+	}
+	return angle*spool.CoreRadius + spool.Growth*angle*angle/2
+}
+
+// Position of the motor in steps when the string is the given length, steps increase as the string lets out
+func (spool *SpoolModel) Steps(length float64) float64 {
+	return -spool.woundAngle(spool.StringLength-length) / spool.StepAngle
+}
+
+// Length of the string when the motor is at the given position in steps
+func (spool *SpoolModel) Length(steps float64) float64 {
+	return spool.StringLength - spool.woundLength(-steps*spool.StepAngle)
+}
+
+// Fixed point steps each motor makes to change the string lengths from from to to
+func (spool *SpoolModel) StepsBetween(from, to PolarCoordinate) PolarCoordinate {
+	return PolarCoordinate{
+		LeftDist:  (spool.Steps(to.LeftDist) - spool.Steps(from.LeftDist)) * StepsFixedPointFactor,
+		RightDist: (spool.Steps(to.RightDist) - spool.Steps(from.RightDist)) * StepsFixedPointFactor,
+		PenUp:     to.PenUp,
+	}
+}
+
+// String lengths after each motor makes the given fixed point steps starting from position
+func (spool *SpoolModel) Moved(position, steps PolarCoordinate) PolarCoordinate {
+	return PolarCoordinate{
+		LeftDist:  spool.Length(spool.Steps(position.LeftDist) + steps.LeftDist/StepsFixedPointFactor),
+		RightDist: spool.Length(spool.Steps(position.RightDist) + steps.RightDist/StepsFixedPointFactor),
+		PenUp:     position.PenUp,
+	}
+}
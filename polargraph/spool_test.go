@@ -0,0 +1,60 @@
+package polargraph
+
+import (
+	"math"
+	"testing"
+)
+
+// Without a spool model every step moves StepSize_MM, the same as before spools were modelled
+func TestSpoolModelFixedStepSize(t *testing.T) {
+	setupTestSettings()
+	spool := SpoolModelFromSettings()
+
+	steps := spool.StepsBetween(PolarCoordinate{LeftDist: 600, RightDist: 600}, PolarCoordinate{LeftDist: 610, RightDist: 590})
+	expected := 10 * StepsFixedPointFactor / Settings.StepSize_MM
+	if math.Abs(steps.LeftDist-expected) > 1e-9 || math.Abs(steps.RightDist+expected) > 1e-9 {
+		t.Error("Expected", expected, "steps, got", steps)
+	}
+}
+
+// A step moves more string the more is wound onto the spool, and converting back gives the same length
+func TestSpoolModelLayers(t *testing.T) {
+	setupTestSettings()
+	Settings.StringThickness_MM = 0.5
+	Settings.SpoolCoreDiameter_MM = 20
+	Settings.SpoolLayerWidth_MM = 5
+	Settings.StringLength_MM = 3000
+	Settings.CalculateDerivedFields()
+	spool := SpoolModelFromSettings()
+
+	if expected := math.Pi * 20.5 * 0.225 / 360; math.Abs(Settings.StepSize_MM-expected) > 1e-9 {
+		t.Error("Expected the smallest step size", expected, "got", Settings.StepSize_MM)
+	}
+
+	// a step on a nearly empty spool moves less string than a step on a full one
+	stepSize := func(length float64) float64 {
+		return spool.Length(spool.Steps(length)+1) - length
+	}
+	if empty, full := stepSize(2990), stepSize(500); empty >= full {
+		t.Error("Expected a step to move more string on a full spool, got", empty, "empty and", full, "full")
+	}
+
+	// a layer is 10 turns, the diameter grows from 20.5 to 21.5 as it winds on so the turns average 21
+	turn := 2 * math.Pi / spool.StepAngle
+	layer := spool.Length(spool.Steps(3000) - 10*turn)
+	if expected := 3000 - 10*math.Pi*21; math.Abs(layer-expected) > 1e-6 {
+		t.Error("Expected", expected, "after winding a layer, got", layer)
+	}
+
+	for _, length := range []float64{3000, 2500, 800, 100} {
+		if back := spool.Length(spool.Steps(length)); math.Abs(back-length) > 1e-9 {
+			t.Error("Expected", length, "got", back)
+		}
+	}
+
+	start := PolarCoordinate{LeftDist: 600, RightDist: 700}
+	moved := spool.Moved(start, PolarCoordinate{LeftDist: 320, RightDist: -320})
+	if back := spool.StepsBetween(start, moved); math.Abs(back.LeftDist-320) > 1e-6 || math.Abs(back.RightDist+320) > 1e-6 {
+		t.Error("Expected 320 and -320 steps, got", back)
+	}
+}
@@ -0,0 +1,143 @@
+package polargraph
+
+// Statistics collected while generating step data, used to check a job before sending it to the stepper driver
+
+import (
+	"fmt"
+	"time"
+)
+
+// Maximum number of out of bounds coordinates that are kept, the rest are only counted
+const maxOutOfBoundsKept = 100
+
+// A coordinate that is outside of the drawing surface and will be clipped
+type OutOfBoundsCoordinate struct {
+	// Index of the coordinate in the job
+	Index int
+
+	// Position relative to the job's 0,0
+	Coordinate Coordinate
+
+	// Position relative to the left motor, which is compared to the DrawingSurface settings
+	Surface Coordinate
+}
+
+// Collected by the StepGenerator as it generates step data
+type StepStats struct {
+	// Number of coordinates in the job
+	Coordinates int
+
+	// Number of coordinates outside of the drawing surface
+	OutOfBoundsCount int
+
+	// The first maxOutOfBoundsKept coordinates outside of the drawing surface
+	OutOfBounds []OutOfBoundsCoordinate
+
+	// Number of slices where a spool would have had to move more than StepsMaxValue, so the movement falls behind the planned path
+	ClampedSlices int64
+
+	// Distance moved with the pen down
+	DrawDistance_MM float64
+
+	// Distance moved with the pen up
+	TravelDistance_MM float64
+
+	// Number of times the pen is raised
+	PenLifts int
+
+	// Number of times the pen is raised or lowered
+	PenTransitions int
+
+	// Number of move slices
+	Slices int64
+
+	// Number of the move slices spent with the pen up
+	TravelSlices int64
+
+	// String lengths at the end of the job
+	FinalPosition PolarCoordinate
+
+	// Position relative to the job's 0,0 at the end of the job
+	FinalCoordinate Coordinate
+}
+
+// Time it would take the stepper driver to execute the job
+func (stats *StepStats) Time() time.Duration {
+	return time.Duration(float64(stats.Slices)*TimeSlice_US+float64(stats.PenTransitions)*PenTransitionCooldown_US) * time.Microsecond
+}
+
+// Time spent moving with the pen up
+func (stats *StepStats) TravelTime() time.Duration {
+	return time.Duration(float64(stats.TravelSlices)*TimeSlice_US) * time.Microsecond
+}
+
+// Time spent moving with the pen down
+func (stats *StepStats) DrawTime() time.Duration {
+	return time.Duration(float64(stats.Slices-stats.TravelSlices)*TimeSlice_US) * time.Microsecond
+}
+
+// True if nothing was found that would stop the job being drawn as intended
+func (stats *StepStats) Ok() bool {
+	return stats.OutOfBoundsCount == 0 && stats.ClampedSlices == 0
+}
+
+// Record a coordinate the generator is about to move to from origin
+func (stats *StepStats) addCoordinate(index int, origin, target Coordinate, system PolarSystem) {
+	stats.Coordinates++
+
+	distance := target.Minus(origin).Len()
+	if target.Curve != nil {
+		distance = NewCurvePath(target.Curve).Length()
+	}
+	if target.PenUp {
+		stats.TravelDistance_MM += distance
+	} else {
+		stats.DrawDistance_MM += distance
+	}
+
+	if _, inBounds := target.ToPolarClipped(system); !inBounds {
+		stats.OutOfBoundsCount++
+		if len(stats.OutOfBounds) < maxOutOfBoundsKept {
+			stats.OutOfBounds = append(stats.OutOfBounds, OutOfBoundsCoordinate{
+				Index:      index,
+				Coordinate: target,
+				Surface:    Coordinate{X: target.X + system.XOffset, Y: target.Y + system.YOffset, PenUp: target.PenUp},
+			})
+		}
+	}
+}
+
+// Output a report of the statistics
+func (stats *StepStats) Print() {
+	fmt.Println()
+	fmt.Println("Coordinates:", stats.Coordinates)
+	fmt.Printf("Drawn: %.1f mm Travelled: %.1f mm\n", stats.DrawDistance_MM, stats.TravelDistance_MM)
+	fmt.Println("Pen Lifts:", stats.PenLifts, "Pen Transitions:", stats.PenTransitions)
+	fmt.Println("Slices:", stats.Slices, "Estimated Time:", stats.Time())
+	fmt.Println("Drawing Time:", stats.DrawTime(), "Travel Time:", stats.TravelTime())
+	fmt.Println("Final Position:", stats.FinalCoordinate, "Polar", stats.FinalPosition)
+
+	if stats.ClampedSlices > 0 {
+		fmt.Println()
+		fmt.Println("WARNING:", stats.ClampedSlices, "slices needed more than", StepsMaxValue, "steps and were clamped, the pen will lag behind the path, try a lower speed or -slowfactor")
+	}
+
+	if stats.OutOfBoundsCount > 0 {
+		fmt.Println()
+		fmt.Println("WARNING:", stats.OutOfBoundsCount, "coordinates are outside of the drawing surface and will be clipped")
+		fmt.Printf("Drawing surface is X %.1f to %.1f, Y %.1f to %.1f from the left motor\n", Settings.DrawingSurfaceMinX_MM, Settings.DrawingSurfaceMaxX_MM, Settings.DrawingSurfaceMinY_MM, Settings.DrawingSurfaceMaxY_MM)
+		for _, outOfBounds := range stats.OutOfBounds {
+			fmt.Println("  Index", outOfBounds.Index, "at", outOfBounds.Coordinate, "is", outOfBounds.Surface, "from the left motor")
+		}
+		if stats.OutOfBoundsCount > len(stats.OutOfBounds) {
+			fmt.Println("  and", stats.OutOfBoundsCount-len(stats.OutOfBounds), "more")
+		}
+	}
+
+	fmt.Println()
+	if stats.Ok() {
+		fmt.Println("OK")
+	} else {
+		fmt.Println("Problems found")
+	}
+}
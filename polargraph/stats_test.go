@@ -0,0 +1,53 @@
+package polargraph
+
+// Tests for the statistics collected while generating steps
+
+import (
+	"math"
+	"testing"
+)
+
+// Stats should count distances, pen lifts and coordinates outside of the drawing surface
+func TestStepStats(t *testing.T) {
+	setupTestSettings()
+
+	plotCoords := make(chan Coordinate, 10)
+	plotCoords <- Coordinate{X: 0, Y: 0}
+	plotCoords <- Coordinate{X: 30, Y: 0}
+	plotCoords <- Coordinate{X: 30, Y: 40, PenUp: true}
+	plotCoords <- Coordinate{X: 0, Y: -1000}
+	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
+	close(plotCoords)
+
+	generator := NewStepGenerator()
+	generator.Stats = new(StepStats)
+	stepData := make(chan int8, 1024)
+	go generator.Generate(plotCoords, stepData)
+	for range stepData {
+	}
+	stats := generator.Stats
+
+	if stats.Coordinates != 5 || stats.PenLifts != 2 || stats.PenTransitions != 4 {
+		t.Error("Expected 5 coordinates, 2 lifts and 4 transitions and saw", stats.Coordinates, stats.PenLifts, stats.PenTransitions)
+	}
+	if stats.OutOfBoundsCount != 1 || stats.OutOfBounds[0].Index != 3 {
+		t.Error("Expected coordinate 3 to be out of bounds and saw", stats.OutOfBounds)
+	}
+	// the out of bounds move is clipped at the top of the drawing surface, so the return travel is from there
+	start := generator.Origin.ToCoord(PolarSystemFromSettings())
+	if expected := 40 + start.Y - Settings.DrawingSurfaceMinY_MM; math.Abs(stats.TravelDistance_MM-expected) > 1 {
+		t.Error("Expected travel of", expected, "and saw", stats.TravelDistance_MM)
+	}
+	if stats.FinalCoordinate.Len() > 1 {
+		t.Error("Expected to finish at 0,0 and saw", stats.FinalCoordinate)
+	}
+	if stats.DrawDistance_MM < 30 {
+		t.Error("Expected at least 30 mm drawn and saw", stats.DrawDistance_MM)
+	}
+	if stats.Slices == 0 || stats.Time() == 0 || stats.Ok() {
+		t.Error("Expected slices, a time estimate and problems to be reported", stats)
+	}
+	if stats.TravelSlices == 0 || stats.TravelSlices >= stats.Slices {
+		t.Error("Expected some but not all slices to be travel and saw", stats.TravelSlices, "of", stats.Slices)
+	}
+}
@@ -5,6 +5,7 @@ package polargraph
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -16,6 +17,7 @@ import (
 
 // Used to decode xml data into a readable struct
 type Path struct {
+	Id    string `xml:"id,attr"`
 	Style string `xml:"style,attr"`
 	Data  string `xml:"d,attr"`
 }
@@ -48,6 +50,20 @@ const (
 	ClosePath
 	LineToAbsolute
 	LineToRelative
+	HorizontalLineToAbsolute
+	HorizontalLineToRelative
+	VerticalLineToAbsolute
+	VerticalLineToRelative
+	CurveToAbsolute
+	CurveToRelative
+	SmoothCurveToAbsolute
+	SmoothCurveToRelative
+	QuadraticCurveToAbsolute
+	QuadraticCurveToRelative
+	SmoothQuadraticCurveToAbsolute
+	SmoothQuadraticCurveToRelative
+	ArcToAbsolute
+	ArcToRelative
 )
 
 // PathCommand ToString
@@ -65,6 +81,34 @@ func (command PathCommand) String() string {
 		return "LineToAbsolute"
 	case LineToRelative:
 		return "LineToRelative"
+	case HorizontalLineToAbsolute:
+		return "HorizontalLineToAbsolute"
+	case HorizontalLineToRelative:
+		return "HorizontalLineToRelative"
+	case VerticalLineToAbsolute:
+		return "VerticalLineToAbsolute"
+	case VerticalLineToRelative:
+		return "VerticalLineToRelative"
+	case CurveToAbsolute:
+		return "CurveToAbsolute"
+	case CurveToRelative:
+		return "CurveToRelative"
+	case SmoothCurveToAbsolute:
+		return "SmoothCurveToAbsolute"
+	case SmoothCurveToRelative:
+		return "SmoothCurveToRelative"
+	case QuadraticCurveToAbsolute:
+		return "QuadraticCurveToAbsolute"
+	case QuadraticCurveToRelative:
+		return "QuadraticCurveToRelative"
+	case SmoothQuadraticCurveToAbsolute:
+		return "SmoothQuadraticCurveToAbsolute"
+	case SmoothQuadraticCurveToRelative:
+		return "SmoothQuadraticCurveToRelative"
+	case ArcToAbsolute:
+		return "ArcToAbsolute"
+	case ArcToRelative:
+		return "ArcToRelative"
 	}
 	return "UNKNOWN"
 }
@@ -72,7 +116,8 @@ func (command PathCommand) String() string {
 // True if the given PathCommand is relative
 func (command PathCommand) IsRelative() bool {
 	switch command {
-	case MoveToRelative, LineToRelative:
+	case MoveToRelative, LineToRelative, HorizontalLineToRelative, VerticalLineToRelative, CurveToRelative,
+		SmoothCurveToRelative, QuadraticCurveToRelative, SmoothQuadraticCurveToRelative, ArcToRelative:
 		return true
 	default:
 		return false
@@ -94,6 +139,34 @@ func ParseCommand(commandString string) PathCommand {
 		return LineToAbsolute
 	case "l":
 		return LineToRelative
+	case "H":
+		return HorizontalLineToAbsolute
+	case "h":
+		return HorizontalLineToRelative
+	case "V":
+		return VerticalLineToAbsolute
+	case "v":
+		return VerticalLineToRelative
+	case "C":
+		return CurveToAbsolute
+	case "c":
+		return CurveToRelative
+	case "S":
+		return SmoothCurveToAbsolute
+	case "s":
+		return SmoothCurveToRelative
+	case "Q":
+		return QuadraticCurveToAbsolute
+	case "q":
+		return QuadraticCurveToRelative
+	case "T":
+		return SmoothQuadraticCurveToAbsolute
+	case "t":
+		return SmoothQuadraticCurveToRelative
+	case "A":
+		return ArcToAbsolute
+	case "a":
+		return ArcToRelative
 	default:
 		return NotAValidCommand
 	}
@@ -114,6 +187,10 @@ type PathParser struct {
 	// Track current position for relative moves
 	currentPosition Coordinate
 
+	// Last control point of the previous segment and the command that drew it, reflected by the smooth curve commands
+	lastControl        Coordinate
+	lastControlCommand PathCommand
+
 	// Used to apply a scale factor to all coordinates
 	scaleX float64
 	scaleY float64
@@ -143,50 +220,83 @@ func NewParser(originalPathData string, scaleX, scaleY float64) (parser *PathPar
 }
 
 // Parse the data
-func (this *PathParser) Parse() []Coordinate {
+func (this *PathParser) Parse() ([]Coordinate, error) {
 
-	for this.ReadCommand() {
+	for {
+		more, err := this.ReadCommand()
+		if err != nil {
+			return nil, err
+		} else if !more {
+			break
+		}
 
 		switch this.currentCommand {
 		case MoveToAbsolute, MoveToRelative:
-			this.ReadCoord(true)
+			if err := this.ReadCoord(true); err != nil {
+				return nil, err
+			}
 			for this.PeekHasMoreArguments() { // can have multiple implicit line coords
-				this.ReadCoord(false)
+				if err := this.ReadCoord(false); err != nil {
+					return nil, err
+				}
 			}
 
 		case LineToAbsolute, LineToRelative:
 			for this.PeekHasMoreArguments() {
-				this.ReadCoord(false)
+				if err := this.ReadCoord(false); err != nil {
+					return nil, err
+				}
+			}
+
+		case HorizontalLineToAbsolute, HorizontalLineToRelative, VerticalLineToAbsolute, VerticalLineToRelative,
+			CurveToAbsolute, CurveToRelative, SmoothCurveToAbsolute, SmoothCurveToRelative,
+			QuadraticCurveToAbsolute, QuadraticCurveToRelative, SmoothQuadraticCurveToAbsolute, SmoothQuadraticCurveToRelative,
+			ArcToAbsolute, ArcToRelative:
+			for this.PeekHasMoreArguments() {
+				if err := this.ReadSegment(); err != nil {
+					return nil, err
+				}
 			}
 
 		case ClosePath:
+			if len(this.coordinates) == 0 {
+				return nil, this.errorAt(this.tokenIndex-1, "Close path before any coordinates")
+			}
 			firstPosition := this.coordinates[0]
-			this.currentPosition = Coordinate{X: firstPosition.X, Y: firstPosition.Y, PenUp: false}
-			this.coordinates = append(this.coordinates, this.currentPosition.ScaledBoth(this.scaleX, this.scaleY))
+			this.appendSegment(Coordinate{X: firstPosition.X / this.scaleX, Y: firstPosition.Y / this.scaleY}, nil, Coordinate{})
 
 		default:
-			panic(fmt.Sprint("Unsupported command:", this.currentCommand))
+			return nil, this.errorAt(this.tokenIndex-1, "Unsupported command %v", this.currentCommand)
 		}
 	}
 
-	return this.coordinates
+	return this.coordinates, nil
+}
+
+// Create an error located at the given token, tokens are numbered from 1 in the message
+func (this *PathParser) errorAt(tokenIndex int, format string, args ...interface{}) error {
+	location := fmt.Sprint("token ", tokenIndex+1)
+	if tokenIndex < len(this.tokens) {
+		location = fmt.Sprintf("token %d %q", tokenIndex+1, this.tokens[tokenIndex])
+	}
+	return parseErrorf(location, format, args...)
 }
 
-// Move to next token
-func (this *PathParser) ReadCommand() bool {
+// Move to next token, returns false when there are no more tokens
+func (this *PathParser) ReadCommand() (bool, error) {
 
 	if this.tokenIndex >= len(this.tokens) {
-		return false
+		return false, nil
 	}
 
 	commandString := this.tokens[this.tokenIndex]
 	this.tokenIndex++
 	this.currentCommand = ParseCommand(commandString)
 	if this.currentCommand == NotAValidCommand {
-		panic(fmt.Sprint("Unexpected command, saw ", commandString))
+		return false, this.errorAt(this.tokenIndex-1, "Expected a supported path command, one of M L H V C S Q T A or Z in either case")
 	}
 
-	return true
+	return true, nil
 }
 
 // Return if the next token is a command or not
@@ -199,54 +309,254 @@ func (this *PathParser) PeekHasMoreArguments() bool {
 }
 
 // Read two strings as a pair of doubles
-func (this *PathParser) ReadCoord(penUp bool) {
+func (this *PathParser) ReadCoord(penUp bool) error {
 
 	if this.tokenIndex >= len(this.tokens)-1 {
-		panic(fmt.Sprint("Not enough tokens to ReadCoord, at ", this.tokenIndex, " of ", len(this.tokens)))
+		return this.errorAt(this.tokenIndex, "Expected an x y pair but the path ended")
+	}
+
+	position, err := this.ReadPoint()
+	if err != nil {
+		return err
+	}
+	position.PenUp = penUp
+	this.appendSegment(position, nil, Coordinate{})
+	return nil
+}
+
+// Read a single number
+func (this *PathParser) ReadNumber() (float64, error) {
+
+	if this.tokenIndex >= len(this.tokens) {
+		return 0, this.errorAt(this.tokenIndex, "Expected a number but the path ended")
 	}
 
 	number := this.tokens[this.tokenIndex]
 	this.tokenIndex++
-	x, err := strconv.ParseFloat(number, 64)
+	value, err := strconv.ParseFloat(number, 64)
 	if err != nil {
-		panic(fmt.Sprint("Expected a parseable number, but saw", number, "which got parse error", err))
+		return 0, this.errorAt(this.tokenIndex-1, "Expected a number")
 	}
+	return value, nil
+}
 
-	number = this.tokens[this.tokenIndex]
-	this.tokenIndex++
-	y, err := strconv.ParseFloat(number, 64)
+// Read two numbers as a point, relative commands are offset by the current position
+func (this *PathParser) ReadPoint() (Coordinate, error) {
+
+	x, err := this.ReadNumber()
+	if err != nil {
+		return Coordinate{}, err
+	}
+	y, err := this.ReadNumber()
 	if err != nil {
-		panic(fmt.Sprint("Expected a parseable number, but saw", number, "which got parse error", err))
+		return Coordinate{}, err
 	}
 
 	if this.currentCommand.IsRelative() {
 		x += this.currentPosition.X
 		y += this.currentPosition.Y
 	}
+	return Coordinate{X: x, Y: y}, nil
+}
+
+// Read the arguments of one horizontal, vertical, curve or arc segment of the current command
+func (this *PathParser) ReadSegment() error {
 
-	this.currentPosition = Coordinate{X: x, Y: y, PenUp: penUp}
-	this.coordinates = append(this.coordinates, this.currentPosition.ScaledBoth(this.scaleX, this.scaleY))
+	start := this.currentPosition
+	start.PenUp = false
+
+	switch this.currentCommand {
+	case HorizontalLineToAbsolute, HorizontalLineToRelative, VerticalLineToAbsolute, VerticalLineToRelative:
+		value, err := this.ReadNumber()
+		if err != nil {
+			return err
+		}
+		end := start
+		horizontal := this.currentCommand == HorizontalLineToAbsolute || this.currentCommand == HorizontalLineToRelative
+		switch {
+		case horizontal && this.currentCommand.IsRelative():
+			end.X += value
+		case horizontal:
+			end.X = value
+		case this.currentCommand.IsRelative():
+			end.Y += value
+		default:
+			end.Y = value
+		}
+		this.appendSegment(end, nil, Coordinate{})
+
+	case CurveToAbsolute, CurveToRelative, SmoothCurveToAbsolute, SmoothCurveToRelative:
+		control1 := this.reflectedControl(CurveToAbsolute, CurveToRelative, SmoothCurveToAbsolute, SmoothCurveToRelative)
+		if this.currentCommand == CurveToAbsolute || this.currentCommand == CurveToRelative {
+			var err error
+			if control1, err = this.ReadPoint(); err != nil {
+				return err
+			}
+		}
+		control2, err := this.ReadPoint()
+		if err != nil {
+			return err
+		}
+		end, err := this.ReadPoint()
+		if err != nil {
+			return err
+		}
+		this.appendSegment(end, &CubicBezier{Start: start, Control1: control1, Control2: control2, End: end}, control2)
+
+	case QuadraticCurveToAbsolute, QuadraticCurveToRelative, SmoothQuadraticCurveToAbsolute, SmoothQuadraticCurveToRelative:
+		control := this.reflectedControl(QuadraticCurveToAbsolute, QuadraticCurveToRelative, SmoothQuadraticCurveToAbsolute, SmoothQuadraticCurveToRelative)
+		if this.currentCommand == QuadraticCurveToAbsolute || this.currentCommand == QuadraticCurveToRelative {
+			var err error
+			if control, err = this.ReadPoint(); err != nil {
+				return err
+			}
+		}
+		end, err := this.ReadPoint()
+		if err != nil {
+			return err
+		}
+		this.appendSegment(end, NewQuadraticBezier(start, control, end), control)
+
+	case ArcToAbsolute, ArcToRelative:
+		var values [5]float64
+		for index := range values {
+			var err error
+			if values[index], err = this.ReadNumber(); err != nil {
+				return err
+			}
+		}
+		end, err := this.ReadPoint()
+		if err != nil {
+			return err
+		}
+		arc := svgArc(start, end, values[0], values[1], values[2], values[3] != 0, values[4] != 0)
+		if arc == nil {
+			this.appendSegment(end, nil, Coordinate{})
+		} else {
+			this.appendSegment(end, arc, Coordinate{})
+		}
+
+	default:
+		return this.errorAt(this.tokenIndex, "Unsupported command %v", this.currentCommand)
+	}
+	return nil
+}
+
+// First control point of a smooth curve, the reflection of the previous control point when the previous segment was one of the given commands, otherwise the current position
+func (this *PathParser) reflectedControl(commands ...PathCommand) Coordinate {
+	current := this.currentPosition
+	current.PenUp = false
+	for _, command := range commands {
+		if this.lastControlCommand == command {
+			return current.Add(current.Minus(this.lastControl))
+		}
+	}
+	return current
+}
+
+// Move the current position to position, following curve if it is not nil, and remember control for the smooth curve commands.
+// Points are kept unscaled for relative commands, the coordinate added and its curve are scaled
+func (this *PathParser) appendSegment(position Coordinate, curve Curve, control Coordinate) {
+	this.currentPosition = position
+	this.lastControl = control
+	this.lastControlCommand = this.currentCommand
+
+	scale := func(point Coordinate) Coordinate { return point.ScaledBoth(this.scaleX, this.scaleY) }
+	coord := scale(position)
+	if curve != nil {
+		coord.Curve = curve.Transformed(scale)
+	}
+	this.coordinates = append(this.coordinates, coord)
+}
+
+// Arc from start to end as described by the svg arc command, nil if it should be drawn as a straight line.
+// Converts from the endpoint form svg uses to a center and angles as described in the svg implementation notes
+func svgArc(start, end Coordinate, radiusX, radiusY, rotationDegrees float64, largeArc, sweep bool) *EllipticalArc {
+	radiusX, radiusY = math.Abs(radiusX), math.Abs(radiusY)
+	if radiusX == 0 || radiusY == 0 || start.Minus(end).Len() == 0 {
+		return nil
+	}
+
+	sin, cos := math.Sincos(rotationDegrees * math.Pi / 180)
+
+	// start relative to the middle of the chord, in the ellipse's unrotated frame
+	halfX, halfY := (start.X-end.X)/2, (start.Y-end.Y)/2
+	x := cos*halfX + sin*halfY
+	y := -sin*halfX + cos*halfY
+
+	// radii too small to reach are scaled up until they just do
+	if lambda := x*x/(radiusX*radiusX) + y*y/(radiusY*radiusY); lambda > 1 {
+		radiusX *= math.Sqrt(lambda)
+		radiusY *= math.Sqrt(lambda)
+	}
+
+	numerator := radiusX*radiusX*radiusY*radiusY - radiusX*radiusX*y*y - radiusY*radiusY*x*x
+	denominator := radiusX*radiusX*y*y + radiusY*radiusY*x*x
+	factor := math.Sqrt(math.Max(numerator, 0) / denominator)
+	if largeArc == sweep {
+		factor = -factor
+	}
+	centerX := factor * radiusX * y / radiusY
+	centerY := -factor * radiusY * x / radiusX
+
+	startAngle := math.Atan2((y-centerY)/radiusY, (x-centerX)/radiusX)
+	endAngle := math.Atan2((-y-centerY)/radiusY, (-x-centerX)/radiusX)
+	sweepAngle := endAngle - startAngle
+	if sweep && sweepAngle < 0 {
+		sweepAngle += 2 * math.Pi
+	} else if !sweep && sweepAngle > 0 {
+		sweepAngle -= 2 * math.Pi
+	}
+
+	return &EllipticalArc{
+		Center: Coordinate{
+			X: cos*centerX - sin*centerY + (start.X+end.X)/2,
+			Y: sin*centerX + cos*centerY + (start.Y+end.Y)/2,
+		},
+		Major:      Coordinate{X: radiusX * cos, Y: radiusX * sin},
+		Minor:      Coordinate{X: -radiusY * sin, Y: radiusY * cos},
+		StartAngle: startAngle,
+		Sweep:      sweepAngle,
+	}
 }
 
 // read a file
-func ParseSvgFile(fileName string) (data []Coordinate) {
+func ParseSvgFile(fileName string) (data []Coordinate, err error) {
 	file, err := os.Open(fileName)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer file.Close()
 
-	return ParseSvg(file)
+	data, err = ParseSvg(file)
+	return data, wrapParseError(err, fileName, "")
+}
+
+// Parse the path data of a path element, errors are located by the path's id or its index in the file
+func parsePathElement(path Path, pathIndex int, scaleX, scaleY float64) ([]Coordinate, error) {
+	coords, err := NewParser(path.Data, scaleX, scaleY).Parse()
+	if err != nil {
+		location := fmt.Sprint("path ", pathIndex)
+		if path.Id != "" {
+			location = fmt.Sprintf("path %q", path.Id)
+		}
+		return nil, wrapParseError(err, "", location)
+	}
+	return coords, nil
 }
 
 // read svg xml data
-func ParseSvg(svgData io.Reader) (data []Coordinate) {
+func ParseSvg(svgData io.Reader) (data []Coordinate, err error) {
 
 	data = make([]Coordinate, 0)
+	pathIndex := 0
 	decoder := xml.NewDecoder(svgData)
 	for {
-		t, _ := decoder.Token()
-		if t == nil {
+		t, err := decoder.Token()
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			return nil, wrapParseError(err, "", "")
 		}
 
 		switch se := t.(type) {
@@ -254,12 +564,20 @@ func ParseSvg(svgData io.Reader) (data []Coordinate) {
 
 			if se.Name.Local == "path" {
 				var pathData Path
-				decoder.DecodeElement(&pathData, &se)
-				parser := NewParser(pathData.Data, 1, 1)
-				data = append(data, parser.Parse()...)
+				if err := decoder.DecodeElement(&pathData, &se); err != nil {
+					return nil, wrapParseError(err, "", "")
+				}
+				coords, err := parsePathElement(pathData, pathIndex, 1, 1)
+				if err != nil {
+					return nil, err
+				}
+				pathIndex++
+				data = append(data, coords...)
 			} else if se.Name.Local == "g" {
 				var groupData Group
-				decoder.DecodeElement(&groupData, &se)
+				if err := decoder.DecodeElement(&groupData, &se); err != nil {
+					return nil, wrapParseError(err, "", "")
+				}
 
 				var transformX, transformY, scaleX, scaleY float64
 				if groupData.Transform != "" && strings.Contains(groupData.Transform, "scale") {
@@ -275,8 +593,12 @@ func ParseSvg(svgData io.Reader) (data []Coordinate) {
 
 				if groupData.Paths != nil {
 					for _, pathElement := range groupData.Paths {
-						parser := NewParser(pathElement.Data, scaleX, scaleY)
-						data = append(data, parser.Parse()...)
+						coords, err := parsePathElement(pathElement, pathIndex, scaleX, scaleY)
+						if err != nil {
+							return nil, err
+						}
+						pathIndex++
+						data = append(data, coords...)
 					}
 				}
 			}
@@ -284,33 +606,37 @@ func ParseSvg(svgData io.Reader) (data []Coordinate) {
 	}
 
 	if len(data) == 0 {
-		panic("SVG contained no Path elements! Only Paths are supported")
+		return nil, errors.New("SVG contained no Path elements! Only Paths are supported")
 	}
 
-	return data
+	return data, nil
 }
 
 // read a file
-func ParseSvgFileCircle(fileName string) (data []Circle) {
+func ParseSvgFileCircle(fileName string) (data []Circle, err error) {
 	//fmt.Println("filename",fileName)
 	file, err := os.Open(fileName)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer file.Close()
 
-	return ParseSvgCircle(file)
+	data, err = ParseSvgCircle(file)
+	return data, wrapParseError(err, fileName, "")
 }
 
 // read svg xml data
-func ParseSvgCircle(svgData io.Reader) (data []Circle) {
+func ParseSvgCircle(svgData io.Reader) (data []Circle, err error) {
 
 	data = make([]Circle, 0)
 	decoder := xml.NewDecoder(svgData)
 	for {
-		t, _ := decoder.Token()
+		t, err := decoder.Token()
 		//fmt.Println("token: ",t)
-		if t == nil {
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			return nil, wrapParseError(err, "", "")
 		}
 
 		switch se := t.(type) {
@@ -320,13 +646,17 @@ func ParseSvgCircle(svgData io.Reader) (data []Circle) {
 
 			if se.Name.Local == "circle" {
 				var stippleData Stipple
-				decoder.DecodeElement(&stippleData, &se)
+				if err := decoder.DecodeElement(&stippleData, &se); err != nil {
+					return nil, wrapParseError(err, "", "")
+				}
 
 				data = append(data)
 			} else if se.Name.Local == "g" {
 
 				var groupData GroupStipple
-				decoder.DecodeElement(&groupData, &se)
+				if err := decoder.DecodeElement(&groupData, &se); err != nil {
+					return nil, wrapParseError(err, "", "")
+				}
 				//fmt.Println("group data:",groupData)
 
 				var transformX, transformY, scaleX, scaleY float64
@@ -366,6 +696,10 @@ func ParseSvgCircle(svgData io.Reader) (data []Circle) {
 		} //end switch
 	}
 
+	if len(data) == 0 {
+		return nil, errors.New("SVG contained no Circle elements! Only Circle are supported")
+	}
+
 	//search id with "start" tag in it
 	initialPositionIndex := 0
 	for index, point := range data {
@@ -380,13 +714,31 @@ func ParseSvgCircle(svgData io.Reader) (data []Circle) {
 		dataSorted = append(dataSorted, curTarget)
 	}
 	fmt.Println("initialPositionIndex: ", initialPositionIndex, "len(data): ", len(data), "len(dataSorted): ", len(dataSorted))
-	fmt.Println("initialPosition: ", dataSorted[initialPositionIndex], "nextPosition: ", dataSorted[initialPositionIndex+1])
+	fmt.Println("initialPosition: ", dataSorted[0])
 
-	if len(dataSorted) == 0 {
-		panic("SVG contained no Circle elements! Only Circle are supported")
-	}
 	//fmt.Println("[]Circles",data)
-	return dataSorted
+	return dataSorted, nil
+}
+
+// Scale that makes the svg size wide, or size on its longest side when fitWidth is false.
+// Returns an error if the scaled svg does not fit on the drawing surface
+func SvgScale(data Coordinates, size float64, fitWidth bool) (float64, error) {
+	minPoint, maxPoint := data.Extents()
+
+	imageSize := maxPoint.Minus(minPoint)
+	scale := size / math.Max(imageSize.X, imageSize.Y)
+	if fitWidth {
+		scale = size / imageSize.X
+	}
+
+	if imageSize.X*scale > (Settings.DrawingSurfaceMaxX_MM-Settings.DrawingSurfaceMinX_MM) || imageSize.Y*scale > (Settings.DrawingSurfaceMaxY_MM-Settings.DrawingSurfaceMinY_MM) {
+		return 0, errors.New(fmt.Sprint(
+			"SVG coordinates extend past drawable surface, as defined in setup. Scaled svg size was: ",
+			imageSize.Scaled(scale),
+			" And settings bounds are, X: ", Settings.DrawingSurfaceMaxX_MM, " - ", Settings.DrawingSurfaceMinX_MM,
+			" Y: ", Settings.DrawingSurfaceMaxY_MM, " - ", Settings.DrawingSurfaceMinY_MM))
+	}
+	return scale, nil
 }
 
 // Send svg path points to channel
@@ -397,18 +749,13 @@ func GenerateSvgCenterPath(data Coordinates, size float64, plotCoords chan<- Coo
 	minPoint, maxPoint := data.Extents()
 
 	imageSize := maxPoint.Minus(minPoint)
-	scale := size / imageSize.X
+	scale, err := SvgScale(data, size, true)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println("SVG Min:", minPoint, "Max:", maxPoint, "Scale:", scale)
 
-	if imageSize.X*scale > (Settings.DrawingSurfaceMaxX_MM-Settings.DrawingSurfaceMinX_MM) || imageSize.Y*scale > (Settings.DrawingSurfaceMaxY_MM-Settings.DrawingSurfaceMinY_MM) {
-		panic(fmt.Sprint(
-			"SVG coordinates extend past drawable surface, as defined in setup. Scaled svg size was: ",
-			imageSize,
-			" And settings bounds are, X: ", Settings.DrawingSurfaceMaxX_MM, " - ", Settings.DrawingSurfaceMinX_MM,
-			" Y: ", Settings.DrawingSurfaceMaxY_MM, " - ", Settings.DrawingSurfaceMinY_MM))
-	}
-
 	// want to center the image horizontally, so need actual world space location of gondola at start
 	polarSystem := PolarSystemFromSettings()
 	previousPolarPos := PolarCoordinate{LeftDist: Settings.StartingLeftDist_MM, RightDist: Settings.StartingRightDist_MM}
@@ -424,7 +771,9 @@ func GenerateSvgCenterPath(data Coordinates, size float64, plotCoords chan<- Coo
 
 	for index := 0; index < len(data); index++ {
 		curTarget := data[index]
-		plotCoords <- curTarget.Minus(minPoint).Scaled(scale).Minus(centeringOffset)
+		plotCoords <- curTarget.Transformed(func(point Coordinate) Coordinate {
+			return point.Minus(minPoint).Scaled(scale).Minus(centeringOffset)
+		})
 	}
 
 	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
@@ -437,19 +786,13 @@ func GenerateSvgBoxPath(data Coordinates, size float64, plotCoords chan<- Coordi
 
 	minPoint, maxPoint := data.Extents()
 
-	imageSize := maxPoint.Minus(minPoint)
-	scale := size / math.Max(imageSize.X, imageSize.Y)
+	scale, err := SvgScale(data, size, false)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println("SVG Min:", minPoint, "Max:", maxPoint, "Scale:", scale)
 
-	if imageSize.X*scale > (Settings.DrawingSurfaceMaxX_MM-Settings.DrawingSurfaceMinX_MM) || imageSize.Y*scale > (Settings.DrawingSurfaceMaxY_MM-Settings.DrawingSurfaceMinY_MM) {
-		panic(fmt.Sprint(
-			"SVG coordinates extend past drawable surface, as defined in setup. Scaled svg size was: ",
-			imageSize,
-			" And settings bounds are, X: ", Settings.DrawingSurfaceMaxX_MM, " - ", Settings.DrawingSurfaceMinX_MM,
-			" Y: ", Settings.DrawingSurfaceMaxY_MM, " - ", Settings.DrawingSurfaceMinY_MM))
-	}
-
 	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
 	plotCoords <- Coordinate{X: 0, Y: 10, PenUp: true}
 	plotCoords <- Coordinate{X: 0, Y: maxPoint.Y - minPoint.Y, PenUp: true}.Scaled(scale)
@@ -459,7 +802,7 @@ func GenerateSvgBoxPath(data Coordinates, size float64, plotCoords chan<- Coordi
 
 	for index := 0; index < len(data); index++ {
 		curTarget := data[index]
-		plotCoords <- curTarget.Minus(minPoint).Scaled(scale)
+		plotCoords <- curTarget.Transformed(func(point Coordinate) Coordinate { return point.Minus(minPoint).Scaled(scale) })
 	}
 
 	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
@@ -472,19 +815,13 @@ func GenerateSvgTopPath(data Coordinates, size float64, plotCoords chan<- Coordi
 
 	minPoint, maxPoint := data.Extents()
 
-	imageSize := maxPoint.Minus(minPoint)
-	scale := size / math.Max(imageSize.X, imageSize.Y)
+	scale, err := SvgScale(data, size, false)
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Println("SVG Min:", minPoint, "Max:", maxPoint, "Scale:", scale)
 
-	if imageSize.X*scale > (Settings.DrawingSurfaceMaxX_MM-Settings.DrawingSurfaceMinX_MM) || imageSize.Y*scale > (Settings.DrawingSurfaceMaxY_MM-Settings.DrawingSurfaceMinY_MM) {
-		panic(fmt.Sprint(
-			"SVG coordinates extend past drawable surface, as defined in setup. Scaled svg size was: ",
-			imageSize,
-			" And settings bounds are, X: ", Settings.DrawingSurfaceMaxX_MM, " - ", Settings.DrawingSurfaceMinX_MM,
-			" Y: ", Settings.DrawingSurfaceMaxY_MM, " - ", Settings.DrawingSurfaceMinY_MM))
-	}
-
 	// find top most svg point, so that the path can start there	244		// find minPoint of coordinates, which will be upper left, where the pen will start
 	initialPositionIndex := 0
 	initialPosition := Coordinate{X: 100000.0, Y: 100000.0}
@@ -500,7 +837,7 @@ func GenerateSvgTopPath(data Coordinates, size float64, plotCoords chan<- Coordi
 
 	for index := 0; index < len(data); index++ {
 		curTarget := data[(index+initialPositionIndex)%len(data)]
-		plotCoords <- curTarget.Minus(initialPosition).Scaled(scale)
+		plotCoords <- curTarget.Transformed(func(point Coordinate) Coordinate { return point.Minus(initialPosition).Scaled(scale) })
 	}
 
 	plotCoords <- Coordinate{X: 0, Y: 0, PenUp: true}
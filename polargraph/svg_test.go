@@ -28,7 +28,10 @@ func TestSVGScale(t *testing.T) {
 </svg>`
 
 	var expectedResult, result []Coordinate
-	result = ParseSvg(strings.NewReader(svgText))
+	result, err := ParseSvg(strings.NewReader(svgText))
+	if err != nil {
+		t.Error("Expected no error, got", err)
+	}
 
 	expectedResult = []Coordinate{
 		Coordinate{X: 213, Y: -152, PenUp: true},
@@ -45,6 +48,7 @@ func TestSVGPath(t *testing.T) {
 
 	var p *PathParser
 	var expectedResult, result []Coordinate
+	var err error
 
 	p = NewParser("M364.51173 507.85272L364.283279688 508.984281094L363.660275 509.90832375", 1, 1)
 	expectedResult = []Coordinate{
@@ -52,7 +56,9 @@ func TestSVGPath(t *testing.T) {
 		Coordinate{X: 364.283279688, Y: 508.984281094, PenUp: false},
 		Coordinate{X: 363.660275, Y: 509.90832375, PenUp: false},
 	}
-	result = p.Parse()
+	if result, err = p.Parse(); err != nil {
+		t.Error("Expected no error, got", err)
+	}
 	assertAreEqual(expectedResult, result, t)
 
 	p = NewParser("m 213,152 3,0 2,0 3,0 2,2", 1, 1)
@@ -63,7 +69,9 @@ func TestSVGPath(t *testing.T) {
 		Coordinate{X: 221, Y: 152, PenUp: false},
 		Coordinate{X: 223, Y: 154, PenUp: false},
 	}
-	result = p.Parse()
+	if result, err = p.Parse(); err != nil {
+		t.Error("Expected no error, got", err)
+	}
 	assertAreEqual(expectedResult, result, t)
 
 	p = NewParser("m 213,152 3,0 2,0 3,0 2,2     z", 1, 1)
@@ -75,7 +83,9 @@ func TestSVGPath(t *testing.T) {
 		Coordinate{X: 223, Y: 154, PenUp: false},
 		Coordinate{X: 213, Y: 152, PenUp: false},
 	}
-	result = p.Parse()
+	if result, err = p.Parse(); err != nil {
+		t.Error("Expected no error, got", err)
+	}
 	assertAreEqual(expectedResult, result, t)
 }
 
@@ -92,3 +102,19 @@ func assertAreEqual(expected, actual []Coordinate, t *testing.T) {
 		}
 	}
 }
+
+// Svgs scaled past the drawing surface should be an error instead of a panic
+func TestSVGScaleBounds(t *testing.T) {
+	setupTestSettings()
+	data := Coordinates{Coordinate{X: 0, Y: 0}, Coordinate{X: 10, Y: 5}}
+
+	if scale, err := SvgScale(data, 100, false); err != nil || scale != 10 {
+		t.Error("Expected a scale of 10, got", scale, err)
+	}
+	if scale, err := SvgScale(Coordinates{Coordinate{X: 0, Y: 0}, Coordinate{X: 5, Y: 10}}, 100, true); err != nil || scale != 20 {
+		t.Error("Expected a scale of 20 fitting the width, got", scale, err)
+	}
+	if _, err := SvgScale(data, 10*Settings.DrawingSurfaceMaxX_MM, false); err == nil {
+		t.Error("Expected an svg larger than the drawing surface to be refused")
+	}
+}
@@ -0,0 +1,290 @@
+package polargraph
+
+// Handles opening the connection to the stepper driver and the request / reply handshake used over it
+
+import (
+	"errors"
+	"fmt"
+	serial "github.com/tarm/goserial"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Byte stream connection to the stepper driver
+type Transport interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// Supported Transport types
+const (
+	SerialTransport = "serial"
+	TcpTransport    = "tcp"
+	UnixTransport   = "unix"
+	PipeTransport   = "pipe"
+)
+
+// Open the transport defined in settings
+func OpenTransportFromSettings() (Transport, error) {
+	return OpenTransport(Settings.Transport, Settings.TransportAddress, Settings.SerialBaud)
+}
+
+// Open a transport of the given type, address is a device path for serial (/dev/ttyUSB0), host:port for tcp,
+// a socket path for unix, and for pipe the base path of two named pipes, steps are written to address.in and requests read from address.out.
+// The emulator type runs a StepperEmulator in process, with an address of realtime or fast
+func OpenTransport(transportType, address string, baud int) (Transport, error) {
+
+	switch strings.ToLower(transportType) {
+	case SerialTransport, "":
+		return serial.OpenPort(&serial.Config{Name: address, Baud: baud})
+
+	case TcpTransport:
+		return net.Dial("tcp", address)
+
+	case UnixTransport:
+		return net.Dial("unix", address)
+
+	case PipeTransport:
+		// opened read/write so that neither side blocks waiting for the other to open the fifo
+		writer, err := os.OpenFile(address+".in", os.O_RDWR, 0)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := os.OpenFile(address+".out", os.O_RDWR, 0)
+		if err != nil {
+			writer.Close()
+			return nil, err
+		}
+		return &pipeTransport{reader: reader, writer: writer}, nil
+
+	case EmulatorTransport:
+		transport, _ := StartEmulator(strings.ToLower(address) == "realtime")
+		return transport, nil
+	}
+
+	return nil, errors.New(fmt.Sprint("Unknown transport type ", transportType, ", expected serial, tcp, unix, pipe or emulator"))
+}
+
+// Parse a transport specified as type:address, such as serial:/dev/ttyUSB0 or tcp:localhost:2000
+func ParseTransportSpec(spec string) (transportType, address string, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", errors.New(fmt.Sprint("Expected transport in the form type:address and saw ", spec))
+	}
+	return strings.ToLower(parts[0]), parts[1], nil
+}
+
+// A pair of named pipes used as a single Transport
+type pipeTransport struct {
+	reader *os.File
+	writer *os.File
+}
+
+func (pipe *pipeTransport) Read(data []byte) (int, error) {
+	return pipe.reader.Read(data)
+}
+
+func (pipe *pipeTransport) Write(data []byte) (int, error) {
+	return pipe.writer.Write(data)
+}
+
+func (pipe *pipeTransport) Close() error {
+	readErr := pipe.reader.Close()
+	if err := pipe.writer.Close(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// Time to wait for the stepper driver to report its constants, firmware that does not support the handshake is assumed after this
+var HandshakeTimeout = 2 * time.Second
+
+// Time to wait for an arduino that was reset by opening its serial port to finish booting, it requests data once setup is done.
+// The reset bytes would be lost in the bootloader if they were sent earlier
+var BootTimeout = 5 * time.Second
+
+// Returned when reading times out
+var errReadTimeout = errors.New("Timed out waiting for the stepper driver")
+
+// Connection to the stepper driver, the driver requests a number of bytes and the host replies with that many bytes of step data
+type StepperConnection struct {
+	transport Transport
+
+	// Constants the stepper driver reported, or the host's values if it did not report any
+	Firmware FirmwareInfo
+
+	// True if the stepper driver reported its constants
+	FirmwareReported bool
+
+	// bytes read from the transport, closed after readErr is set
+	incoming chan byte
+	readErr  error
+
+	// data request read during the handshake that has not been returned by WaitForRequest, 0 if there is none
+	pendingRequest int
+
+	// buffer to use during communication
+	writeData []byte
+}
+
+// Open the transport defined in settings and reset the stepper driver
+func OpenStepperConnection() (*StepperConnection, error) {
+
+	transport, err := OpenTransportFromSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(Settings.Transport) {
+	case SerialTransport, "":
+		return newStepperConnection(transport, BootTimeout)
+	}
+	return NewStepperConnection(transport)
+}
+
+// Reset the stepper driver listening on the other end of the given transport and adopt the constants it reports
+func NewStepperConnection(transport Transport) (*StepperConnection, error) {
+	return newStepperConnection(transport, 0)
+}
+
+// Create a connection that first waits up to bootTimeout for the stepper driver to request data before resetting it
+func newStepperConnection(transport Transport, bootTimeout time.Duration) (*StepperConnection, error) {
+
+	conn := &StepperConnection{
+		transport: transport,
+		incoming:  make(chan byte, 256),
+		writeData: make([]byte, 128),
+	}
+	go conn.readLoop()
+
+	err := conn.waitForBoot(bootTimeout)
+	if err == nil {
+		err = conn.handshake()
+	}
+	if err == nil {
+		err = conn.Firmware.Adopt()
+	}
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Read from the transport until it is closed or fails
+func (conn *StepperConnection) readLoop() {
+	buffer := make([]byte, 64)
+	for {
+		n, err := conn.transport.Read(buffer)
+		for _, value := range buffer[:n] {
+			conn.incoming <- value
+		}
+		if err != nil {
+			conn.readErr = err
+			close(conn.incoming)
+			return
+		}
+	}
+}
+
+// Read the next byte, returns errReadTimeout if timeout fires first, a nil timeout waits forever
+func (conn *StepperConnection) readByte(timeout <-chan time.Time) (byte, error) {
+	select {
+	case value, open := <-conn.incoming:
+		if !open {
+			return 0, conn.readErr
+		}
+		return value, nil
+	case <-timeout:
+		return 0, errReadTimeout
+	}
+}
+
+// Wait for the first data request from a stepper driver that is still booting, the request is dropped since the handshake resets the driver.
+// A driver that was not reset may already be waiting on a request it sent earlier, so the handshake goes ahead after the timeout
+func (conn *StepperConnection) waitForBoot(bootTimeout time.Duration) error {
+	if bootTimeout <= 0 {
+		return nil
+	}
+
+	_, err := conn.readByte(time.After(bootTimeout))
+	if err == errReadTimeout {
+		return nil
+	}
+	return err
+}
+
+// Reset the stepper driver and ask for its constants.
+// Data requests read before the constants were sent before the reset and are dropped,
+// firmware without the handshake only sends data requests so the last one is kept for WaitForRequest
+func (conn *StepperConnection) handshake() error {
+
+	// send a -128 to force the arduino to restart and rerequest data, firmware that supports the handshake replies to the second one with its constants
+	if _, err := conn.transport.Write([]byte{ResetCommand, ResetCommand}); err != nil {
+		return err
+	}
+
+	timeout := time.After(HandshakeTimeout)
+	for {
+		value, err := conn.readByte(timeout)
+		if err == errReadTimeout {
+			conn.Firmware = HostFirmwareInfo()
+			fmt.Println("Stepper driver did not report its constants, assuming", conn.Firmware)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if value != CapabilitiesHeader {
+			conn.pendingRequest = int(value)
+			continue
+		}
+
+		data := make([]byte, capabilitiesSize)
+		for index := range data {
+			if data[index], err = conn.readByte(timeout); err != nil {
+				return errors.New(fmt.Sprint("Stepper driver did not finish reporting its constants, ", err))
+			}
+		}
+		conn.Firmware = decodeFirmwareInfo(data)
+		conn.FirmwareReported = true
+		conn.pendingRequest = 0
+		return nil
+	}
+}
+
+// Wait for the next data request, returns the buffer that must be filled and passed to Send
+func (conn *StepperConnection) WaitForRequest() ([]byte, error) {
+
+	requested := conn.pendingRequest
+	conn.pendingRequest = 0
+	if requested == 0 {
+		value, err := conn.readByte(nil)
+		if err != nil {
+			return nil, err
+		}
+		requested = int(value)
+	}
+
+	if requested > len(conn.writeData) || requested%2 != 0 {
+		return nil, errors.New(fmt.Sprint("Stepper driver requested an invalid amount of data ", requested))
+	}
+
+	return conn.writeData[:requested], nil
+}
+
+// Send the reply to the last data request
+func (conn *StepperConnection) Send(data []byte) error {
+	_, err := conn.transport.Write(data)
+	return err
+}
+
+// Close the underlying transport
+func (conn *StepperConnection) Close() error {
+	return conn.transport.Close()
+}